@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Caller authentication
+//
+// Any process on the session bus can call the daemon. The methods that see
+// or change what is typed or how the daemon runs (key events, Reset,
+// SetEnabled, GetPreedit, focus, Set, Reload, Stop and SetLogLevel) take the
+// caller's unique name as a dbus.Sender and only answer callers that run as
+// the daemon's user from an allowed executable: the fcitx5 found on PATH at
+// startup and this program by default, see run -allow-callers. Other
+// callers get errAccessDenied. The
+// methods that only report state are open to every caller.
+//
+// The executable is read from /proc/PID/exe for the PID the bus reports.
+// This keeps stray processes from reading or injecting keys; it does not
+// stop a process of the same user that can already ptrace the frontend.
+
+// errAccessDenied is the error name of calls rejected by the allowlist.
+const errAccessDenied = serviceName + ".Error.AccessDenied"
+
+// authCacheSize bounds the verdicts kept. Unique names are never reused on
+// a bus, so a verdict stays valid for as long as it is kept.
+const authCacheSize = 64
+
+// peer is the process behind a connection to the bus.
+type peer struct {
+	uid uint32
+	pid uint32
+	exe string // Executable path, empty when unknown
+}
+
+// authorizer decides which callers may use the restricted methods.
+type authorizer struct {
+	uid     uint32   // The daemon's user
+	allowed []string // Absolute paths, or names matching any directory
+	lookup  func(sender string) (peer, error)
+
+	mu       sync.Mutex
+	verdicts map[string]verdict // By unique name
+}
+
+// verdict is the outcome of checking a caller.
+type verdict struct {
+	peer peer
+	err  error // nil when the caller is allowed
+}
+
+// newAuthorizer allows the executables in allowed to call restricted
+// methods, looking callers up with lookup.
+func newAuthorizer(allowed []string, lookup func(sender string) (peer, error)) *authorizer {
+	return &authorizer{
+		uid:      uint32(os.Getuid()),
+		allowed:  allowed,
+		lookup:   lookup,
+		verdicts: make(map[string]verdict),
+	}
+}
+
+// defaultFrontends are the frontends allowed by default.
+var defaultFrontends = []string{"fcitx5"}
+
+// defaultCallers returns the executables allowed by default: the frontends
+// as lookPath finds them and this program, so that its subcommands can
+// control the daemon. They are absolute paths with the symlinks resolved: a
+// name would let any program of the user in that is copied to a file of
+// that name.
+func defaultCallers(lookPath func(file string) (string, error)) []string {
+	var callers []string
+	for _, name := range defaultFrontends {
+		if path, err := lookPath(name); err == nil && filepath.IsAbs(path) {
+			callers = append(callers, resolvePath(path))
+		}
+	}
+	if exe, err := os.Executable(); err == nil {
+		callers = append(callers, resolvePath(exe))
+	}
+	return callers
+}
+
+// resolvePath returns path with its symlinks resolved, or path when that
+// fails.
+func resolvePath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// parseCallers reads the comma-separated list of -allow-callers. "*" gives
+// nil: every caller is allowed.
+func parseCallers(list string) ([]string, error) {
+	var callers []string
+	for _, c := range strings.Split(list, ",") {
+		c = strings.TrimSpace(c)
+		switch {
+		case c == "*":
+			return nil, nil
+		case c == "":
+		case strings.Contains(c, "/") && !filepath.IsAbs(c):
+			return nil, fmt.Errorf("allowed caller %q: want an absolute path or a name", c)
+		default:
+			callers = append(callers, c)
+		}
+	}
+	if len(callers) == 0 {
+		return nil, errors.New("no allowed callers; use * to allow every caller")
+	}
+	return callers, nil
+}
+
+// check returns nil when sender may call restricted methods, and the peer
+// as far as it was looked up.
+func (a *authorizer) check(sender string) (peer, error) {
+	a.mu.Lock()
+	v, ok := a.verdicts[sender]
+	a.mu.Unlock()
+	if ok {
+		return v.peer, v.err
+	}
+
+	p, err := a.lookup(sender)
+	if err != nil {
+		// Not kept: the bus may answer next time
+		return p, fmt.Errorf("cannot identify the caller: %w", err)
+	}
+	switch {
+	case p.uid != a.uid:
+		err = fmt.Errorf("caller runs as user %d", p.uid)
+	case !a.allows(p.exe):
+		err = fmt.Errorf("caller %s is not an allowed frontend", p.exe)
+	}
+
+	a.mu.Lock()
+	if len(a.verdicts) >= authCacheSize {
+		clear(a.verdicts)
+	}
+	a.verdicts[sender] = verdict{p, err}
+	a.mu.Unlock()
+	return p, err
+}
+
+// allows reports whether exe is in the allowlist.
+func (a *authorizer) allows(exe string) bool {
+	if exe == "" {
+		return false
+	}
+	return slices.ContainsFunc(a.allowed, func(allowed string) bool {
+		if filepath.IsAbs(allowed) {
+			return exe == allowed
+		}
+		return filepath.Base(exe) == allowed
+	})
+}
+
+// busPeer looks callers up with the bus daemon and /proc.
+func busPeer(conn *dbus.Conn) func(sender string) (peer, error) {
+	bus := conn.BusObject()
+	return func(sender string) (peer, error) {
+		var p peer
+		if err := bus.Call("org.freedesktop.DBus.GetConnectionUnixUser", 0, sender).Store(&p.uid); err != nil {
+			return p, err
+		}
+		if err := bus.Call("org.freedesktop.DBus.GetConnectionUnixProcessID", 0, sender).Store(&p.pid); err != nil {
+			return p, err
+		}
+		exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", p.pid))
+		if err != nil {
+			return p, err
+		}
+		// An executable replaced by an update keeps running
+		p.exe = strings.TrimSuffix(exe, " (deleted)")
+		return p, nil
+	}
+}
+
+// authorize rejects calls to the restricted method from callers that are
+// not allowed. Calls are not checked when the daemon has no allowlist.
+func (e *InputEngine) authorize(sender dbus.Sender, method string) *dbus.Error {
+	if e.auth == nil {
+		return nil
+	}
+	p, err := e.auth.check(string(sender))
+	if err == nil {
+		return nil
+	}
+	e.log.Warn("call rejected", "method", method, "sender", string(sender), "pid", p.pid, "exe", p.exe, "err", err)
+	return &dbus.Error{Name: errAccessDenied, Body: []any{err.Error()}}
+}
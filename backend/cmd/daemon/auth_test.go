@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseCallers(t *testing.T) {
+	tests := []struct {
+		list    string
+		callers []string
+		wantErr bool
+	}{
+		{"fcitx5", []string{"fcitx5"}, false},
+		{"fcitx5, /usr/bin/goviet-daemon", []string{"fcitx5", "/usr/bin/goviet-daemon"}, false},
+		{"fcitx5,*", nil, false},
+		{"bin/fcitx5", nil, true},
+		{" , ", nil, true},
+	}
+	for _, tt := range tests {
+		callers, err := parseCallers(tt.list)
+		if !slices.Equal(callers, tt.callers) || (err != nil) != tt.wantErr {
+			t.Errorf("parseCallers(%q) = %q, %v", tt.list, callers, err)
+		}
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	uid := uint32(os.Getuid())
+	peers := map[string]peer{
+		":1.1": {uid, 10, "/usr/bin/fcitx5"},
+		":1.2": {uid, 11, "/usr/bin/goviet-daemon"},
+		":1.3": {uid, 12, "/home/me/bin/goviet-daemon"},
+		":1.4": {uid + 1, 13, "/usr/bin/fcitx5"},
+		":1.5": {uid, 14, "/usr/bin/dbus-send"},
+	}
+	lookups := 0
+	a := newAuthorizer([]string{"fcitx5", "/usr/bin/goviet-daemon"}, func(sender string) (peer, error) {
+		lookups++
+		p, ok := peers[sender]
+		if !ok {
+			return peer{}, errors.New("no such name")
+		}
+		return p, nil
+	})
+
+	for sender, allowed := range map[string]bool{":1.1": true, ":1.2": true, ":1.3": false, ":1.4": false, ":1.5": false, ":1.6": false} {
+		if _, err := a.check(sender); (err == nil) != allowed {
+			t.Errorf("check(%s) = %v, want allowed %v", sender, err, allowed)
+		}
+	}
+
+	// Verdicts are kept, failed lookups are not
+	lookups = 0
+	a.check(":1.1")
+	a.check(":1.5")
+	a.check(":1.6")
+	if lookups != 1 {
+		t.Errorf("%d lookups, want 1", lookups)
+	}
+}
+
+func TestDefaultCallers(t *testing.T) {
+	// fcitx5 on PATH is a symlink to the real executable
+	dir := t.TempDir()
+	real := filepath.Join(dir, "fcitx5-real")
+	if err := os.WriteFile(real, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "fcitx5")
+	if err := os.Symlink(real, link); err != nil {
+		t.Fatal(err)
+	}
+	callers := defaultCallers(func(file string) (string, error) {
+		if file != "fcitx5" {
+			return "", errors.New("not found")
+		}
+		return link, nil
+	})
+	if len(callers) != 2 || callers[0] != real || !filepath.IsAbs(callers[1]) {
+		t.Fatalf("defaultCallers = %q", callers)
+	}
+
+	// Another program copied to a file named fcitx5 is not the frontend
+	uid := uint32(os.Getuid())
+	peers := map[string]peer{
+		":1.1": {uid, 10, real},
+		":1.2": {uid, 11, "/tmp/fcitx5"},
+	}
+	a := newAuthorizer(callers, func(sender string) (peer, error) { return peers[sender], nil })
+	if _, err := a.check(":1.1"); err != nil {
+		t.Errorf("fcitx5 rejected: %v", err)
+	}
+	if _, err := a.check(":1.2"); err == nil {
+		t.Error("/tmp/fcitx5 should be rejected by default")
+	}
+
+	// Without fcitx5 on PATH only this program is allowed
+	none := defaultCallers(func(string) (string, error) { return "", errors.New("not found") })
+	if len(none) != 1 {
+		t.Errorf("defaultCallers without fcitx5 = %q", none)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	e := NewInputEngine(nil)
+	e.auth = newAuthorizer([]string{"fcitx5"}, func(sender string) (peer, error) {
+		return peer{uint32(os.Getuid()), 1, "/usr/bin/" + sender}, nil
+	})
+
+	if _, _, preedit, err := e.ProcessKey("fcitx5", 'a', 0); err != nil || preedit != "a" {
+		t.Fatalf("ProcessKey from fcitx5 = %q, %v", preedit, err)
+	}
+	_, _, _, err := e.ProcessKey("xdotool", 'b', 0)
+	if err == nil || err.Name != errAccessDenied {
+		t.Errorf("ProcessKey from xdotool: err = %v, want %s", err, errAccessDenied)
+	}
+	if _, err := e.GetPreedit("xdotool"); err == nil {
+		t.Error("GetPreedit should be restricted")
+	}
+	if preedit, _ := e.GetPreedit("fcitx5"); preedit != "a" {
+		t.Errorf("preedit = %q: a rejected key reached the engine", preedit)
+	}
+	if mode, err := e.GetMode(); err != nil || mode != "vi" {
+		t.Errorf("GetMode() = %q, %v: it is open to every caller", mode, err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// Batches
+//
+// Macros, paste-to-type and test drivers send many keys at once. ProcessKeys
+// and ProcessText run them through the focused context in one call, holding
+// e.mu throughout so that no other call runs in between, and each key takes
+// the path of a single ProcessKey: the same logs, recording, signals and
+// metrics.
+
+// maxBatchKeys bounds the keys of one batch, which blocks every other call
+// while it runs.
+const maxBatchKeys = 1 << 16
+
+// keyStep is a key event of ProcessKeys, (uu) on the bus.
+type keyStep struct {
+	Keysym    uint32
+	Modifiers uint32
+}
+
+// keyStepResult is the result of one key of ProcessKeys, (bss) on the bus:
+// the values ProcessKey returns.
+type keyStepResult struct {
+	Handled bool
+	Commit  string
+	Preedit string
+}
+
+// ProcessKeys runs key events through the focused context as ProcessKey
+// would, one after the other, and returns a result per key.
+func (e *InputEngine) ProcessKeys(sender dbus.Sender, keys []keyStep) ([]keyStepResult, *dbus.Error) {
+	if err := e.authorize(sender, "ProcessKeys"); err != nil {
+		return nil, err
+	}
+	events := make([]engine.KeyEvent, len(keys))
+	for i, key := range keys {
+		events[i] = engine.KeyEvent{KeySym: key.Keysym, Modifiers: key.Modifiers}
+	}
+
+	results, _, err := e.processKeys("ProcessKeys", events)
+	if err != nil {
+		return nil, err
+	}
+	steps := make([]keyStepResult, len(results))
+	for i, result := range results {
+		steps[i] = keyStepResult{result.Handled, result.CommitText, result.Preedit}
+	}
+	return steps, nil
+}
+
+// ProcessText types text into the focused context, a key per character, and
+// returns the text to insert and the preedit left at the end. Characters
+// the engine does not handle are inserted as they are; newline and tab are
+// typed as Return and Tab and inserted too, also after a word they commit.
+func (e *InputEngine) ProcessText(sender dbus.Sender, text string) (commit string, preedit string, err *dbus.Error) {
+	if err := e.authorize(sender, "ProcessText"); err != nil {
+		return "", "", err
+	}
+	events := make([]engine.KeyEvent, 0, len(text))
+	for _, r := range text {
+		events = append(events, textKey(r))
+	}
+
+	results, commit, err := e.processKeys("ProcessText", events)
+	if err != nil {
+		return "", "", err
+	}
+	if len(results) > 0 {
+		preedit = results[len(results)-1].Preedit
+	}
+	return commit, preedit, nil
+}
+
+// processKeys runs events through the focused context with stepKey and
+// returns their results and the text they insert: what they commit and the
+// characters of the keys the engine does not handle, and of Return and Tab
+// when they commit a word.
+//
+// When the engine panics, the keys after the one that panicked are dropped
+// and the error's commit holds the text to insert instead of the lost
+// replies: what the earlier keys insert, the word typed so far and the
+// characters of the dropped keys.
+func (e *InputEngine) processKeys(method string, events []engine.KeyEvent) ([]engine.ProcessResult, string, *dbus.Error) {
+	if len(events) > maxBatchKeys {
+		return nil, "", dbus.MakeFailedError(fmt.Errorf("%d keys are more than the %d of a batch", len(events), maxBatchKeys))
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	results := make([]engine.ProcessResult, 0, len(events))
+	var typed strings.Builder
+	for i, event := range events {
+		result, err := e.stepKey(method, e.current, event, time.Now())
+		if err != nil {
+			var dropped strings.Builder
+			for _, event := range events[i:] {
+				dropped.WriteString(keyText(event))
+			}
+			err.Body[1] = typed.String() + err.Body[1].(string) + dropped.String()
+			return nil, "", err
+		}
+		typed.WriteString(result.CommitText)
+		if !result.Handled || result.CommitText != "" && (event.KeySym == engine.KeyReturn || event.KeySym == engine.KeyTab) {
+			typed.WriteString(keyText(event))
+		}
+		results = append(results, result)
+	}
+	return results, typed.String(), nil
+}
+
+// textKey returns the key event that types r.
+func textKey(r rune) engine.KeyEvent {
+	switch r {
+	case '\n':
+		return engine.KeyEvent{KeySym: engine.KeyReturn}
+	case '\t':
+		return engine.KeyEvent{KeySym: engine.KeyTab}
+	}
+	return engine.KeyEvent{KeySym: engine.RuneToKeysym(r)}
+}
+
+// keyText returns the text a key inserts when the application gets it:
+// its character, or nothing for releases, shortcuts and other keys.
+func keyText(event engine.KeyEvent) string {
+	if event.Modifiers&(engine.ModRelease|engine.ModControl|engine.ModMod1|engine.ModMod4) != 0 {
+		return ""
+	}
+	switch event.KeySym {
+	case engine.KeyReturn:
+		return "\n"
+	case engine.KeyTab:
+		return "\t"
+	}
+	if r := engine.KeysymToRune(event.KeySym); r != 0 && utf8.ValidRune(r) {
+		return string(r)
+	}
+	return ""
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+func TestProcessKeys_MatchesProcessKey(t *testing.T) {
+	keys := []keyStep{{'V', 0}, {'i', 0}, {'e', 0}, {'e', 0}, {'t', 0}, {'j', 0}, {' ', 0},
+		{'d', 0}, {'d', 0}, {'a', 0}, {engine.KeyBackspace, 0}, {'o', 0}, {engine.KeyReturn, 0},
+		{'a', 0}, {'c', engine.ModControl}, {engine.KeyControlL, engine.ModControl | engine.ModRelease}}
+
+	single, batch := NewInputEngine(nil), NewInputEngine(nil)
+	singleSignals, batchSignals := new(signalRecorder), new(signalRecorder)
+	single.conn, batch.conn = singleSignals, batchSignals
+
+	var want []keyStepResult
+	for _, key := range keys {
+		handled, commit, preedit, err := single.ProcessKey("", key.Keysym, key.Modifiers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, keyStepResult{handled, commit, preedit})
+	}
+	got, err := batch.ProcessKeys("", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ProcessKeys = %v\nProcessKey  = %v", got, want)
+	}
+	if got, want := batchSignals.take(), singleSignals.take(); !slices.Equal(got, want) {
+		t.Errorf("signals\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if got, want := batch.metrics.stats()["keys"], single.metrics.stats()["keys"]; got != want {
+		t.Errorf("keys counted = %v, want %v", got, want)
+	}
+}
+
+func TestProcessText(t *testing.T) {
+	tests := []struct {
+		text, commit, preedit string
+	}{
+		{"", "", ""},
+		{"Vieetj Nam \txin chaof", "Việt Nam \txin ", "chào"},
+		{"ddaay laf. \n", "đây là. \n", ""},
+		{"1 + 1 = 2, ", "1 + 1 = 2, ", ""},
+		{"chaof\nbanj", "chào\n", "bạn"},
+		{"xin chaof\tbanj\n", "xin chào\tbạn\n", ""},
+	}
+	for _, tt := range tests {
+		e := NewInputEngine(nil)
+		commit, preedit, err := e.ProcessText("", tt.text)
+		if err != nil || commit != tt.commit || preedit != tt.preedit {
+			t.Errorf("ProcessText(%q) = %q, %q, %v, want %q, %q", tt.text, commit, preedit, err, tt.commit, tt.preedit)
+		}
+	}
+
+	// In English mode every character goes through as it is
+	e := NewInputEngine(nil)
+	e.SetEnabled("", false)
+	if commit, preedit, _ := e.ProcessText("", "vieetj"); commit != "vieetj" || preedit != "" {
+		t.Errorf("English: ProcessText = %q, %q", commit, preedit)
+	}
+}
+
+func TestProcessText_RecoversFromPanic(t *testing.T) {
+	e := crashingEngine(t)
+	_, _, err := e.ProcessText("", "ab vieets xyz")
+	if err == nil || err.Name != errInternal {
+		t.Fatalf("err = %v, want %s", err, errInternal)
+	}
+	// The text inserted before, the word typed so far and the dropped keys
+	if commit := err.Body[1]; commit != "ab vieets xyz" {
+		t.Errorf("commit = %q", commit)
+	}
+	if preedit, _ := e.GetPreedit(""); preedit != "" {
+		t.Errorf("preedit = %q: keys after the panic reached the engine", preedit)
+	}
+
+	e.current.engine.SetInputMethod(panickyMethod{engine.NewTelexMethod()})
+	results, err := e.ProcessKeys("", []keyStep{{'a', 0}, {'s', 0}, {engine.KeyReturn, 0}})
+	if err == nil || results != nil {
+		t.Fatalf("ProcessKeys = %v, %v", results, err)
+	}
+	if commit := err.Body[1]; commit != "as\n" {
+		t.Errorf("ProcessKeys: commit = %q", commit)
+	}
+}
+
+func TestProcessKeys_Limit(t *testing.T) {
+	e := NewInputEngine(nil)
+	if _, err := e.ProcessKeys("", make([]keyStep, maxBatchKeys+1)); err == nil {
+		t.Error("ProcessKeys should refuse a batch over the limit")
+	}
+	if _, _, err := e.ProcessText("", strings.Repeat("a ", maxBatchKeys/2)); err != nil {
+		t.Errorf("ProcessText at the limit: %v", err)
+	}
+}
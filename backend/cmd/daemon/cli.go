@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Command line
+//
+//	goviet-daemon [run] [flags]      run the daemon (the default)
+//	goviet-daemon status             show the running daemon's state
+//	goviet-daemon reload             reread the configuration file
+//	goviet-daemon set [name=value]   change settings, or list them
+//	goviet-daemon stop               stop the running daemon
+//	goviet-daemon version            show the versions of both
+//	goviet-daemon install-service    write the D-Bus and systemd files
+//
+// Every command but run talks to the running daemon over D-Bus, so the IME
+// can be scripted from a shell.
+
+// command is a subcommand of goviet-daemon.
+type command struct {
+	args string // Arguments, for the usage
+	help string
+	run  func(args []string, stdout, stderr io.Writer) int
+}
+
+// commands lists the subcommands in the order the usage shows them.
+var commands = []struct {
+	name string
+	command
+}{
+	{"run", command{"[flags]", "run the daemon (the default; run -h lists the flags)", runDaemon}},
+	{"status", command{"", "show the running daemon's state", clientCommand(statusCommand)}},
+	{"reload", command{"", "reread the configuration file", clientCommand(reloadCommand)}},
+	{"set", command{"[name=value ...]", "change settings until the next reload, or list them", clientCommand(setCommand)}},
+	{"stop", command{"", "stop the running daemon", clientCommand(stopCommand)}},
+	{"version", command{"", "show the versions of this program and of the running daemon", versionCommand}},
+	{"install-service", command{"[-exec path] [-print]", "write the D-Bus service file and systemd user unit", installServiceCommand}},
+}
+
+// runCommand runs the subcommand named by args[0] and returns the exit
+// status. Without a subcommand, or with flags only, the daemon runs.
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && !isHelp(args[0]) {
+		return runDaemon(args, stdout, stderr)
+	}
+	if isHelp(args[0]) || args[0] == "help" {
+		usage(stdout)
+		return 0
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:], stdout, stderr)
+		}
+	}
+	fmt.Fprintf(stderr, "goviet-daemon: unknown command %q\n\n", args[0])
+	usage(stderr)
+	return 2
+}
+
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+// usage lists the subcommands.
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  goviet-daemon %s %s\t%s\n", c.name, c.args, c.help)
+	}
+	tw.Flush()
+}
+
+// caller calls a method of the running daemon and returns its reply.
+type caller func(method string, args ...any) ([]any, error)
+
+// errNotRunning is returned when no daemon owns the service name.
+var errNotRunning = errors.New("no daemon is running")
+
+// busCaller calls the daemon on the session bus. It never starts one.
+func busCaller(conn *dbus.Conn) caller {
+	obj := conn.Object(serviceName, dbus.ObjectPath(objectPath))
+	return func(method string, args ...any) ([]any, error) {
+		call := obj.Call(interfaceName+"."+method, dbus.FlagNoAutoStart, args...)
+		var dbusErr dbus.Error
+		if errors.As(call.Err, &dbusErr) {
+			switch dbusErr.Name {
+			case "org.freedesktop.DBus.Error.ServiceUnknown", "org.freedesktop.DBus.Error.NameHasNoOwner":
+				return nil, errNotRunning
+			}
+		}
+		return call.Body, call.Err
+	}
+}
+
+// clientCommand turns a command run against the daemon into a subcommand.
+func clientCommand(run func(call caller, args []string, stdout io.Writer) error) func([]string, io.Writer, io.Writer) int {
+	return func(args []string, stdout, stderr io.Writer) int {
+		conn, err := dbus.SessionBus()
+		if err != nil {
+			fmt.Fprintln(stderr, "goviet-daemon: failed to connect to session bus:", err)
+			return 1
+		}
+		defer conn.Close()
+		return reportError(run(busCaller(conn), args, stdout), stderr)
+	}
+}
+
+// usageError is a command used the wrong way.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+// reportError prints err and returns the exit status for it.
+func reportError(err error, stderr io.Writer) int {
+	if err == nil {
+		return 0
+	}
+	fmt.Fprintln(stderr, "goviet-daemon:", err)
+	var usage usageError
+	if errors.As(err, &usage) {
+		return 2
+	}
+	return 1
+}
+
+// getStatus calls GetStatus.
+func getStatus(call caller) (map[string]dbus.Variant, error) {
+	reply, err := call("GetStatus")
+	if err != nil {
+		return nil, err
+	}
+	var status map[string]dbus.Variant
+	if err := dbus.Store(reply, &status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// statusCommand prints the daemon's state.
+func statusCommand(call caller, args []string, stdout io.Writer) error {
+	if len(args) > 0 {
+		return usageError("status takes no arguments")
+	}
+	status, err := getStatus(call)
+	if err != nil {
+		return err
+	}
+
+	str := func(key string) string { s, _ := status[key].Value().(string); return s }
+	enabled := "no (English)"
+	if on, _ := status["enabled"].Value().(bool); on {
+		enabled = "yes (Vietnamese)"
+	}
+	focused := str("focused")
+	ids, _ := status["contexts"].Value().([]string)
+	contexts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		name := id
+		if id == defaultContextID {
+			name = "(default)"
+		}
+		if id == focused {
+			name += " (focused)"
+		}
+		contexts = append(contexts, name)
+	}
+	settings, _ := status["settings"].Value().([]string)
+	configFile := str("config-file")
+	if configFile == "" {
+		configFile = "(none)"
+	}
+
+	fmt.Fprintf(stdout, "goviet-daemon %s\n", str("version"))
+	fmt.Fprintf(stdout, "input method:  %s\n", str("input-method"))
+	fmt.Fprintf(stdout, "output format: %s\n", str("output-format"))
+	fmt.Fprintf(stdout, "enabled:       %s\n", enabled)
+	fmt.Fprintf(stdout, "contexts:      %s\n", strings.Join(contexts, ", "))
+	fmt.Fprintf(stdout, "config file:   %s\n", configFile)
+	fmt.Fprintf(stdout, "settings:      %s\n", strings.Join(settings, " "))
+	return nil
+}
+
+// reloadCommand makes the daemon reread its configuration file.
+func reloadCommand(call caller, args []string, stdout io.Writer) error {
+	if len(args) > 0 {
+		return usageError("reload takes no arguments")
+	}
+	_, err := call("Reload")
+	return err
+}
+
+// setCommand changes settings, or lists them without arguments.
+func setCommand(call caller, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		status, err := getStatus(call)
+		if err != nil {
+			return err
+		}
+		settings, _ := status["settings"].Value().([]string)
+		for _, setting := range settings {
+			fmt.Fprintln(stdout, setting)
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return usageError(fmt.Sprintf("want name=value, got %q", arg))
+		}
+		if _, err := call("Set", name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopCommand stops the daemon.
+func stopCommand(call caller, args []string, stdout io.Writer) error {
+	if len(args) > 0 {
+		return usageError("stop takes no arguments")
+	}
+	_, err := call("Stop")
+	return err
+}
+
+// versionCommand prints this program's version and the running daemon's.
+func versionCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		return reportError(usageError("version takes no arguments"), stderr)
+	}
+	fmt.Fprintf(stdout, "goviet-daemon %s\n", version)
+
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		fmt.Fprintln(stdout, "running daemon: unknown (no session bus)")
+		return 0
+	}
+	defer conn.Close()
+	printDaemonVersion(busCaller(conn), stdout)
+	return 0
+}
+
+// printDaemonVersion prints the version of the running daemon, if any.
+func printDaemonVersion(call caller, stdout io.Writer) {
+	status, err := getStatus(call)
+	switch {
+	case errors.Is(err, errNotRunning):
+		fmt.Fprintln(stdout, "running daemon: none")
+	case err != nil:
+		fmt.Fprintln(stdout, "running daemon: unknown:", err)
+	default:
+		fmt.Fprintf(stdout, "running daemon: %s\n", status["version"].Value())
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// engineCaller calls the methods of e directly, the way busCaller calls a
+// running daemon.
+func engineCaller(e *InputEngine) caller {
+	return func(method string, args ...any) ([]any, error) {
+		var reply []any
+		var err *dbus.Error
+		switch method {
+		case "GetStatus":
+			var status map[string]dbus.Variant
+			status, err = e.GetStatus()
+			reply = []any{status}
+		case "Set":
+			err = e.Set("", args[0].(string), args[1].(string))
+		case "Reload":
+			err = e.Reload("")
+		case "Stop":
+			err = e.Stop("")
+		default:
+			panic("unexpected method " + method)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return reply, nil
+	}
+}
+
+func TestStatusCommand(t *testing.T) {
+	e := NewInputEngine(nil)
+	e.FocusIn("", "editor")
+	var out bytes.Buffer
+	if err := statusCommand(engineCaller(e), nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := `goviet-daemon dev
+input method:  Telex
+output format: Unicode
+enabled:       yes (Vietnamese)
+contexts:      (default), editor (focused)
+config file:   (none)
+settings:      method=Telex format=Unicode rule=new tone= validation=on revert=on wvowel=on caretcommit=off hotkey= modepercontext=off
+`
+	if out.String() != want {
+		t.Errorf("status:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestSetCommand(t *testing.T) {
+	e := NewInputEngine(nil)
+	call := engineCaller(e)
+	var out bytes.Buffer
+	if err := setCommand(call, []string{"method=VNI", "rule=old"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if err := setCommand(call, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "method=VNI\nformat=Unicode\nrule=old\n") {
+		t.Errorf("settings:\n%s", out.String())
+	}
+
+	var usage usageError
+	if err := setCommand(call, []string{"method"}, &out); !errors.As(err, &usage) {
+		t.Errorf("err = %v, want a usage error", err)
+	}
+	if err := setCommand(call, []string{"method=Dvorak"}, &out); err == nil {
+		t.Error("the daemon's error should be returned")
+	}
+}
+
+func TestPrintDaemonVersion(t *testing.T) {
+	var out bytes.Buffer
+	printDaemonVersion(engineCaller(NewInputEngine(nil)), &out)
+	printDaemonVersion(func(string, ...any) ([]any, error) { return nil, errNotRunning }, &out)
+	if want := "running daemon: dev\nrunning daemon: none\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestRunCommand_Usage(t *testing.T) {
+	tests := []struct {
+		args   []string
+		status int
+		output string
+	}{
+		{[]string{"help"}, 0, "goviet-daemon set [name=value ...]"},
+		{[]string{"--help"}, 0, "goviet-daemon stop"},
+		{[]string{"restart"}, 2, `unknown command "restart"`},
+		{[]string{"run", "extra"}, 2, `unexpected argument "extra"`},
+		{[]string{"run", "-log-level", "loud"}, 2, `unknown log level "loud"`},
+		{[]string{"version", "now"}, 2, "version takes no arguments"},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		status := runCommand(tt.args, &stdout, &stderr)
+		if status != tt.status || !strings.Contains(stdout.String()+stderr.String(), tt.output) {
+			t.Errorf("%q: status %d, output:\n%s%s\nwant %d and %q", tt.args, status, stdout.String(), stderr.String(), tt.status, tt.output)
+		}
+	}
+}
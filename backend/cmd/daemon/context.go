@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// defaultContextID is the context used by frontends that never call FocusIn.
+const defaultContextID = ""
+
+// inputContext is the composition state of one frontend input context
+// (one text field or window).
+type inputContext struct {
+	id     string
+	engine *engine.ConfiguredEngine
+
+	// For panic recovery: the word's keystrokes before the current key and
+	// the recent keys, a ring of keyHistory events
+	raw     []rune
+	history [keyHistory]engine.KeyEvent
+	keys    int
+
+	// The preedit and caret last announced by PreeditChanged
+	preedit string
+	cursor  int
+
+	stream *keyStream // Keys sent with SubmitKey, nil before the first
+}
+
+// context returns the context with the given id, creating it if needed.
+// The caller holds e.mu.
+// New contexts start in the current mode unless modes are kept per context.
+func (e *InputEngine) context(id string) *inputContext {
+	if ctx, ok := e.contexts[id]; ok {
+		return ctx
+	}
+
+	ctx := &inputContext{
+		id:     id,
+		engine: engine.NewConfiguredEngine(e.config),
+	}
+	if !e.config.ModePerContext && e.current != nil {
+		ctx.engine.SetEnabled(e.current.engine.IsEnabled())
+	}
+	e.contexts[id] = ctx
+	return ctx
+}
+
+// FocusIn makes the given context the target of subsequent key events.
+func (e *InputEngine) FocusIn(sender dbus.Sender, id string) (err *dbus.Error) {
+	if err := e.authorize(sender, "FocusIn"); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.recoverCall("FocusIn", e.current, nil, &err)
+	before := e.current.engine.Mode()
+	e.current = e.context(id)
+	e.recordEvent(engine.SessionFocusIn, id)
+
+	// Tell indicators when the focused context is in a different mode
+	if mode := e.current.engine.Mode(); mode != before {
+		e.emitModeChanged(e.current, mode)
+	}
+	return nil
+}
+
+// FocusOut clears the composition of a context that lost focus.
+func (e *InputEngine) FocusOut(sender dbus.Sender, id string) (err *dbus.Error) {
+	if err := e.authorize(sender, "FocusOut"); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ctx, ok := e.contexts[id]; ok {
+		defer e.recoverCall("FocusOut", ctx, nil, &err)
+		ctx.engine.Reset()
+		e.syncPreedit(ctx)
+		e.recordEvent(engine.SessionFocusOut, id)
+	}
+	return nil
+}
+
+// DestroyContext forgets a context, including its remembered mode.
+func (e *InputEngine) DestroyContext(sender dbus.Sender, id string) *dbus.Error {
+	if err := e.authorize(sender, "DestroyContext"); err != nil {
+		return err
+	}
+	if id == defaultContextID {
+		return dbus.MakeFailedError(fmt.Errorf("the default context cannot be destroyed"))
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ctx, ok := e.contexts[id]
+	if !ok {
+		return nil
+	}
+	delete(e.contexts, id)
+	if ctx.stream != nil {
+		ctx.stream.stop()
+		ctx.stream = nil
+	}
+	e.recordEvent(engine.SessionDestroy, id)
+	if e.current == ctx {
+		e.current = e.context(defaultContextID)
+	}
+	return nil
+}
+
+// GetMode returns the mode of the focused context ("vi" or "en").
+func (e *InputEngine) GetMode() (mode string, err *dbus.Error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.recoverCall("GetMode", e.current, nil, &err)
+	return e.current.engine.Mode().String(), nil
+}
+
+// syncMode propagates a mode change of ctx to the other contexts when the
+// mode is global, and announces it with the ModeChanged signal.
+func (e *InputEngine) syncMode(ctx *inputContext, before engine.InputMode) {
+	mode := ctx.engine.Mode()
+	if mode == before {
+		return
+	}
+
+	if !e.config.ModePerContext {
+		for _, other := range e.contexts {
+			if other != ctx && other.engine.Mode() != mode {
+				other.engine.SetEnabled(mode == engine.ModeVietnamese)
+				e.syncPreedit(other)
+				e.emitModeChanged(other, mode)
+			}
+		}
+	}
+
+	e.emitModeChanged(ctx, mode)
+}
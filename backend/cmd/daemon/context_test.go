@@ -0,0 +1,92 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// toggle presses the Alt+Z toggle hotkey on the focused context.
+func toggle(e *InputEngine) {
+	e.ProcessKey("", 'z', engine.ModMod1)
+}
+
+func TestContexts_GlobalMode(t *testing.T) {
+	e := NewInputEngine(nil)
+	e.config.ToggleHotkey = "alt+z"
+
+	e.FocusIn("", "editor")
+	toggle(e)
+	e.FocusIn("", "terminal")
+
+	if mode, _ := e.GetMode(); mode != "en" {
+		t.Errorf("terminal mode = %q, want en (mode is global)", mode)
+	}
+	if handled, _, _, _ := e.ProcessKey("", 'a', 0); handled {
+		t.Error("Keys should pass through in English mode")
+	}
+}
+
+func TestContexts_ModePerContext(t *testing.T) {
+	e := NewInputEngine(nil)
+	e.config.ToggleHotkey = "alt+z"
+	e.config.ModePerContext = true
+
+	e.FocusIn("", "terminal")
+	toggle(e)
+	e.FocusIn("", "editor")
+
+	if mode, _ := e.GetMode(); mode != "vi" {
+		t.Errorf("editor mode = %q, want vi", mode)
+	}
+
+	e.FocusIn("", "terminal")
+	if mode, _ := e.GetMode(); mode != "en" {
+		t.Errorf("terminal mode = %q, want en (remembered)", mode)
+	}
+
+	// Forgetting a context also forgets its mode
+	e.FocusIn("", "editor")
+	e.DestroyContext("", "terminal")
+	e.FocusIn("", "terminal")
+	if mode, _ := e.GetMode(); mode != "vi" {
+		t.Errorf("recreated terminal mode = %q, want vi", mode)
+	}
+}
+
+func TestContexts_SeparateComposition(t *testing.T) {
+	e := NewInputEngine(nil)
+
+	e.FocusIn("", "a")
+	e.ProcessKey("", 'v', 0)
+	e.FocusIn("", "b")
+	e.ProcessKey("", 'x', 0)
+	e.FocusIn("", "a")
+
+	if preedit, _ := e.GetPreedit(""); preedit != "v" {
+		t.Errorf("preedit of context a = %q, want v", preedit)
+	}
+
+	e.FocusOut("", "a")
+	if preedit, _ := e.GetPreedit(""); preedit != "" {
+		t.Errorf("preedit after FocusOut = %q, want empty", preedit)
+	}
+
+	if err := e.DestroyContext("", defaultContextID); err == nil {
+		t.Error("Destroying the default context should fail")
+	}
+}
+
+func TestInputEngine_ListRegistered(t *testing.T) {
+	e := NewInputEngine(nil)
+
+	methods, _ := e.ListInputMethods()
+	if !slices.Equal(methods, []string{"Telex", "VIQR", "VNI"}) {
+		t.Errorf("ListInputMethods() = %v, want [Telex VIQR VNI]", methods)
+	}
+	formats, _ := e.ListOutputFormats()
+	if !slices.Equal(formats, []string{"Unicode"}) {
+		t.Errorf("ListOutputFormats() = %v, want [Unicode]", formats)
+	}
+}
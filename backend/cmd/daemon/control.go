@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// Control
+//
+// The subcommands other than run control a running daemon through the
+// methods below: GetStatus, Reload, Set and Stop. Settings use the names of
+// engine.EngineConfig.Set, as in the configuration file.
+
+// version is the daemon's version, set when building a release with
+// -ldflags "-X main.version=1.2.3".
+var version = "dev"
+
+// configPath returns the default configuration file:
+// $XDG_CONFIG_HOME/goviet-ime/config, by default ~/.config/goviet-ime/config.
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "goviet-ime", "config"), nil
+}
+
+// loadConfig reads the configuration file at path. A missing file gives the
+// default configuration unless it is required.
+func loadConfig(path string, required bool) (*engine.EngineConfig, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return engine.DefaultConfig(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config, err := engine.ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// GetStatus describes the running daemon as a versioned a{sv} dictionary.
+//
+//	version        s   daemon version
+//	input-method   s   input method name
+//	output-format  s   output format name
+//	enabled        b   whether the focused context types Vietnamese
+//	focused        s   id of the focused context
+//	contexts       as  ids of every context, sorted
+//	settings       as  every setting as name=value
+//	config-file    s   configuration file read by Reload, empty when none
+func (e *InputEngine) GetStatus() (map[string]dbus.Variant, *dbus.Error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	contexts := make([]string, 0, len(e.contexts))
+	for id := range e.contexts {
+		contexts = append(contexts, id)
+	}
+	slices.Sort(contexts)
+
+	return map[string]dbus.Variant{
+		"version":       dbus.MakeVariant(version),
+		"input-method":  dbus.MakeVariant(e.config.InputMethodName),
+		"output-format": dbus.MakeVariant(e.config.OutputFormatName),
+		"enabled":       dbus.MakeVariant(e.current.engine.IsEnabled()),
+		"focused":       dbus.MakeVariant(e.current.id),
+		"contexts":      dbus.MakeVariant(contexts),
+		"settings":      dbus.MakeVariant(e.config.Settings()),
+		"config-file":   dbus.MakeVariant(e.configFile),
+	}, nil
+}
+
+// Reload reads the configuration file again. The old configuration stays
+// when the file cannot be read.
+func (e *InputEngine) Reload(sender dbus.Sender) *dbus.Error {
+	if err := e.authorize(sender, "Reload"); err != nil {
+		return err
+	}
+	if e.configFile == "" {
+		return dbus.MakeFailedError(errors.New("the daemon was started without a configuration file"))
+	}
+	config, err := loadConfig(e.configFile, e.configRequired)
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	e.setConfig(config)
+	e.signal(objectPath, "ConfigReloaded", e.configFile)
+	e.log.Info("configuration reloaded", "file", e.configFile)
+	return nil
+}
+
+// Set changes one setting, e.g. Set("method", "VNI"), until the daemon
+// exits or reloads its configuration.
+func (e *InputEngine) Set(sender dbus.Sender, name, value string) *dbus.Error {
+	if err := e.authorize(sender, "Set"); err != nil {
+		return err
+	}
+	err := e.updateConfig(func(config *engine.EngineConfig) error {
+		return config.Set(name, value)
+	})
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	e.log.Info("setting changed", "name", name, "value", value)
+	return nil
+}
+
+// stopDelay gives the reply to Stop time to go out before the daemon
+// closes its connection.
+const stopDelay = 100 * time.Millisecond
+
+// Stop shuts the daemon down after replying.
+func (e *InputEngine) Stop(sender dbus.Sender) *dbus.Error {
+	if err := e.authorize(sender, "Stop"); err != nil {
+		return err
+	}
+	e.log.Info("stop requested")
+	time.AfterFunc(stopDelay, func() {
+		select {
+		case e.stop <- struct{}{}:
+		default: // Already stopping
+		}
+	})
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a configuration file and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	config, err := loadConfig(writeConfig(t, "method = VNI\n"), true)
+	if err != nil || config.InputMethodName != "VNI" {
+		t.Errorf("loadConfig() = %+v, %v", config, err)
+	}
+
+	// Only a file named with --config has to exist
+	missing := filepath.Join(t.TempDir(), "config")
+	if config, err := loadConfig(missing, false); err != nil || config.InputMethodName != "Telex" {
+		t.Errorf("missing default file: %+v, %v", config, err)
+	}
+	if _, err := loadConfig(missing, true); err == nil {
+		t.Error("a missing --config file should fail")
+	}
+
+	_, err = loadConfig(writeConfig(t, "rule = newer\n"), true)
+	if err == nil || !strings.Contains(err.Error(), "config: line 1: rule:") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestConfigPath(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/tmp/config")
+	if path, _ := configPath(); path != "/tmp/config/goviet-ime/config" {
+		t.Errorf("configPath() = %q", path)
+	}
+}
+
+func TestGetStatus(t *testing.T) {
+	e := NewInputEngine(nil)
+	e.configFile = "/etc/goviet"
+	e.FocusIn("", "terminal")
+	e.FocusIn("", "editor")
+	e.SetEnabled("", false)
+
+	status, _ := e.GetStatus()
+	want := map[string]any{
+		"version":       version,
+		"input-method":  "Telex",
+		"output-format": "Unicode",
+		"enabled":       false,
+		"focused":       "editor",
+		"config-file":   "/etc/goviet",
+	}
+	for k, v := range want {
+		if got := status[k].Value(); got != v {
+			t.Errorf("%s = %v, want %v", k, got, v)
+		}
+	}
+	if got := status["contexts"].Value().([]string); strings.Join(got, ",") != ",editor,terminal" {
+		t.Errorf("contexts = %q", got)
+	}
+	if got := status["settings"].Value().([]string); got[0] != "method=Telex" {
+		t.Errorf("settings = %q", got)
+	}
+}
+
+func TestSet(t *testing.T) {
+	e := NewInputEngine(nil)
+	shared := e.config
+	if err := e.Set("", "method", "VNI"); err != nil {
+		t.Fatal(err)
+	}
+	e.ProcessKey("", 'a', 0)
+	if _, _, preedit, _ := e.ProcessKey("", '6', 0); preedit != "â" {
+		t.Errorf("preedit = %q, want â", preedit)
+	}
+	if shared.InputMethodName != "Telex" {
+		t.Error("Set should switch to a copy of the config, not change it")
+	}
+
+	if err := e.Set("", "method", "Dvorak"); err == nil {
+		t.Error("Set should reject unknown input methods")
+	}
+	if err := e.Set("", "colour", "red"); err == nil || !strings.Contains(err.Error(), `unknown setting "colour"`) {
+		t.Errorf("err = %v", err)
+	}
+	if e.config.InputMethodName != "VNI" {
+		t.Error("a failed Set should keep the config")
+	}
+}
+
+func TestReload(t *testing.T) {
+	e := NewInputEngine(nil)
+	if err := e.Reload(""); err == nil {
+		t.Error("Reload without a configuration file should fail")
+	}
+
+	e.configFile = writeConfig(t, "method = VNI\n")
+	if err := e.Reload(""); err != nil || e.config.InputMethodName != "VNI" {
+		t.Errorf("Reload() = %v, method %s", err, e.config.InputMethodName)
+	}
+
+	// A broken file keeps the configuration in use
+	os.WriteFile(e.configFile, []byte("method = Dvorak\n"), 0600)
+	if err := e.Reload(""); err == nil || e.config.InputMethodName != "VNI" {
+		t.Errorf("Reload() = %v, method %s", err, e.config.InputMethodName)
+	}
+}
+
+func TestStop(t *testing.T) {
+	e := NewInputEngine(nil)
+	e.Stop("")
+	e.Stop("") // A second Stop while stopping does not block
+	select {
+	case <-e.stop:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not stop the daemon")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// Panic isolation
+//
+// A bug in the engine must not stop typing for the whole desktop. Every D-Bus
+// call that runs the engine recovers from panics: the caller gets an error,
+// the context starts over with a fresh engine, and a crash report with the
+// stack and the redacted recent keys is written to the state directory.
+// For key events the error also carries the keystrokes of the word being
+// typed, so the frontend can commit them and nothing typed is lost.
+
+// errInternal is the D-Bus error name of a recovered panic. Its body is the
+// message and the raw keystrokes to commit, which are empty outside key events.
+const errInternal = serviceName + ".Error.Internal"
+
+// keyHistory is the number of recent keys kept per context for crash reports.
+const keyHistory = 16
+
+// crashReportLimit caps the reports written per run, so an engine that
+// fails on every key cannot fill the disk. Incidents are still counted.
+const crashReportLimit = 10
+
+// remember adds a key event to the context's recent keys.
+func (ctx *inputContext) remember(event engine.KeyEvent) {
+	ctx.history[ctx.keys%keyHistory] = event
+	ctx.keys++
+}
+
+// recentKeys returns the context's recent keys, oldest first.
+func (ctx *inputContext) recentKeys() []engine.KeyEvent {
+	n := min(ctx.keys, keyHistory)
+	keys := make([]engine.KeyEvent, 0, n)
+	for i := ctx.keys - n; i < ctx.keys; i++ {
+		keys = append(keys, ctx.history[i%keyHistory])
+	}
+	return keys
+}
+
+// recoverCall recovers from a panic in the D-Bus call method running on ctx
+// and replaces its error reply. keys are the raw keystrokes to hand back for
+// the frontend to commit. It must be deferred directly.
+func (e *InputEngine) recoverCall(method string, ctx *inputContext, keys []rune, err **dbus.Error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	*err = e.crashed(method, ctx, r, debug.Stack(), string(keys))
+}
+
+// crashed handles a recovered panic: it starts ctx over, counts and reports
+// the incident and returns the error reply.
+func (e *InputEngine) crashed(method string, ctx *inputContext, r any, stack []byte, commit string) *dbus.Error {
+	// The old engine may be left in any state; keep only its mode
+	enabled := ctx.engine.IsEnabled()
+	ctx.engine = engine.NewConfiguredEngine(e.config)
+	ctx.engine.SetEnabled(enabled)
+	e.syncPreedit(ctx)
+
+	incident := e.metrics.countPanic()
+	e.log.Error("recovered from panic", "method", method, "ctx", ctx.id, "panic", r)
+	if e.crashDir != "" && incident <= crashReportLimit {
+		if path, err := e.writeCrashReport(method, ctx, r, stack); err != nil {
+			e.log.Error("failed to write crash report", "err", err)
+		} else {
+			e.log.Error("crash report written", "file", path)
+		}
+	}
+
+	return &dbus.Error{
+		Name: errInternal,
+		Body: []any{fmt.Sprintf("internal error in %s: %v", method, r), commit},
+	}
+}
+
+// writeCrashReport writes a report of a recovered panic to the crash
+// directory and returns its path. Keys are redacted as in the log.
+func (e *InputEngine) writeCrashReport(method string, ctx *inputContext, r any, stack []byte) (string, error) {
+	if err := os.MkdirAll(e.crashDir, 0700); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	f, err := os.CreateTemp(e.crashDir, now.Format("crash-20060102-150405-*.txt"))
+	if err != nil {
+		return "", err
+	}
+
+	var keys []string
+	for _, event := range ctx.recentKeys() {
+		keys = append(keys, redactKey(event))
+	}
+	fmt.Fprintf(f, "goviet-daemon crash report\n\n")
+	fmt.Fprintf(f, "time:          %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(f, "method:        %s\n", method)
+	fmt.Fprintf(f, "context:       %q\n", ctx.id)
+	fmt.Fprintf(f, "panic:         %v\n", r)
+	fmt.Fprintf(f, "input method:  %s\n", e.config.InputMethodName)
+	fmt.Fprintf(f, "output format: %s\n", e.config.OutputFormatName)
+	fmt.Fprintf(f, "recent keys:   %s\n\n", strings.Join(keys, " "))
+	f.Write(stack)
+
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// panickyMethod is Telex with a bug: the tone key s panics.
+type panickyMethod struct{ engine.InputMethod }
+
+func (m panickyMethod) ProcessChar(char rune, current *engine.Syllable) engine.Edit {
+	if char == 's' {
+		var marks []engine.VowelMark
+		_ = marks[len(current.Nucleus)] // index out of range
+	}
+	return m.InputMethod.ProcessChar(char, current)
+}
+
+// crashingEngine returns an engine whose focused context panics on s.
+func crashingEngine(t *testing.T) *InputEngine {
+	t.Helper()
+	e := NewInputEngine(nil)
+	e.crashDir = t.TempDir()
+	e.FocusIn("", "editor")
+	e.current.engine.SetInputMethod(panickyMethod{engine.NewTelexMethod()})
+	return e
+}
+
+func TestProcessKey_RecoversFromPanic(t *testing.T) {
+	e := crashingEngine(t)
+	for _, r := range "Vieet" {
+		e.ProcessKey("", uint32(r), 0)
+	}
+
+	_, _, _, err := e.ProcessKey("", 's', 0)
+	if err == nil {
+		t.Fatal("ProcessKey should fail when the engine panics")
+	}
+	if err.Name != errInternal || len(err.Body) != 2 {
+		t.Fatalf("error = %v %v", err.Name, err.Body)
+	}
+	if !strings.Contains(err.Error(), "internal error in ProcessKey") {
+		t.Errorf("message = %q", err.Error())
+	}
+	// The word typed so far, as typed, for the frontend to commit
+	if commit := err.Body[1]; commit != "Vieet" {
+		t.Errorf("commit = %q, want Vieet", commit)
+	}
+
+	// The context starts over and typing goes on
+	if handled, _, preedit, err := e.ProcessKey("", 'a', 0); err != nil || !handled || preedit != "a" {
+		t.Errorf("after the panic: handled %v, preedit %q, err %v", handled, preedit, err)
+	}
+	if stats, _ := e.GetStats(); stats["panics"].Value() != uint64(1) {
+		t.Errorf("panics = %v, want 1", stats["panics"])
+	}
+}
+
+func TestProcessKey2_RecoversFromPanic(t *testing.T) {
+	e := crashingEngine(t)
+	e.ProcessKey2("", 'a', 0)
+	reply, err := e.ProcessKey2("", 's', 0)
+	if err == nil || reply != nil {
+		t.Fatalf("reply = %v, err = %v", reply, err)
+	}
+	if commit := err.Body[1]; commit != "a" {
+		t.Errorf("commit = %q, want a", commit)
+	}
+}
+
+func TestCrashReport(t *testing.T) {
+	e := crashingEngine(t)
+	keys, _ := engine.ParseKeyScript("<S-m>aa1s")
+	for _, key := range keys {
+		e.ProcessKey("", key.KeySym, key.Modifiers)
+	}
+
+	reports, _ := filepath.Glob(filepath.Join(e.crashDir, "crash-*.txt"))
+	if len(reports) != 1 {
+		t.Fatalf("crash reports: %v", reports)
+	}
+	data, err := os.ReadFile(reports[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := string(data)
+	for _, want := range []string{
+		"method:        ProcessKey\n",
+		`context:       "editor"`,
+		"panic:         runtime error: index out of range",
+		"recent keys:   <S-letter> letter letter digit letter\n",
+		"panickyMethod.ProcessChar", // The stack
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report lacks %q:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "Mâ") {
+		t.Errorf("report contains the text typed:\n%s", report)
+	}
+	if info, err := os.Stat(reports[0]); err == nil && info.Mode().Perm() != 0600 {
+		t.Errorf("report mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestCrashReport_Limit(t *testing.T) {
+	e := crashingEngine(t)
+	for range crashReportLimit + 5 {
+		if _, _, _, err := e.ProcessKey("", 's', 0); err == nil {
+			t.Fatal("ProcessKey should fail")
+		}
+		e.current.engine.SetInputMethod(panickyMethod{engine.NewTelexMethod()})
+	}
+
+	reports, _ := filepath.Glob(filepath.Join(e.crashDir, "crash-*.txt"))
+	if len(reports) != crashReportLimit {
+		t.Errorf("%d crash reports, want %d", len(reports), crashReportLimit)
+	}
+	if stats, _ := e.GetStats(); stats["panics"].Value() != uint64(crashReportLimit+5) {
+		t.Errorf("panics = %v", stats["panics"])
+	}
+}
+
+// panickyFormat is Unicode with a bug: composing anything panics.
+type panickyFormat struct{ engine.OutputFormat }
+
+func (panickyFormat) Compose(*engine.Syllable) string { panic("compose failed") }
+
+func TestRecoverCall(t *testing.T) {
+	// Calls other than key events recover too, with nothing to commit
+	e := NewInputEngine(nil)
+	e.ProcessKey("", 'a', 0)
+	e.current.engine.SetOutputFormat(panickyFormat{engine.NewUnicodeFormat()})
+	preedit, err := e.GetPreedit("")
+	if err == nil || err.Name != errInternal || preedit != "" {
+		t.Fatalf("GetPreedit() = %q, %v", preedit, err)
+	}
+	if commit := err.Body[1]; commit != "" {
+		t.Errorf("commit = %q, want empty", commit)
+	}
+	if preedit, err := e.GetPreedit(""); err != nil || preedit != "" {
+		t.Errorf("after the panic: GetPreedit() = %q, %v", preedit, err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	_ "embed"
+	"slices"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// Interface
+//
+// protocol/com.github.goviet.ime.Engine1.xml defines the D-Bus interface.
+// interface.xml is a copy the daemon embeds and returns from Introspect;
+// go generate refreshes it and TestIntrospection checks that it is current
+// and matches the methods of InputEngine.
+
+//go:generate cp ../../../protocol/com.github.goviet.ime.Engine1.xml interface.xml
+
+//go:embed interface.xml
+var introspection string
+
+// exportEngine serves e on the versioned interface, on the unversioned one
+// of older frontends, and its introspection data.
+func exportEngine(conn *dbus.Conn, e *InputEngine) error {
+	path := dbus.ObjectPath(objectPath)
+	for _, iface := range []string{interfaceName, serviceName} {
+		if err := conn.Export(e, path, iface); err != nil {
+			return err
+		}
+	}
+	return conn.Export(introspect.Introspectable(introspection), path, "org.freedesktop.DBus.Introspectable")
+}
+
+// GetCapabilities tells frontends what the daemon supports, so they can
+// use optional features without guessing from the version.
+//
+//	interface       s   interface name
+//	version         s   daemon version
+//	result-version  u   version of the ProcessKey2 result
+//	features        as  optional features in effect, sorted
+func (e *InputEngine) GetCapabilities() (map[string]dbus.Variant, *dbus.Error) {
+	return map[string]dbus.Variant{
+		"interface":      dbus.MakeVariant(interfaceName),
+		"version":        dbus.MakeVariant(version),
+		"result-version": dbus.MakeVariant(processResultVersion),
+		"features":       dbus.MakeVariant(e.features()),
+	}, nil
+}
+
+// features lists the optional features in effect, as documented in the
+// interface definition.
+func (e *InputEngine) features() []string {
+	e.mu.Lock()
+	config := e.config
+	e.mu.Unlock()
+
+	var features []string
+	if e.auth != nil {
+		features = append(features, "access-control")
+	}
+	features = append(features, "batch-keys")
+	if hotkey, _ := engine.ParseHotkey(config.ToggleHotkey); hotkey.NeedsReleases() {
+		features = append(features, "key-releases")
+	}
+	if !config.CommitOnCaretKeys {
+		features = append(features, "caret-editing")
+	}
+	if config.ModePerContext {
+		features = append(features, "mode-per-context")
+	}
+	features = append(features, "pipelined-keys")
+	if e.signalContent == contentFull {
+		features = append(features, "signal-content")
+	}
+	features = append(features, "state-signals")
+	if config.ToggleHotkey != "" {
+		features = append(features, "toggle-hotkey")
+	}
+	slices.Sort(features)
+	return features
+}
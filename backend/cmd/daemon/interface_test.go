@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+// signature describes the arguments of a method as they appear on the bus.
+func signature(args []introspect.Arg) string {
+	var b strings.Builder
+	for _, arg := range args {
+		b.WriteString(arg.Direction + ":" + arg.Type + " ")
+	}
+	return b.String()
+}
+
+func TestIntrospection(t *testing.T) {
+	protocol, err := os.ReadFile("../../../protocol/com.github.goviet.ime.Engine1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(protocol) != introspection {
+		t.Error("interface.xml differs from protocol/; run go generate ./cmd/daemon/")
+	}
+
+	var node introspect.Node
+	if err := xml.Unmarshal([]byte(introspection), &node); err != nil {
+		t.Fatal(err)
+	}
+	i := slices.IndexFunc(node.Interfaces, func(iface introspect.Interface) bool { return iface.Name == interfaceName })
+	if i < 0 {
+		t.Fatalf("no interface %s", interfaceName)
+	}
+	iface := node.Interfaces[i]
+
+	// Every exported method is defined with the same arguments, and only those
+	defined := make(map[string]string)
+	for _, m := range iface.Methods {
+		defined[m.Name] = signature(m.Args)
+	}
+	for _, m := range introspect.Methods(NewInputEngine(nil)) {
+		want, ok := defined[m.Name]
+		switch {
+		case !ok:
+			t.Errorf("%s is exported but not defined", m.Name)
+		case want != signature(m.Args):
+			t.Errorf("%s: defined as %s, exported as %s", m.Name, want, signature(m.Args))
+		}
+		delete(defined, m.Name)
+	}
+	for name := range defined {
+		t.Errorf("%s is defined but not exported", name)
+	}
+
+	var signals []string
+	for _, s := range iface.Signals {
+		signals = append(signals, s.Name)
+	}
+	if want := []string{"ModeChanged", "PreeditChanged", "Committed", "KeyProcessed", "KeyFailed", "KeysLost", "InputMethodChanged", "ConfigReloaded"}; !slices.Equal(signals, want) {
+		t.Errorf("signals = %q, want %q", signals, want)
+	}
+}
+
+func TestGetCapabilities(t *testing.T) {
+	e := NewInputEngine(nil)
+	capabilities, _ := e.GetCapabilities()
+	if got := capabilities["interface"].Value(); got != "com.github.goviet.ime.Engine1" {
+		t.Errorf("interface = %v", got)
+	}
+	if got := capabilities["result-version"].Value(); got != processResultVersion {
+		t.Errorf("result-version = %v", got)
+	}
+	if got := capabilities["features"].Value().([]string); !slices.Equal(got, []string{"batch-keys", "caret-editing", "pipelined-keys", "state-signals"}) {
+		t.Errorf("default features = %q", got)
+	}
+
+	for _, setting := range []string{"caretcommit=on", "modepercontext=on", "hotkey=ctrl+shift"} {
+		name, value, _ := strings.Cut(setting, "=")
+		if err := e.Set("", name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.auth = newAuthorizer(nil, nil)
+	e.signalContent = contentFull
+	capabilities, _ = e.GetCapabilities()
+	want := []string{"access-control", "batch-keys", "key-releases", "mode-per-context", "pipelined-keys", "signal-content", "state-signals", "toggle-hotkey"}
+	if got := capabilities["features"].Value().([]string); !slices.Equal(got, want) {
+		t.Errorf("features = %q, want %q", got, want)
+	}
+
+	// A chord ending in a regular key needs no releases
+	e = NewInputEngine(nil)
+	e.Set("", "hotkey", "alt+z")
+	capabilities, _ = e.GetCapabilities()
+	if got := capabilities["features"].Value().([]string); slices.Contains(got, "key-releases") || !slices.Contains(got, "toggle-hotkey") {
+		t.Errorf("features with alt+z = %q", got)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// Logging
+//
+// The daemon logs with log/slog and is silent by default. Key events are
+// logged at debug level only, and what was typed is redacted unless the
+// content mode is full: a redacted key shows its kind (letter, digit,
+// symbol) and modifiers, and text shows only its length. The level can be
+// changed at runtime with SetLogLevel.
+
+// logLevelOff is above every slog level: nothing is logged.
+const logLevelOff = slog.Level(100)
+
+// logLevels names the levels accepted by -log-level and SetLogLevel.
+var logLevels = map[string]slog.Level{
+	"off":   logLevelOff,
+	"error": slog.LevelError,
+	"warn":  slog.LevelWarn,
+	"info":  slog.LevelInfo,
+	"debug": slog.LevelDebug,
+}
+
+// parseLogLevel parses a level name.
+func parseLogLevel(name string) (slog.Level, error) {
+	level, ok := logLevels[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q (want off, error, warn, info or debug)", name)
+	}
+	return level, nil
+}
+
+// logLevelName returns the name of a level.
+func logLevelName(level slog.Level) string {
+	for name, l := range logLevels {
+		if l == level {
+			return name
+		}
+	}
+	return strings.ToLower(level.String())
+}
+
+// logContent decides how much of what was typed the log shows.
+type logContent int
+
+const (
+	contentRedacted logContent = iota // Kind of key and length of text
+	contentFull                       // Keys, preedit and commit as typed
+)
+
+// parseLogContent parses a content mode name.
+func parseLogContent(name string) (logContent, error) {
+	switch name {
+	case "redacted":
+		return contentRedacted, nil
+	case "full":
+		return contentFull, nil
+	}
+	return 0, fmt.Errorf("unknown log content %q (want redacted or full)", name)
+}
+
+// logging is the daemon's logger and its runtime settings.
+type logging struct {
+	*slog.Logger
+	level   *slog.LevelVar
+	content logContent
+}
+
+// newLogging logs to w from the given level on.
+func newLogging(w io.Writer, level slog.Level, content logContent) *logging {
+	l := &logging{level: new(slog.LevelVar), content: content}
+	l.level.Set(level)
+	l.Logger = slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: l.level}))
+	return l
+}
+
+// logKey logs a key event and its result at debug level.
+func (l *logging) logKey(ctx *inputContext, event engine.KeyEvent, result engine.ProcessResult) {
+	if !l.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	if l.content == contentFull {
+		l.Debug("key", "ctx", ctx.id, "key", engine.FormatKeyScript([]engine.KeyEvent{event}),
+			"handled", result.Handled, "preedit", result.Preedit, "commit", result.CommitText)
+		return
+	}
+	l.Debug("key", "ctx", ctx.id, "key", redactKey(event), "handled", result.Handled,
+		"preedit_len", len([]rune(result.Preedit)), "commit_len", len([]rune(result.CommitText)))
+}
+
+// redactKey names a key without the character it types: special keys by
+// name, characters by kind, with their modifiers (<C-letter>).
+func redactKey(event engine.KeyEvent) string {
+	r := engine.KeysymToRune(event.KeySym)
+	if r == 0 || event.KeySym == engine.KeySpace {
+		return engine.FormatKeyScript([]engine.KeyEvent{event})
+	}
+
+	kind := "symbol"
+	switch {
+	case unicode.IsLetter(r):
+		kind = "letter"
+	case unicode.IsDigit(r):
+		kind = "digit"
+	}
+	// Format a placeholder key to get the modifier prefixes
+	prefix := engine.FormatKeyScript([]engine.KeyEvent{{KeySym: 'x', Modifiers: event.Modifiers}})
+	if prefix == "x" {
+		return kind
+	}
+	return strings.TrimSuffix(prefix, "x>") + kind + ">"
+}
+
+// stateDir returns the directory for the daemon's state files:
+// $XDG_STATE_HOME/goviet-ime, by default ~/.local/state/goviet-ime.
+func stateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "goviet-ime"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "goviet-ime"), nil
+}
+
+// Log rotation limits.
+const (
+	logMaxSize = 1 << 20 // Bytes before the log is rotated
+	logKeep    = 3       // Rotated logs kept (daemon.log.1 ... daemon.log.3)
+)
+
+// rotatingFile is a log file that is moved to path.1 once it grows past
+// maxSize, shifting older ones up to path.keep. It is created on the first
+// write, so a daemon that logs nothing leaves no file behind.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	f       *os.File
+	size    int64
+}
+
+func newRotatingFile(path string) *rotatingFile {
+	return &rotatingFile{path: path, maxSize: logMaxSize, keep: logKeep}
+}
+
+// Write appends p to the log, rotating it first if p would not fit.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f != nil && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// open opens the log for appending.
+func (r *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// rotate closes the log and shifts it and the older logs up by one.
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+	for i := r.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	return os.Rename(r.path, r.path+".1")
+}
+
+// Close closes the log if it was opened.
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// SetLogLevel changes the log level: off, error, warn, info or debug.
+func (e *InputEngine) SetLogLevel(sender dbus.Sender, level string) *dbus.Error {
+	if err := e.authorize(sender, "SetLogLevel"); err != nil {
+		return err
+	}
+	l, err := parseLogLevel(level)
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	e.log.level.Set(l)
+	e.log.Info("log level changed", "level", logLevelName(l))
+	return nil
+}
+
+// GetLogLevel returns the current log level.
+func (e *InputEngine) GetLogLevel() (string, *dbus.Error) {
+	return logLevelName(e.log.level.Level()), nil
+}
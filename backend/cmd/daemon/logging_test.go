@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// typeWord types "Mật khẩu1" and Ctrl+C into an engine logging to a buffer.
+func typeWord(content logContent, level string) string {
+	var buf bytes.Buffer
+	log := newLogging(&buf, logLevelOff, content)
+	e := NewInputEngine(log)
+	e.SetLogLevel("", level)
+	keys, _ := engine.ParseKeyScript("<S-m>aatj<Space>khaaru1<C-c>")
+	for _, key := range keys {
+		e.ProcessKey("", key.KeySym, key.Modifiers)
+	}
+	return buf.String()
+}
+
+func TestLogging_OffByDefault(t *testing.T) {
+	if out := typeWord(contentFull, "off"); out != "" {
+		t.Errorf("log level off wrote:\n%s", out)
+	}
+	e := NewInputEngine(nil)
+	if level, _ := e.GetLogLevel(); level != "off" {
+		t.Errorf("GetLogLevel() = %q, want off", level)
+	}
+}
+
+func TestLogging_Redacted(t *testing.T) {
+	out := typeWord(contentRedacted, "debug")
+	for _, secret := range []string{"Mật", "khẩu", "khaaru", `key=m`, "key=1"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("redacted log contains %q:\n%s", secret, out)
+		}
+	}
+	for _, want := range []string{"key=<S-letter>", "key=<Space>", "key=digit", "key=<C-letter>", "commit_len=4"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("redacted log lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestLogging_Full(t *testing.T) {
+	out := typeWord(contentFull, "debug")
+	for _, want := range []string{`commit="Mật "`, `preedit=khẩu1`, "key=<C-c>"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("full log lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestLogging_Level(t *testing.T) {
+	// Key events are debug messages
+	if out := typeWord(contentFull, "info"); strings.Contains(out, "msg=key") {
+		t.Errorf("info level logged keys:\n%s", out)
+	}
+
+	e := NewInputEngine(nil)
+	if err := e.SetLogLevel("", "DEBUG"); err != nil {
+		t.Fatal(err)
+	}
+	if level, _ := e.GetLogLevel(); level != "debug" {
+		t.Errorf("GetLogLevel() = %q, want debug", level)
+	}
+	if err := e.SetLogLevel("", "loud"); err == nil {
+		t.Error("SetLogLevel should reject unknown levels")
+	}
+}
+
+func TestStateDir(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/tmp/state")
+	if dir, _ := stateDir(); dir != "/tmp/state/goviet-ime" {
+		t.Errorf("stateDir() = %q", dir)
+	}
+
+	// Relative paths are ignored, as the XDG spec requires
+	t.Setenv("XDG_STATE_HOME", "state")
+	t.Setenv("HOME", "/home/user")
+	if dir, _ := stateDir(); dir != "/home/user/.local/state/goviet-ime" {
+		t.Errorf("stateDir() = %q", dir)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "daemon.log")
+	r := newRotatingFile(path)
+	r.maxSize = 10
+	defer r.Close()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("the log should not exist before the first write")
+	}
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]string{
+		"":   "six\n",
+		".1": "four\nfive\n",
+		".2": "three\n",
+		".3": "one\ntwo\n",
+	}
+	for suffix, content := range want {
+		data, err := os.ReadFile(path + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("daemon.log%s = %q, want %q", suffix, data, content)
+		}
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm() != 0600 {
+		t.Errorf("log mode = %v, want 0600", info.Mode().Perm())
+	}
+}
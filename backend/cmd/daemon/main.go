@@ -1,180 +1,227 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"io"
 	"os"
-	"os/signal"
-	"syscall"
+	"sync"
+	"time"
 
 	"github.com/godbus/dbus/v5"
 	"github.com/username/goviet-ime/internal/engine"
 )
 
 const (
-	serviceName = "com.github.goviet.ime"
-	objectPath  = "/Engine"
+	serviceName   = "com.github.goviet.ime"
+	interfaceName = serviceName + ".Engine1" // See protocol/
+	objectPath    = "/Engine"
 )
 
 // InputEngine is the D-Bus object that receives key events from Fcitx5.
+// Key events go to the focused input context (see FocusIn).
+//
+// godbus runs every method call on its own goroutine. Calls that touch the
+// contexts hold mu for their whole duration, so each call sees and leaves a
+// consistent state, but calls in flight at the same time may run in any
+// order: a Reset sent without waiting for the reply can still run after a
+// key event sent later. The frontend therefore waits for the reply of every
+// call a later key depends on (FocusIn, FocusOut, Reset) before it sends
+// the key. Keys sent with SubmitKey carry numbers and run in their order
+// (see pipeline.go).
 type InputEngine struct {
-	engine *engine.CompositionEngine
-	logger *log.Logger
+	mu       sync.Mutex           // Guards config, contexts, current and recorder
+	config   *engine.EngineConfig // Shared by the contexts; replaced, never modified
+	contexts map[string]*inputContext
+	current  *inputContext
+	conn     emitter // Sends signals, nil when not exported
+	log      *logging
+	recorder *engine.SessionWriter // Session recording, nil when not recording
+	metrics  *metrics
+	crashDir string      // Where crash reports go, none when empty
+	auth     *authorizer // Callers allowed to use restricted methods, nil for any
+
+	signalContent logContent // Whether signals carry the text typed
+
+	configFile     string        // Configuration file read by Reload, none when empty
+	configRequired bool          // The file was named with --config and must exist
+	stop           chan struct{} // Receives when Stop is called
 }
 
-// NewInputEngine creates a new InputEngine with default settings.
-func NewInputEngine(logger *log.Logger) *InputEngine {
-	return &InputEngine{
-		engine: engine.NewCompositionEngine(),
-		logger: logger,
+// NewInputEngine creates a new InputEngine with default settings. A nil log
+// discards everything.
+func NewInputEngine(log *logging) *InputEngine {
+	if log == nil {
+		log = newLogging(io.Discard, logLevelOff, contentRedacted)
 	}
+	e := &InputEngine{
+		config:   engine.DefaultConfig(),
+		contexts: make(map[string]*inputContext),
+		log:      log,
+		metrics:  newMetrics(),
+		stop:     make(chan struct{}, 1),
+	}
+	e.current = e.context(defaultContextID)
+	return e
+}
+
+// processKey runs a key event through the focused context. If the engine
+// panics, the context starts over and the error carries the keystrokes of
+// the word typed so far.
+func (e *InputEngine) processKey(method string, event engine.KeyEvent) (engine.ProcessResult, *dbus.Error) {
+	start := time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stepKey(method, e.current, event, start)
+}
+
+// stepKey runs a key event through ctx like processKey, for a caller that
+// holds e.mu; start is when the key arrived, for the latency metrics.
+func (e *InputEngine) stepKey(method string, ctx *inputContext, event engine.KeyEvent, start time.Time) (result engine.ProcessResult, err *dbus.Error) {
+	ctx.raw = ctx.engine.AppendRawKeys(ctx.raw[:0])
+	ctx.remember(event)
+	defer e.recoverCall(method, ctx, ctx.raw, &err)
+
+	before := ctx.engine.Mode()
+	result = ctx.engine.ProcessKey(event)
+	e.syncMode(ctx, before)
+	e.emitResult(ctx, result)
+	e.log.logKey(ctx, event, result)
+	stats := ctx.engine.LastKeyStats()
+	e.recordKey(ctx, event, result, stats)
+	e.metrics.observeKey(event, result, stats, time.Since(start))
+	return result, nil
 }
 
 // ProcessKey handles key events from Fcitx5 frontend.
 // Input: keysym (X11 keycode), modifiers (Shift/Ctrl/Alt state)
 // Output: handled (was key consumed), commitText (text to commit), preeditText (composition)
-func (e *InputEngine) ProcessKey(keysym uint32, modifiers uint32) (bool, string, string, *dbus.Error) {
+func (e *InputEngine) ProcessKey(sender dbus.Sender, keysym uint32, modifiers uint32) (bool, string, string, *dbus.Error) {
+	if err := e.authorize(sender, "ProcessKey"); err != nil {
+		return false, "", "", err
+	}
 	event := engine.KeyEvent{
 		KeySym:    keysym,
 		Modifiers: modifiers,
 	}
 
-	result := e.engine.ProcessKey(event)
-
-	// Log the key event and result
-	if e.logger != nil {
-		char := engine.KeysymToRune(keysym)
-		keyStr := fmt.Sprintf("0x%x", keysym)
-		if char != 0 {
-			keyStr = fmt.Sprintf("%q", char)
-		} else {
-			// Handle special keys if they don't have a rune representation
-			switch keysym {
-			case engine.KeyBackspace:
-				keyStr = "Backspace"
-			case engine.KeySpace:
-				keyStr = "Space"
-			case engine.KeyReturn:
-				keyStr = "Enter"
-			case engine.KeyTab:
-				keyStr = "Tab"
-			case engine.KeyEscape:
-				keyStr = "Esc"
-			case engine.KeyDelete:
-				keyStr = "Delete"
-			case 0xff51:
-				keyStr = "Left"
-			case 0xff52:
-				keyStr = "Up"
-			case 0xff53:
-				keyStr = "Right"
-			case 0xff54:
-				keyStr = "Down"
-			case 0xff50:
-				keyStr = "Home"
-			case 0xff57:
-				keyStr = "End"
-			case 0xff55:
-				keyStr = "PgUp"
-			case 0xff56:
-				keyStr = "PgDn"
-			}
-		}
-
-		modsStr := ""
-		if modifiers&engine.ModShift != 0 {
-			modsStr += "Shift+"
-		}
-		if modifiers&engine.ModControl != 0 {
-			modsStr += "Ctrl+"
-		}
-		if modifiers&engine.ModMod1 != 0 {
-			modsStr += "Alt+"
-		}
-
-		e.logger.Printf("Type: %-15s | Preedit: %-15q | Commit: %-15q | Handled: %v",
-			modsStr+keyStr, result.Preedit, result.CommitText, result.Handled)
+	result, err := e.processKey("ProcessKey", event)
+	if err != nil {
+		return false, "", "", err
 	}
 
 	return result.Handled, result.CommitText, result.Preedit, nil
 }
 
+// ProcessKey2 handles key events like ProcessKey but returns the full result
+// as a versioned a{sv} dictionary. See processResultToMap for the keys.
+func (e *InputEngine) ProcessKey2(sender dbus.Sender, keysym uint32, modifiers uint32) (map[string]dbus.Variant, *dbus.Error) {
+	if err := e.authorize(sender, "ProcessKey2"); err != nil {
+		return nil, err
+	}
+	event := engine.KeyEvent{
+		KeySym:    keysym,
+		Modifiers: modifiers,
+	}
+
+	result, err := e.processKey("ProcessKey2", event)
+	if err != nil {
+		return nil, err
+	}
+
+	return processResultToMap(result), nil
+}
+
 // Reset clears the current composition state.
-func (e *InputEngine) Reset() *dbus.Error {
-	e.engine.Reset()
-	fmt.Println(">>> [GoViet] Engine reset")
+func (e *InputEngine) Reset(sender dbus.Sender) (err *dbus.Error) {
+	if err := e.authorize(sender, "Reset"); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.recoverCall("Reset", e.current, nil, &err)
+	e.current.engine.Reset()
+	e.syncPreedit(e.current)
+	e.recordEvent(engine.SessionReset, e.current.id)
+	e.log.Debug("reset", "ctx", e.current.id)
 	return nil
 }
 
 // SetEnabled enables or disables the engine.
-func (e *InputEngine) SetEnabled(enabled bool) *dbus.Error {
-	e.engine.SetEnabled(enabled)
-	fmt.Printf(">>> [GoViet] Engine enabled: %v\n", enabled)
+func (e *InputEngine) SetEnabled(sender dbus.Sender, enabled bool) (err *dbus.Error) {
+	if err := e.authorize(sender, "SetEnabled"); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.recoverCall("SetEnabled", e.current, nil, &err)
+	before := e.current.engine.Mode()
+	e.current.engine.SetEnabled(enabled)
+	if enabled {
+		e.recordEvent(engine.SessionEnable, e.current.id)
+	} else {
+		e.recordEvent(engine.SessionDisable, e.current.id)
+	}
+	e.syncPreedit(e.current)
+	e.syncMode(e.current, before)
+	e.log.Info("enabled", "ctx", e.current.id, "enabled", enabled)
 	return nil
 }
 
-// GetPreedit returns the current preedit string.
-func (e *InputEngine) GetPreedit() (string, *dbus.Error) {
-	return e.engine.GetPreedit(), nil
+// setConfig switches every context to a new configuration. The config is
+// shared by the contexts and must not be modified afterwards.
+func (e *InputEngine) setConfig(config *engine.EngineConfig) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.useConfig(config)
 }
 
-func main() {
-	// 1. Connect to Session Bus
-	conn, err := dbus.SessionBus()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to connect to session bus:", err)
-		os.Exit(1)
-	}
-	defer conn.Close()
+// updateConfig applies update to a copy of the configuration and switches
+// to it, unless update fails.
+func (e *InputEngine) updateConfig(update func(*engine.EngineConfig) error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
-	// 2. Register Service Name
-	reply, err := conn.RequestName(serviceName, dbus.NameFlagDoNotQueue)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to request name:", err)
-		os.Exit(1)
+	config := e.config.Clone()
+	if err := update(config); err != nil {
+		return err
 	}
+	e.useConfig(config)
+	return nil
+}
 
-	if reply != dbus.RequestNameReplyPrimaryOwner {
-		fmt.Fprintln(os.Stderr, "Name already taken - another instance may be running")
-		os.Exit(1)
+// useConfig switches every context to config. The caller holds e.mu.
+func (e *InputEngine) useConfig(config *engine.EngineConfig) {
+	changed := config.InputMethodName != e.config.InputMethodName
+	e.config = config
+	for _, ctx := range e.contexts {
+		ctx.engine.SetConfig(config)
+		e.syncPreedit(ctx)
+	}
+	if changed {
+		e.signal(objectPath, "InputMethodChanged", config.InputMethodName)
 	}
+}
 
-	// 3. Setup Logging
-	logFile, err := os.OpenFile("typing.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	var logger *log.Logger
-	if err == nil {
-		logger = log.New(logFile, "", log.LstdFlags)
-		fmt.Println(">>> [GoViet] Logging to typing.log")
-	} else {
-		fmt.Fprintf(os.Stderr, ">>> [GoViet] Failed to open log file: %v\n", err)
+// GetPreedit returns the current preedit string.
+func (e *InputEngine) GetPreedit(sender dbus.Sender) (preedit string, err *dbus.Error) {
+	if err := e.authorize(sender, "GetPreedit"); err != nil {
+		return "", err
 	}
-	defer logFile.Close()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer e.recoverCall("GetPreedit", e.current, nil, &err)
+	return e.current.engine.GetPreedit(), nil
+}
 
-	// 4. Create and export the engine
-	inputEngine := NewInputEngine(logger)
+// ListInputMethods returns the names of the available input methods.
+func (e *InputEngine) ListInputMethods() ([]string, *dbus.Error) {
+	return engine.InputMethods(), nil
+}
 
-	err = conn.Export(inputEngine, dbus.ObjectPath(objectPath), serviceName)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to export object:", err)
-		os.Exit(1)
-	}
+// ListOutputFormats returns the names of the available output formats.
+func (e *InputEngine) ListOutputFormats() ([]string, *dbus.Error) {
+	return engine.OutputFormats(), nil
+}
 
-	// 4. Print startup banner
-	fmt.Println("================================================")
-	fmt.Println("✅ GoViet-IME Backend is running!")
-	fmt.Println("================================================")
-	fmt.Printf("  Service:     %s\n", serviceName)
-	fmt.Printf("  Object Path: %s\n", objectPath)
-	fmt.Printf("  Input Method: Telex\n")
-	fmt.Printf("  Output Format: Unicode\n")
-	fmt.Println("------------------------------------------------")
-	fmt.Println("Waiting for key events...")
-	fmt.Println()
-
-	// 5. Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	<-sigChan
-	fmt.Println("\n>>> [GoViet] Shutting down...")
+func main() {
+	os.Exit(runCommand(os.Args[1:], os.Stdout, os.Stderr))
 }
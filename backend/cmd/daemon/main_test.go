@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// TestInputEngine_Concurrent calls the D-Bus methods from many goroutines,
+// as godbus does. Run it with -race.
+func TestInputEngine_Concurrent(t *testing.T) {
+	e := NewInputEngine(newLogging(io.Discard, slog.LevelDebug, contentFull))
+	if err := e.recordTo(io.Discard, true); err != nil {
+		t.Fatal(err)
+	}
+
+	vni := *engine.DefaultConfig()
+	vni.InputMethodName = "VNI"
+	configs := []*engine.EngineConfig{engine.DefaultConfig(), &vni}
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		id := fmt.Sprintf("ctx%d", i)
+		wg.Go(func() {
+			for range 100 {
+				e.FocusIn("", id)
+				for _, r := range "tieengs vieetj " {
+					e.ProcessKey("", uint32(r), 0)
+				}
+				e.ProcessKey2("", 'a', 0)
+				e.ProcessKey("", engine.KeyBackspace, 0)
+				e.ProcessText("", "xin chaof ")
+				e.GetPreedit("")
+				e.FocusOut("", id)
+			}
+			e.DestroyContext("", id)
+		})
+	}
+	wg.Go(func() {
+		var seq uint32
+		for range 100 {
+			for _, r := range "xin chaof " {
+				seq++
+				e.SubmitKey(":1.9", "pipe", seq, uint32(r), 0)
+			}
+		}
+	})
+	wg.Go(func() {
+		for i := range 200 {
+			e.Reset("")
+			e.SetEnabled("", i%2 == 0)
+			e.GetMode()
+		}
+	})
+	wg.Go(func() {
+		for i := range 100 {
+			e.setConfig(configs[i%len(configs)])
+			e.GetStats()
+			e.SetLogLevel("", []string{"debug", "info"}[i%2])
+		}
+	})
+	wg.Wait()
+
+	// Typing still works afterwards
+	e.setConfig(engine.DefaultConfig())
+	e.FocusIn("", "check")
+	e.SetEnabled("", true)
+	e.ProcessKey("", 'a', 0)
+	if _, _, preedit, _ := e.ProcessKey("", 'a', 0); preedit != "â" {
+		t.Errorf("preedit = %q, want â", preedit)
+	}
+	if stats, _ := e.GetStats(); stats["panics"].Value() != uint64(0) {
+		t.Errorf("panics = %v", stats["panics"])
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	e := NewInputEngine(nil)
+	e.FocusIn("", "editor")
+	config := *engine.DefaultConfig()
+	config.InputMethodName = "VNI"
+	e.setConfig(&config)
+
+	// Existing and new contexts both use the new config
+	for _, id := range []string{"editor", "terminal"} {
+		e.FocusIn("", id)
+		e.ProcessKey("", 'a', 0)
+		if _, _, preedit, _ := e.ProcessKey("", '6', 0); preedit != "â" {
+			t.Errorf("%s: preedit = %q, want â", id, preedit)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// Metrics
+//
+// The daemon counts the keys it processes and how long each one took, so the
+// latency and memory targets checked by the benchmarks can be checked on a
+// running system too. GetStats returns a summary over D-Bus; -metrics-file
+// writes every metric in the OpenMetrics text format to a local file.
+
+// statsVersion is the version of the GetStats reply dictionary. Like the
+// ProcessKey2 reply, keys may be added without bumping it.
+const statsVersion uint32 = 1
+
+// metricsInterval is how often the metrics file is rewritten.
+const metricsInterval = 10 * time.Second
+
+// Histogram bucket upper bounds.
+var (
+	latencyBounds = []float64{10e-6, 25e-6, 50e-6, 100e-6, 250e-6, 500e-6, 1e-3, 2.5e-3, 10e-3, 50e-3, 200e-3} // Seconds
+	replayBounds  = []float64{1, 2, 4, 8, 16, 32, 64}                                                          // Keystrokes
+)
+
+// histogram counts observations in buckets with fixed upper bounds. The
+// last bucket holds everything above the highest bound.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+	max    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+// observe adds a value.
+func (h *histogram) observe(v float64) {
+	i, _ := slices.BinarySearch(h.bounds, v)
+	h.counts[i]++
+	h.count++
+	h.sum += v
+	h.max = max(h.max, v)
+}
+
+// quantile estimates the q-quantile (0 < q <= 1) as the upper bound of the
+// bucket that holds it, never more than the largest value seen.
+func (h *histogram) quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := q * float64(h.count)
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if float64(seen) >= rank && i < len(h.bounds) {
+			return min(h.bounds[i], h.max)
+		}
+	}
+	return h.max
+}
+
+// metrics are the daemon's counters and histograms. D-Bus calls run on
+// their own goroutines, so every access holds mu.
+type metrics struct {
+	mu         sync.Mutex
+	start      time.Time
+	keys       uint64
+	handled    uint64
+	released   uint64 // Key releases, not counted in keys
+	reverts    uint64
+	rejections uint64
+	panics     uint64
+	reordered  uint64     // Pipelined keys that came before a key numbered lower
+	lost       uint64     // Pipelined keys given up on or dropped
+	latency    *histogram // Seconds spent in ProcessKey on a key press
+	replays    *histogram // Keystrokes reduced again by edits inside a word
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		start:   time.Now(),
+		latency: newHistogram(latencyBounds),
+		replays: newHistogram(replayBounds),
+	}
+}
+
+// observeKey counts a processed key. Releases are only counted: the
+// frontend sends them for a modifier-only hotkey, and the engine passes
+// them through without work that would skew the key counts and latency.
+func (m *metrics) observeKey(event engine.KeyEvent, result engine.ProcessResult, stats engine.KeyStats, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if event.Modifiers&engine.ModRelease != 0 {
+		m.released++
+		return
+	}
+	m.keys++
+	if result.Handled {
+		m.handled++
+	}
+	if stats.Reverted {
+		m.reverts++
+	}
+	if stats.Rejected {
+		m.rejections++
+	}
+	if stats.Replayed > 0 {
+		m.replays.observe(float64(stats.Replayed))
+	}
+	m.latency.observe(elapsed.Seconds())
+}
+
+// countPanic counts a recovered panic and returns how many there were.
+func (m *metrics) countPanic() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.panics++
+	return m.panics
+}
+
+// countReordered counts a pipelined key that came early.
+func (m *metrics) countReordered() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reordered++
+}
+
+// countLost counts n pipelined keys that were not run.
+func (m *metrics) countLost(n uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lost += n
+}
+
+// stats returns the GetStats reply.
+//
+//	version          u  reply format version
+//	uptime-seconds   d  time since the daemon started
+//	keys             t  key presses processed
+//	keys-handled     t  key presses consumed by the engine
+//	keys-released    t  key releases processed
+//	reverts          t  keys that reverted the modifier before them
+//	rejections       t  marks and tones the validator turned into letters
+//	panics           t  calls that panicked and were recovered
+//	keys-reordered   t  pipelined keys that came before a key numbered lower
+//	keys-lost        t  pipelined keys given up on or dropped
+//	latency-p50-us   d  ProcessKey latency percentiles, in microseconds
+//	latency-p90-us   d
+//	latency-p99-us   d
+//	latency-max-us   d
+//	replays          t  edits inside a word that reduced it again
+//	replay-p99-keys  d  keystrokes reduced again, 99th percentile
+//	replay-max-keys  d
+//	heap-bytes       t  live heap
+//	sys-bytes        t  memory obtained from the OS
+func (m *metrics) stats() map[string]dbus.Variant {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	micros := func(seconds float64) float64 { return seconds * 1e6 }
+	return map[string]dbus.Variant{
+		"version":         dbus.MakeVariant(statsVersion),
+		"uptime-seconds":  dbus.MakeVariant(time.Since(m.start).Seconds()),
+		"keys":            dbus.MakeVariant(m.keys),
+		"keys-handled":    dbus.MakeVariant(m.handled),
+		"keys-released":   dbus.MakeVariant(m.released),
+		"reverts":         dbus.MakeVariant(m.reverts),
+		"rejections":      dbus.MakeVariant(m.rejections),
+		"panics":          dbus.MakeVariant(m.panics),
+		"keys-reordered":  dbus.MakeVariant(m.reordered),
+		"keys-lost":       dbus.MakeVariant(m.lost),
+		"latency-p50-us":  dbus.MakeVariant(micros(m.latency.quantile(0.50))),
+		"latency-p90-us":  dbus.MakeVariant(micros(m.latency.quantile(0.90))),
+		"latency-p99-us":  dbus.MakeVariant(micros(m.latency.quantile(0.99))),
+		"latency-max-us":  dbus.MakeVariant(micros(m.latency.max)),
+		"replays":         dbus.MakeVariant(m.replays.count),
+		"replay-p99-keys": dbus.MakeVariant(m.replays.quantile(0.99)),
+		"replay-max-keys": dbus.MakeVariant(m.replays.max),
+		"heap-bytes":      dbus.MakeVariant(mem.HeapAlloc),
+		"sys-bytes":       dbus.MakeVariant(mem.Sys),
+	}
+}
+
+// writeOpenMetrics writes every metric in the OpenMetrics text format.
+func (m *metrics) writeOpenMetrics(w io.Writer) error {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := bufio.NewWriter(w)
+	family := func(name, kind, help string) {
+		fmt.Fprintf(b, "# TYPE %s %s\n# HELP %s %s\n", name, kind, name, help)
+	}
+	counter := func(name, help string, v uint64) {
+		family(name, "counter", help)
+		fmt.Fprintf(b, "%s_total %d\n", name, v)
+	}
+	gauge := func(name, help string, v float64) {
+		family(name, "gauge", help)
+		fmt.Fprintf(b, "%s %s\n", name, formatFloat(v))
+	}
+	hist := func(name, help string, h *histogram) {
+		family(name, "histogram", help)
+		var seen uint64
+		for i, n := range h.counts {
+			seen += n
+			le := "+Inf"
+			if i < len(h.bounds) {
+				le = formatFloat(h.bounds[i])
+			}
+			fmt.Fprintf(b, "%s_bucket{le=\"%s\"} %d\n", name, le, seen)
+		}
+		fmt.Fprintf(b, "%s_sum %s\n%s_count %d\n", name, formatFloat(h.sum), name, h.count)
+	}
+
+	gauge("goviet_uptime_seconds", "Time since the daemon started.", time.Since(m.start).Seconds())
+	counter("goviet_keys", "Key presses processed.", m.keys)
+	counter("goviet_keys_handled", "Key presses consumed by the engine.", m.handled)
+	counter("goviet_keys_released", "Key releases processed.", m.released)
+	counter("goviet_reverts", "Keys that reverted the modifier typed before them.", m.reverts)
+	counter("goviet_rejections", "Marks and tones the validator turned into letters.", m.rejections)
+	counter("goviet_panics", "Calls that panicked and were recovered.", m.panics)
+	counter("goviet_keys_reordered", "Pipelined keys that came before a key numbered lower.", m.reordered)
+	counter("goviet_keys_lost", "Pipelined keys given up on or dropped.", m.lost)
+	hist("goviet_key_latency_seconds", "Time spent processing a key press.", m.latency)
+	hist("goviet_replay_keys", "Keystrokes reduced again by an edit inside a word.", m.replays)
+	gauge("goviet_heap_bytes", "Live heap.", float64(mem.HeapAlloc))
+	gauge("goviet_sys_bytes", "Memory obtained from the OS.", float64(mem.Sys))
+	b.WriteString("# EOF\n")
+	return b.Flush()
+}
+
+// formatFloat formats a sample value.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeMetricsFile replaces the file at path with the current metrics. The
+// file is written next to it and renamed, so readers never see half of it.
+func (m *metrics) writeMetricsFile(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := m.writeOpenMetrics(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// dumpMetrics rewrites the metrics file every interval until the returned
+// function is called, which writes it one last time.
+func (e *InputEngine) dumpMetrics(path string, interval time.Duration) (stop func()) {
+	write := func() {
+		if err := e.metrics.writeMetricsFile(path); err != nil {
+			e.log.Warn("failed to write metrics", "file", path, "err", err)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			select {
+			case <-ticker.C:
+				write()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-finished
+		write()
+	}
+}
+
+// GetStats returns the daemon's metrics as a versioned a{sv} dictionary.
+// See metrics.stats for the keys.
+func (e *InputEngine) GetStats() (map[string]dbus.Variant, *dbus.Error) {
+	return e.metrics.stats(), nil
+}
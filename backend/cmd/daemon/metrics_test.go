@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+func TestHistogram_Quantile(t *testing.T) {
+	h := newHistogram([]float64{1, 2, 4, 8})
+	if q := h.quantile(0.5); q != 0 {
+		t.Errorf("empty quantile = %v, want 0", q)
+	}
+	for _, v := range []float64{1, 1, 1, 1, 1, 1, 1, 1, 3, 20} {
+		h.observe(v)
+	}
+
+	tests := []struct {
+		q    float64
+		want float64
+	}{
+		{0.5, 1},
+		{0.9, 4},   // Upper bound of the bucket holding 3
+		{0.99, 20}, // Above every bound: the largest value
+	}
+	for _, tt := range tests {
+		if got := h.quantile(tt.q); got != tt.want {
+			t.Errorf("quantile(%v) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+
+	// A bucket bound above every value is capped by the largest one
+	h = newHistogram([]float64{10})
+	h.observe(3)
+	if got := h.quantile(0.5); got != 3 {
+		t.Errorf("quantile(0.5) = %v, want 3", got)
+	}
+}
+
+// typeStats types keys into a fresh engine and returns its GetStats reply.
+func typeStats(t *testing.T, script string) map[string]any {
+	t.Helper()
+	e := NewInputEngine(nil)
+	keys, err := engine.ParseKeyScript(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		e.ProcessKey("", key.KeySym, key.Modifiers)
+	}
+	reply, _ := e.GetStats()
+	stats := make(map[string]any)
+	for k, v := range reply {
+		stats[k] = v.Value()
+	}
+	return stats
+}
+
+func TestGetStats(t *testing.T) {
+	// aaa reverts, "kas" is rejected, the tone at the caret replays "ban";
+	// the releases of a and Shift are counted apart
+	stats := typeStats(t, "aaa<Space>kas<Space>ban<Left>s<C-c><R-a><R-Shift>")
+
+	want := map[string]any{
+		"version":       statsVersion,
+		"keys":          uint64(14),
+		"keys-handled":  uint64(13),
+		"keys-released": uint64(2),
+		"reverts":       uint64(1),
+		"rejections":    uint64(1),
+		"replays":       uint64(1),
+	}
+	for k, v := range want {
+		if stats[k] != v {
+			t.Errorf("%s = %v, want %v", k, stats[k], v)
+		}
+	}
+	if got := stats["replay-max-keys"]; got != 4.0 {
+		t.Errorf("replay-max-keys = %v, want 4", got)
+	}
+	p50, p99, top := stats["latency-p50-us"].(float64), stats["latency-p99-us"].(float64), stats["latency-max-us"].(float64)
+	if p50 <= 0 || p50 > p99 || p99 > top {
+		t.Errorf("latency p50 %v, p99 %v, max %v", p50, p99, top)
+	}
+	if stats["heap-bytes"].(uint64) == 0 {
+		t.Error("heap-bytes should be set")
+	}
+}
+
+func TestOpenMetrics(t *testing.T) {
+	m := newMetrics()
+	m.observeKey(engine.KeyEvent{KeySym: 'a'}, engine.ProcessResult{Handled: true}, engine.KeyStats{Replayed: 3}, 30*time.Microsecond)
+	m.observeKey(engine.KeyEvent{KeySym: 'a'}, engine.ProcessResult{}, engine.KeyStats{}, 2*time.Millisecond)
+	m.observeKey(engine.KeyEvent{KeySym: 'a', Modifiers: engine.ModRelease}, engine.ProcessResult{}, engine.KeyStats{}, time.Second)
+
+	var buf bytes.Buffer
+	if err := m.writeOpenMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE goviet_keys counter\n",
+		"goviet_keys_total 2\n",
+		"goviet_keys_handled_total 1\n",
+		"goviet_keys_released_total 1\n",
+		"# TYPE goviet_key_latency_seconds histogram\n",
+		`goviet_key_latency_seconds_bucket{le="2.5e-05"} 0` + "\n",
+		`goviet_key_latency_seconds_bucket{le="5e-05"} 1` + "\n",
+		`goviet_key_latency_seconds_bucket{le="+Inf"} 2` + "\n",
+		"goviet_key_latency_seconds_count 2\n",
+		`goviet_replay_keys_bucket{le="4"} 1` + "\n",
+		"goviet_replay_keys_sum 3\n",
+		"# TYPE goviet_heap_bytes gauge\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	if !strings.HasSuffix(out, "\n# EOF\n") {
+		t.Errorf("output should end with # EOF:\n%s", out)
+	}
+}
+
+func TestDumpMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.txt")
+	e := NewInputEngine(nil)
+	stop := e.dumpMetrics(path, time.Hour)
+	e.ProcessKey("", 'a', 0)
+	stop()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "goviet_keys_total 1\n") {
+		t.Errorf("metrics file lacks the final count:\n%s", data)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("the temporary file should be renamed")
+	}
+}
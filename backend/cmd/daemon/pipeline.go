@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// Pipelined keys
+//
+// ProcessKey costs the frontend a round trip per key, and a slow reply makes
+// it drop the key. With SubmitKey a frontend types ahead instead: it numbers
+// the keys of each context 1, 2, 3 and so on, sends them without waiting
+// and gets the result of each back in a signal that carries its number.
+//
+//	SubmitKey(context s, seq u, keysym u, modifiers u)
+//	KeyProcessed(seq u, result a{sv})       result as for ProcessKey2
+//	KeyFailed(seq u, message s, commit s)   as Error.Internal
+//	KeysLost(first u, last u)               keys first..last were not run
+//
+// godbus runs every call on its own goroutine, so keys can reach the engine
+// out of order. A key that comes early waits for the keys before it, and
+// the keys of a context run strictly in order. When a key is still missing
+// after gapTimeout, or more than maxKeysAhead keys wait behind it, the
+// daemon gives up on it, reports KeysLost and goes on. A key whose turn has
+// passed is dropped and reported the same way.
+//
+// The signals come from the context's path (see contextPath) and go to the
+// submitting connection only, so they carry the text typed. Numbering
+// starts over at 1 when another connection submits keys to the context or
+// the context is destroyed.
+
+const (
+	gapTimeout   = 100 * time.Millisecond // How long a missing key is waited for
+	maxKeysAhead = 256                    // Keys that may wait for a missing one
+)
+
+// keyStream is the state of the pipelined keys of a context.
+type keyStream struct {
+	sender  string                     // The connection that submits keys
+	next    uint32                     // Number of the next key to run
+	pending map[uint32]engine.KeyEvent // Keys that came early, by number
+	gap     *time.Timer                // Gives up on key next; nil when nothing waits
+}
+
+// stop cancels the wait for a missing key.
+func (s *keyStream) stop() {
+	if s.gap != nil {
+		s.gap.Stop()
+		s.gap = nil
+	}
+}
+
+// SubmitKey queues a key event for the context with the given id, creating
+// it if needed, and runs the keys that are due. Results are sent as
+// signals; the reply carries nothing and may be left out.
+func (e *InputEngine) SubmitKey(sender dbus.Sender, id string, seq uint32, keysym uint32, modifiers uint32) *dbus.Error {
+	if err := e.authorize(sender, "SubmitKey"); err != nil {
+		return err
+	}
+	if seq == 0 {
+		return dbus.MakeFailedError(errors.New("key numbers start at 1"))
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ctx := e.context(id)
+	s := ctx.stream
+	if s == nil || s.sender != string(sender) {
+		if s != nil {
+			s.stop()
+			e.log.Info("key stream taken over", "ctx", id, "sender", string(sender), "dropped", len(s.pending))
+		}
+		s = &keyStream{sender: string(sender), next: 1, pending: make(map[uint32]engine.KeyEvent)}
+		ctx.stream = s
+	}
+
+	if _, queued := s.pending[seq]; queued || seq < s.next {
+		e.log.Warn("key out of turn dropped", "ctx", id, "seq", seq, "next", s.next)
+		e.metrics.countLost(1)
+		e.signalTo(s.sender, contextPath(id), "KeysLost", seq, seq)
+		return nil
+	}
+	s.pending[seq] = engine.KeyEvent{KeySym: keysym, Modifiers: modifiers}
+	if seq != s.next {
+		e.log.Debug("key came early", "ctx", id, "seq", seq, "next", s.next)
+		e.metrics.countReordered()
+	}
+	e.runStream(ctx)
+	if len(s.pending) > maxKeysAhead {
+		e.skipGap(ctx)
+	}
+	return nil
+}
+
+// runStream runs the keys of ctx that are due and waits gapTimeout for the
+// next one when later keys are queued. The caller holds e.mu.
+func (e *InputEngine) runStream(ctx *inputContext) {
+	s := ctx.stream
+	ran := false
+	for {
+		event, ok := s.pending[s.next]
+		if !ok {
+			break
+		}
+		delete(s.pending, s.next)
+		e.runStreamKey(ctx, s.next, event)
+		s.next++
+		ran = true
+	}
+
+	switch {
+	case len(s.pending) == 0:
+		s.stop()
+	case ran || s.gap == nil:
+		s.stop()
+		next := s.next
+		s.gap = time.AfterFunc(gapTimeout, func() {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			// The timer may fire after the key came or the stream ended
+			if ctx.stream == s && s.next == next && len(s.pending) > 0 {
+				e.skipGap(ctx)
+			}
+		})
+	}
+}
+
+// runStreamKey runs a pipelined key through ctx and sends its result to the
+// connection that submitted it.
+func (e *InputEngine) runStreamKey(ctx *inputContext, seq uint32, event engine.KeyEvent) {
+	path := contextPath(ctx.id)
+	result, err := e.stepKey("SubmitKey", ctx, event, time.Now())
+	if err != nil {
+		e.signalTo(ctx.stream.sender, path, "KeyFailed", seq, err.Body[0], err.Body[1])
+		return
+	}
+	e.signalTo(ctx.stream.sender, path, "KeyProcessed", seq, processResultToMap(result))
+}
+
+// skipGap gives up on the keys missing before the first queued one, reports
+// them and runs the keys that are then due. The caller holds e.mu.
+func (e *InputEngine) skipGap(ctx *inputContext) {
+	s := ctx.stream
+	first := slices.Min(slices.Collect(maps.Keys(s.pending)))
+	e.log.Warn("keys lost", "ctx", ctx.id, "first", s.next, "last", first-1)
+	e.metrics.countLost(uint64(first - s.next))
+	e.signalTo(s.sender, contextPath(ctx.id), "KeysLost", s.next, first-1)
+	s.next = first
+	e.runStream(ctx)
+}
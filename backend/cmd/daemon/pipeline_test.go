@@ -0,0 +1,158 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// frontend is the connection that submits keys in these tests.
+const frontend = ":1.7"
+
+// pipelineEngine returns an engine that records signals.
+func pipelineEngine() (*InputEngine, *signalRecorder) {
+	e := NewInputEngine(nil)
+	signals := new(signalRecorder)
+	e.conn = signals
+	return e, signals
+}
+
+// sentTo returns the signals recorded since the last call that were sent
+// to dest, without the prefix.
+func sentTo(e *InputEngine, signals *signalRecorder, dest string) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var sent []string
+	for _, signal := range signals.take() {
+		if rest, ok := strings.CutPrefix(signal, dest+": "); ok {
+			sent = append(sent, rest)
+		}
+	}
+	return sent
+}
+
+func checkSent(t *testing.T, got, want []string) {
+	t.Helper()
+	if !slices.Equal(got, want) {
+		t.Errorf("signals\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSubmitKey(t *testing.T) {
+	e, signals := pipelineEngine()
+	for i, key := range "vieet " {
+		if err := e.SubmitKey(frontend, "editor", uint32(i+1), uint32(key), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkSent(t, sentTo(e, signals, frontend), []string{
+		`/Engine/Context/editor KeyProcessed 1 "" "v"`,
+		`/Engine/Context/editor KeyProcessed 2 "" "vi"`,
+		`/Engine/Context/editor KeyProcessed 3 "" "vie"`,
+		`/Engine/Context/editor KeyProcessed 4 "" "viê"`,
+		`/Engine/Context/editor KeyProcessed 5 "" "viêt"`,
+		`/Engine/Context/editor KeyProcessed 6 "viêt " ""`,
+	})
+
+	// Keys go to the named context, not the focused one
+	if preedit, _ := e.GetPreedit(""); preedit != "" {
+		t.Errorf("focused context: preedit = %q", preedit)
+	}
+	if err := e.SubmitKey(frontend, "editor", 0, 'a', 0); err == nil {
+		t.Error("SubmitKey should refuse key number 0")
+	}
+}
+
+func TestSubmitKey_OutOfOrder(t *testing.T) {
+	e, signals := pipelineEngine()
+	for _, seq := range []uint32{3, 2, 1, 4} {
+		e.SubmitKey(frontend, "", seq, uint32("aas "[seq-1]), 0)
+	}
+	checkSent(t, sentTo(e, signals, frontend), []string{
+		`/Engine/Context/_ KeyProcessed 1 "" "a"`,
+		`/Engine/Context/_ KeyProcessed 2 "" "â"`,
+		`/Engine/Context/_ KeyProcessed 3 "" "ấ"`,
+		`/Engine/Context/_ KeyProcessed 4 "ấ " ""`,
+	})
+	if got := e.metrics.stats()["keys-reordered"].Value(); got != uint64(2) {
+		t.Errorf("keys-reordered = %v, want 2", got)
+	}
+
+	// A key whose turn has passed is dropped
+	e.SubmitKey(frontend, "", 2, 'x', 0)
+	e.SubmitKey(frontend, "", 6, 'b', 0)
+	e.SubmitKey(frontend, "", 6, 'c', 0)
+	checkSent(t, sentTo(e, signals, frontend), []string{
+		`/Engine/Context/_ KeysLost 2 2`,
+		`/Engine/Context/_ KeysLost 6 6`,
+	})
+}
+
+func TestSubmitKey_Gap(t *testing.T) {
+	e, signals := pipelineEngine()
+	e.SubmitKey(frontend, "", 1, 'a', 0)
+	e.SubmitKey(frontend, "", 3, 'b', 0)
+	checkSent(t, sentTo(e, signals, frontend), []string{`/Engine/Context/_ KeyProcessed 1 "" "a"`})
+
+	// Key 2 never comes
+	deadline := time.Now().Add(10 * gapTimeout)
+	var sent []string
+	for len(sent) < 2 && time.Now().Before(deadline) {
+		time.Sleep(gapTimeout / 10)
+		sent = append(sent, sentTo(e, signals, frontend)...)
+	}
+	checkSent(t, sent, []string{
+		`/Engine/Context/_ KeysLost 2 2`,
+		`/Engine/Context/_ KeyProcessed 3 "" "ab"`,
+	})
+
+	// Too many keys waiting behind a missing one
+	for seq := uint32(5); seq <= 5+maxKeysAhead; seq++ {
+		e.SubmitKey(frontend, "", seq, engine.KeyShiftL, engine.ModRelease)
+	}
+	sent = sentTo(e, signals, frontend)
+	if len(sent) != maxKeysAhead+2 || sent[0] != `/Engine/Context/_ KeysLost 4 4` {
+		t.Errorf("%d signals, first %q", len(sent), sent[0])
+	}
+	if got := e.metrics.stats()["keys-lost"].Value(); got != uint64(2) {
+		t.Errorf("keys-lost = %v, want 2", got)
+	}
+}
+
+func TestSubmitKey_Restart(t *testing.T) {
+	e, signals := pipelineEngine()
+	e.SubmitKey(frontend, "editor", 1, 'a', 0)
+	e.SubmitKey(frontend, "editor", 3, 'b', 0)
+	checkSent(t, sentTo(e, signals, frontend), []string{`/Engine/Context/editor KeyProcessed 1 "" "a"`})
+
+	// Another connection numbers from 1 again, and the waiting key is dropped
+	e.SubmitKey(":1.8", "editor", 1, 'c', 0)
+	checkSent(t, sentTo(e, signals, ":1.8"), []string{`/Engine/Context/editor KeyProcessed 1 "" "ac"`})
+
+	// So does destroying the context
+	e.DestroyContext("", "editor")
+	e.SubmitKey(":1.8", "editor", 1, 'd', 0)
+	checkSent(t, sentTo(e, signals, ":1.8"), []string{`/Engine/Context/editor KeyProcessed 1 "" "d"`})
+
+	// The first connection hears nothing more, not even about key 2
+	time.Sleep(2 * gapTimeout)
+	if sent := sentTo(e, signals, frontend); len(sent) != 0 {
+		t.Errorf("signals after the restart: %q", sent)
+	}
+}
+
+func TestSubmitKey_RecoversFromPanic(t *testing.T) {
+	e := crashingEngine(t)
+	signals := new(signalRecorder)
+	e.conn = signals
+	for i, key := range "as" {
+		e.SubmitKey(frontend, "editor", uint32(i+1), uint32(key), 0)
+	}
+	checkSent(t, sentTo(e, signals, frontend), []string{
+		`/Engine/Context/editor KeyProcessed 1 "" "a"`,
+		`/Engine/Context/editor KeyFailed 2 "internal error in SubmitKey: runtime error: index out of range [1] with length 0" "a"`,
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// startRecording records the session to a new file at path for
+// goviet-replay. The returned file must be closed when the daemon exits.
+func (e *InputEngine) startRecording(path string, anonymize bool) (io.Closer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.recordTo(f, anonymize); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &recordingFile{e, f}, nil
+}
+
+// recordingFile is the file of a recording. Closing it first writes the
+// keys an anonymizing recorder holds back.
+type recordingFile struct {
+	e *InputEngine
+	f *os.File
+}
+
+func (r *recordingFile) Close() error {
+	r.e.mu.Lock()
+	if r.e.recorder != nil {
+		r.e.stopRecordingOn(r.e.recorder.Flush())
+		r.e.recorder = nil
+	}
+	r.e.mu.Unlock()
+	return r.f.Close()
+}
+
+// recordTo starts recording the session to w.
+func (e *InputEngine) recordTo(w io.Writer, anonymize bool) error {
+	recorder, err := engine.NewSessionWriter(w, e.config, anonymize)
+	if err != nil {
+		return err
+	}
+	e.recorder = recorder
+	return nil
+}
+
+// recordKey records a key event of ctx, its result and its stats.
+func (e *InputEngine) recordKey(ctx *inputContext, event engine.KeyEvent, result engine.ProcessResult, stats engine.KeyStats) {
+	if e.recorder != nil {
+		e.stopRecordingOn(e.recorder.Key(ctx.id, event, result, stats))
+	}
+}
+
+// recordEvent records a call other than a key event.
+func (e *InputEngine) recordEvent(op, id string) {
+	if e.recorder != nil {
+		e.stopRecordingOn(e.recorder.Event(op, id))
+	}
+}
+
+// stopRecordingOn stops recording after a write error; typing goes on.
+func (e *InputEngine) stopRecordingOn(err error) {
+	if err != nil {
+		e.log.Error("recording stopped", "err", err)
+		e.recorder = nil
+	}
+}
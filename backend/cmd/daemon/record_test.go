@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+func TestRecording(t *testing.T) {
+	e := NewInputEngine(nil)
+	var buf bytes.Buffer
+	if err := e.recordTo(&buf, false); err != nil {
+		t.Fatal(err)
+	}
+
+	e.FocusIn("", "editor")
+	e.ProcessKey("", 'a', 0)
+	e.ProcessKey("", 'a', 0)
+	e.Reset("")
+	e.SetEnabled("", false)
+	e.FocusOut("", "editor")
+	e.DestroyContext("", "editor")
+
+	header, events, err := engine.ReadSession(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Config.InputMethodName != "Telex" || header.Anonymized {
+		t.Errorf("header = %+v", header)
+	}
+
+	var ops []string
+	for _, event := range events {
+		ops = append(ops, event.Op+" "+event.Context)
+	}
+	want := []string{"focus-in editor", "key editor", "key editor", "reset editor", "disable editor", "focus-out editor", "destroy editor"}
+	if strings.Join(ops, ", ") != strings.Join(want, ", ") {
+		t.Errorf("events = %v, want %v", ops, want)
+	}
+	if second := events[2]; second.Key != "a" || second.Preedit != "â" || !second.Handled {
+		t.Errorf("second key = %+v", second)
+	}
+}
+
+// failingWriter fails every write after the first.
+type failingWriter struct{ writes int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes > 1 {
+		return 0, errors.New("disk full")
+	}
+	return len(p), nil
+}
+
+func TestRecording_WriteError(t *testing.T) {
+	e := NewInputEngine(nil)
+	if err := e.recordTo(&failingWriter{}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Typing goes on without the recording
+	e.ProcessKey("", 'a', 0)
+	if e.recorder != nil {
+		t.Error("recording should stop after a write error")
+	}
+	if _, _, preedit, _ := e.ProcessKey("", 'a', 0); preedit != "â" {
+		t.Errorf("preedit = %q, want â", preedit)
+	}
+}
+
+func TestRecording_CloseWritesHeldKeys(t *testing.T) {
+	e := NewInputEngine(nil)
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	recording, err := e.startRecording(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The anonymizer holds the keys of a word back until it ends
+	e.ProcessKey("", 't', 0)
+	e.ProcessKey("", 'o', 0)
+	e.ProcessKey("", 'o', 0)
+	if err := recording.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, events, err := engine.ReadSession(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[2].Key != "o" || events[2].Preedit != "bâ" {
+		t.Errorf("events = %+v", events)
+	}
+}
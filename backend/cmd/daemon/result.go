@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/godbus/dbus/v5"
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// processResultVersion is the version of the ProcessKey2 reply dictionary.
+// New keys may be added without bumping it; clients must ignore keys they
+// do not know. The version only changes when an existing key changes meaning.
+const processResultVersion uint32 = 1
+
+// preeditSegment is the D-Bus representation of a preedit run: (su).
+type preeditSegment struct {
+	Text string
+	Attr uint32
+}
+
+// surroundingDeletion is the D-Bus representation of a delete range: (iu).
+type surroundingDeletion struct {
+	Offset int32
+	Length uint32
+}
+
+// processResultToMap converts a ProcessResult into the ProcessKey2 reply.
+//
+//	version            u      reply format version
+//	handled            b      whether the key was consumed
+//	commit             s      text to commit
+//	preedit            s      full preedit text
+//	preedit-segments   a(su)  preedit runs with engine.PreeditAttr flags
+//	cursor             i      caret position within the preedit, in characters
+//	candidates         as     candidate list
+//	delete-surrounding (iu)   offset and length of text to delete around the caret
+//	mode               s      input mode indicator ("vi" or "en")
+func processResultToMap(result engine.ProcessResult) map[string]dbus.Variant {
+	segments := make([]preeditSegment, 0, len(result.Segments))
+	for _, seg := range result.Segments {
+		segments = append(segments, preeditSegment{Text: seg.Text, Attr: uint32(seg.Attr)})
+	}
+
+	candidates := result.Candidates
+	if candidates == nil {
+		candidates = []string{}
+	}
+
+	return map[string]dbus.Variant{
+		"version":          dbus.MakeVariant(processResultVersion),
+		"handled":          dbus.MakeVariant(result.Handled),
+		"commit":           dbus.MakeVariant(result.CommitText),
+		"preedit":          dbus.MakeVariant(result.Preedit),
+		"preedit-segments": dbus.MakeVariant(segments),
+		"cursor":           dbus.MakeVariant(int32(result.Cursor)),
+		"candidates":       dbus.MakeVariant(candidates),
+		"delete-surrounding": dbus.MakeVariant(surroundingDeletion{
+			Offset: int32(result.DeleteSurrounding.Offset),
+			Length: uint32(result.DeleteSurrounding.Length),
+		}),
+		"mode": dbus.MakeVariant(result.Mode.String()),
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestProcessKey2_Reply(t *testing.T) {
+	e := NewInputEngine(nil)
+
+	e.ProcessKey2("", 'a', 0)
+	reply, dbusErr := e.ProcessKey2("", 's', 0)
+	if dbusErr != nil {
+		t.Fatalf("ProcessKey2 error: %v", dbusErr)
+	}
+
+	signatures := map[string]string{
+		"version":            "u",
+		"handled":            "b",
+		"commit":             "s",
+		"preedit":            "s",
+		"preedit-segments":   "a(su)",
+		"cursor":             "i",
+		"candidates":         "as",
+		"delete-surrounding": "(iu)",
+		"mode":               "s",
+	}
+	for key, sig := range signatures {
+		v, ok := reply[key]
+		if !ok {
+			t.Errorf("reply missing key %q", key)
+			continue
+		}
+		if got := v.Signature().String(); got != sig {
+			t.Errorf("reply[%q] signature = %s, want %s", key, got, sig)
+		}
+	}
+
+	if got := reply["preedit"].Value(); got != "á" {
+		t.Errorf("preedit = %v, want á", got)
+	}
+	if got := reply["cursor"].Value(); got != int32(1) {
+		t.Errorf("cursor = %v, want 1", got)
+	}
+	if got := reply["mode"].Value(); got != "vi" {
+		t.Errorf("mode = %v, want vi", got)
+	}
+}
+
+func TestProcessKey_StillWorks(t *testing.T) {
+	e := NewInputEngine(nil)
+
+	e.ProcessKey("", 'a', 0)
+	handled, commit, preedit, dbusErr := e.ProcessKey("", ' ', 0)
+	if dbusErr != nil {
+		t.Fatalf("ProcessKey error: %v", dbusErr)
+	}
+	if !handled || commit != "a " || preedit != "" {
+		t.Errorf("ProcessKey(space) = (%v, %q, %q), want (true, \"a \", \"\")", handled, commit, preedit)
+	}
+}
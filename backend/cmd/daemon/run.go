@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// runDaemon runs the daemon until it is stopped and returns the exit status.
+func runDaemon(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", "", "configuration file (default $XDG_CONFIG_HOME/goviet-ime/config)")
+	replace := flags.Bool("replace", false, "take over from a daemon that is already running and allows it")
+	allowReplacement := flags.Bool("allow-replacement", false, "let a later run --replace take over; any program of the same user can then take the name")
+	record := flags.String("record", "", "record key events and results to this file for goviet-replay")
+	anonymize := flags.Bool("anonymize", false, "replace most letters of the recording; marks, tones and word lengths still show, so this is not a privacy guarantee")
+	logLevel := flags.String("log-level", "off", "log level: off, error, warn, info or debug")
+	logContent := flags.String("log-content", "redacted", "what debug logs show of the text typed: redacted or full")
+	signalContent := flags.String("signal-content", "redacted", "what PreeditChanged and Committed signals show of the text typed: redacted or full")
+	logFile := flags.String("log-file", "", "log file, - for stderr (default $XDG_STATE_HOME/goviet-ime/daemon.log)")
+	metricsFile := flags.String("metrics-file", "", "write metrics in the OpenMetrics text format to this file every 10s")
+	allowCallers := flags.String("allow-callers", "", "comma-separated executables, by path or name, allowed to send keys and change settings; * allows any (default the fcitx5 on PATH and this program, by path)")
+	idleTimeout := flags.Duration("idle-timeout", 0, "exit after this long without method calls, e.g. 30m; 0 never exits (D-Bus activation starts the daemon again)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(stderr, "run: unexpected argument %q\n", flags.Arg(0))
+		return 2
+	}
+
+	level, err := parseLogLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	content, err := parseLogContent(*logContent)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	signalShows, err := parseLogContent(*signalContent)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	callers := defaultCallers(exec.LookPath)
+	if *allowCallers != "" {
+		if callers, err = parseCallers(*allowCallers); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+	}
+
+	// 1. Read the configuration; a file named with --config must exist
+	configRequired := *configFile != ""
+	if !configRequired {
+		if *configFile, err = configPath(); err != nil {
+			fmt.Fprintln(stderr, "Failed to find the configuration directory:", err)
+			return 1
+		}
+	}
+	config, err := loadConfig(*configFile, configRequired)
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to read the configuration:", err)
+		return 1
+	}
+
+	// 2. Connect to Session Bus. Every method call counts as activity for
+	// --idle-timeout.
+	calls := make(chan struct{}, 1)
+	conn, err := dbus.ConnectSessionBus(dbus.WithIncomingInterceptor(func(msg *dbus.Message) {
+		if msg.Type == dbus.TypeMethodCall {
+			select {
+			case calls <- struct{}{}:
+			default:
+			}
+		}
+	}))
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to connect to session bus:", err)
+		return 1
+	}
+	defer conn.Close()
+
+	// 3. Setup Logging: nothing is written until the level allows it
+	var logOut io.Writer = stderr
+	if *logFile != "-" {
+		path := *logFile
+		if path == "" {
+			dir, err := stateDir()
+			if err != nil {
+				fmt.Fprintln(stderr, "Failed to find the state directory:", err)
+				return 1
+			}
+			path = filepath.Join(dir, "daemon.log")
+		}
+		file := newRotatingFile(path)
+		defer file.Close()
+		logOut = file
+	}
+	log := newLogging(logOut, level, content)
+
+	// 4. Create and export the engine
+	inputEngine := NewInputEngine(log)
+	if callers != nil {
+		inputEngine.auth = newAuthorizer(callers, busPeer(conn))
+	}
+	inputEngine.configFile, inputEngine.configRequired = *configFile, configRequired
+	inputEngine.setConfig(config)
+	inputEngine.conn, inputEngine.signalContent = busEmitter{conn}, signalShows
+	if dir, err := stateDir(); err == nil {
+		inputEngine.crashDir = dir
+	}
+
+	if *record != "" {
+		recording, err := inputEngine.startRecording(*record, *anonymize)
+		if err != nil {
+			fmt.Fprintln(stderr, "Failed to start recording:", err)
+			return 1
+		}
+		defer recording.Close()
+		log.Info("recording session", "file", *record, "anonymized", *anonymize)
+	}
+
+	if *metricsFile != "" {
+		stop := inputEngine.dumpMetrics(*metricsFile, metricsInterval)
+		defer stop()
+	}
+
+	if err := exportEngine(conn, inputEngine); err != nil {
+		fmt.Fprintln(stderr, "Failed to export object:", err)
+		return 1
+	}
+
+	// 5. Register Service Name. With -allow-replacement, later instances
+	// started with --replace may take it over, and this one then exits.
+	// Without it the name stays with this instance until it exits, so no
+	// other program of the user can take the name and read the keys.
+	lost := make(chan *dbus.Signal, 1)
+	conn.Signal(lost)
+	if err := conn.AddMatchSignal(dbus.WithMatchInterface("org.freedesktop.DBus"),
+		dbus.WithMatchMember("NameLost"), dbus.WithMatchArg(0, serviceName)); err != nil {
+		fmt.Fprintln(stderr, "Failed to watch the service name:", err)
+		return 1
+	}
+
+	nameFlags := dbus.NameFlagDoNotQueue
+	if *allowReplacement {
+		nameFlags |= dbus.NameFlagAllowReplacement
+	}
+	if *replace {
+		nameFlags |= dbus.NameFlagReplaceExisting
+	}
+	reply, err := conn.RequestName(serviceName, nameFlags)
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to request name:", err)
+		return 1
+	}
+
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		fmt.Fprintln(stderr, "Name already taken - another instance may be running (use --replace to take over one started with -allow-replacement)")
+		return 1
+	}
+
+	// 6. Print startup banner
+	fmt.Fprintln(stdout, "================================================")
+	fmt.Fprintln(stdout, "✅ GoViet-IME Backend is running!")
+	fmt.Fprintln(stdout, "================================================")
+	fmt.Fprintf(stdout, "  Service:     %s\n", serviceName)
+	fmt.Fprintf(stdout, "  Object Path: %s\n", objectPath)
+	fmt.Fprintf(stdout, "  Input Method: %s\n", config.InputMethodName)
+	fmt.Fprintf(stdout, "  Output Format: %s\n", config.OutputFormatName)
+	fmt.Fprintln(stdout, "------------------------------------------------")
+	fmt.Fprintln(stdout, "Waiting for key events...")
+	fmt.Fprintln(stdout)
+
+	if err := notify("READY=1"); err != nil {
+		log.Warn("failed to notify the service manager", "err", err)
+	}
+
+	// 7. Handle graceful shutdown: give the name back at once, so that the
+	// next call starts a new daemon instead of failing
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	shutdown := func(reason string) int {
+		log.Info("shutting down", "reason", reason)
+		notify("STOPPING=1")
+		if _, err := conn.ReleaseName(serviceName); err != nil {
+			log.Warn("failed to release the service name", "err", err)
+		}
+		return 0
+	}
+
+	var idle <-chan time.Time
+	var idleTimer *time.Timer
+	if *idleTimeout > 0 {
+		idleTimer = time.NewTimer(*idleTimeout)
+		idle = idleTimer.C
+	}
+
+	for {
+		select {
+		case sig := <-sigChan:
+			return shutdown(sig.String())
+		case <-inputEngine.stop:
+			return shutdown("Stop called")
+		case <-calls:
+			if idleTimer != nil {
+				idleTimer.Reset(*idleTimeout)
+			}
+		case <-idle:
+			return shutdown("idle")
+		case msg, ok := <-lost:
+			if !ok {
+				// godbus closes signal channels with the connection
+				log.Error("lost the connection to the bus")
+				return 1
+			}
+			if msg.Name == "org.freedesktop.DBus.NameLost" {
+				log.Info("shutting down", "reason", "replaced by another instance")
+				notify("STOPPING=1")
+				return 0
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Service
+//
+// The daemon runs as a systemd user service started by D-Bus activation: the
+// D-Bus service file names the unit, so the first call to
+// com.github.goviet.ime, usually the frontend's Reset when the input method
+// is activated, starts it. install-service writes both files for this
+// executable. The unit is Type=notify; the daemon reports READY=1 once it
+// owns its name and STOPPING=1 before it releases it.
+
+// unitName is the systemd user unit the D-Bus service file starts.
+const unitName = "goviet-ime.service"
+
+var dbusServiceTemplate = template.Must(template.New("dbus").Parse(`[D-BUS Service]
+Name={{.Name}}
+Exec={{.Exec}} run
+SystemdService={{.Unit}}
+`))
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=GoViet-IME Vietnamese input method engine
+
+[Service]
+Type=notify
+ExecStart={{.Exec}} run
+ExecReload={{.Exec}} reload
+Restart=on-failure
+
+[Install]
+WantedBy=default.target
+`))
+
+// serviceFile is a file written by install-service.
+type serviceFile struct {
+	path    string
+	content string
+}
+
+// serviceFiles returns the D-Bus service file and the systemd unit that run
+// the daemon at exe, in the user's directories.
+func serviceFiles(exe string) ([]serviceFile, error) {
+	if !filepath.IsAbs(exe) || strings.ContainsAny(exe, " \t\n") {
+		return nil, fmt.Errorf("%q: the daemon's path must be absolute and without spaces", exe)
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if !filepath.IsAbs(dataHome) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	configHome, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+
+	data := struct{ Name, Exec, Unit string }{serviceName, exe, unitName}
+	files := []struct {
+		path     string
+		template *template.Template
+	}{
+		{filepath.Join(dataHome, "dbus-1", "services", serviceName+".service"), dbusServiceTemplate},
+		{filepath.Join(configHome, "systemd", "user", unitName), unitTemplate},
+	}
+	var out []serviceFile
+	for _, f := range files {
+		var b strings.Builder
+		if err := f.template.Execute(&b, data); err != nil {
+			return nil, err
+		}
+		out = append(out, serviceFile{f.path, b.String()})
+	}
+	return out, nil
+}
+
+// installServiceCommand writes the D-Bus service file and the systemd unit.
+func installServiceCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("install-service", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	exe := flags.String("exec", "", "path of goviet-daemon written in the files (default this program)")
+	printOnly := flags.Bool("print", false, "print the files instead of writing them")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		return reportError(usageError(fmt.Sprintf("install-service: unexpected argument %q", flags.Arg(0))), stderr)
+	}
+	if *exe == "" {
+		path, err := os.Executable()
+		if err != nil {
+			return reportError(err, stderr)
+		}
+		*exe = path
+	}
+
+	files, err := serviceFiles(*exe)
+	if err != nil {
+		return reportError(err, stderr)
+	}
+	for _, f := range files {
+		if *printOnly {
+			fmt.Fprintf(stdout, "# %s\n%s\n", f.path, f.content)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+			return reportError(err, stderr)
+		}
+		if err := os.WriteFile(f.path, []byte(f.content), 0644); err != nil {
+			return reportError(err, stderr)
+		}
+		fmt.Fprintln(stdout, "wrote", f.path)
+	}
+	if !*printOnly {
+		fmt.Fprintln(stdout, `Run "systemctl --user daemon-reload"; the daemon then starts when it is first called.`)
+	}
+	return 0
+}
+
+// notify sends state, e.g. "READY=1", to the service manager as sd_notify
+// does. It does nothing when the daemon was not started by systemd.
+func notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// The net package reads a leading @ as an abstract socket
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServiceFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "data"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+
+	files, err := serviceFiles("/usr/bin/goviet-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ path, line string }{
+		{"data/dbus-1/services/com.github.goviet.ime.service", "SystemdService=goviet-ime.service\n"},
+		{"config/systemd/user/goviet-ime.service", "ExecStart=/usr/bin/goviet-daemon run\n"},
+	}
+	if len(files) != len(want) {
+		t.Fatalf("got %d files, want %d", len(files), len(want))
+	}
+	for i, w := range want {
+		if files[i].path != filepath.Join(dir, w.path) {
+			t.Errorf("path = %s, want %s", files[i].path, w.path)
+		}
+		if !strings.Contains(files[i].content, w.line) {
+			t.Errorf("%s lacks %q:\n%s", w.path, w.line, files[i].content)
+		}
+	}
+
+	for _, exe := range []string{"goviet-daemon", "/opt/go viet/goviet-daemon"} {
+		if _, err := serviceFiles(exe); err == nil {
+			t.Errorf("serviceFiles(%q) should fail", exe)
+		}
+	}
+}
+
+func TestInstallServiceCommand(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "data"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+
+	var stdout, stderr bytes.Buffer
+	if status := installServiceCommand([]string{"-exec", "/usr/bin/goviet-daemon"}, &stdout, &stderr); status != 0 {
+		t.Fatalf("status %d: %s", status, stderr.String())
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "data/dbus-1/services/com.github.goviet.ime.service"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[D-BUS Service]\nName=com.github.goviet.ime\nExec=/usr/bin/goviet-daemon run\nSystemdService=goviet-ime.service\n"
+	if string(content) != want {
+		t.Errorf("D-Bus service file:\n%s\nwant:\n%s", content, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "config/systemd/user/goviet-ime.service")); err != nil {
+		t.Error(err)
+	}
+
+	stdout.Reset()
+	installServiceCommand([]string{"-exec", "/usr/bin/goviet-daemon", "-print"}, &stdout, &stderr)
+	if !strings.Contains(stdout.String(), "Type=notify\n") {
+		t.Errorf("-print output:\n%s", stdout.String())
+	}
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := notify("READY=1"); err != nil {
+		t.Errorf("notify without a service manager: %v", err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	if err := notify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("read %q, %v", buf[:n], err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// Signals
+//
+// The daemon announces state changes with signals of the Engine1 interface,
+// so that tray applets and accessibility tools can follow the engine without
+// calling into it. Signals about one input context come from the context's
+// object path (see contextPath): a listener matches one context with
+// path=/Engine/Context/... or every context with path_namespace=/Engine.
+// Signals about the whole daemon come from /Engine.
+//
+//	PreeditChanged(text s, length u, cursor i)  context path
+//	Committed(text s, length u)                 context path
+//	ModeChanged(context s, mode s)              context path
+//	InputMethodChanged(input_method s)          /Engine
+//	ConfigReloaded(file s)                      /Engine
+//
+// Any process on the bus can receive signals, so PreeditChanged and
+// Committed carry the text only with run -signal-content full. Otherwise
+// text is empty and length, in characters, is all they show.
+
+// emitter sends signals; busEmitter is one.
+type emitter interface {
+	Emit(path dbus.ObjectPath, name string, values ...any) error
+	// EmitTo sends the signal to the connection dest only.
+	EmitTo(dest string, path dbus.ObjectPath, name string, values ...any) error
+}
+
+// busEmitter sends signals on a bus connection.
+type busEmitter struct{ *dbus.Conn }
+
+// EmitTo sends a signal with a destination, which the bus delivers to that
+// connection only.
+func (b busEmitter) EmitTo(dest string, path dbus.ObjectPath, name string, values ...any) error {
+	i := strings.LastIndex(name, ".")
+	msg := &dbus.Message{
+		Type: dbus.TypeSignal,
+		Headers: map[dbus.HeaderField]dbus.Variant{
+			dbus.FieldDestination: dbus.MakeVariant(dest),
+			dbus.FieldPath:        dbus.MakeVariant(path),
+			dbus.FieldInterface:   dbus.MakeVariant(name[:i]),
+			dbus.FieldMember:      dbus.MakeVariant(name[i+1:]),
+		},
+		Body: values,
+	}
+	if len(values) > 0 {
+		msg.Headers[dbus.FieldSignature] = dbus.MakeVariant(dbus.SignatureOf(values...))
+	}
+	return b.Send(msg, nil).Err
+}
+
+// contextPath returns the object path of the context with the given id.
+// Bytes other than ASCII letters and digits are escaped as _xx, and the
+// default context, whose id is empty, is "_".
+func contextPath(id string) dbus.ObjectPath {
+	if id == "" {
+		return objectPath + "/Context/_"
+	}
+	var b strings.Builder
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return dbus.ObjectPath(objectPath + "/Context/" + b.String())
+}
+
+// signal emits the named signal of the Engine1 interface from path.
+func (e *InputEngine) signal(path dbus.ObjectPath, name string, values ...any) {
+	e.signalTo("", path, name, values...)
+}
+
+// signalTo sends the named signal of the Engine1 interface from path to
+// the connection dest only, or to every listener when dest is empty.
+func (e *InputEngine) signalTo(dest string, path dbus.ObjectPath, name string, values ...any) {
+	if e.conn == nil {
+		return
+	}
+	var err error
+	if dest == "" {
+		err = e.conn.Emit(path, interfaceName+"."+name, values...)
+	} else {
+		err = e.conn.EmitTo(dest, path, interfaceName+"."+name, values...)
+	}
+	if err != nil {
+		e.log.Warn("failed to emit "+name, "err", err)
+	}
+}
+
+// signalText returns the text a signal carries: all of it with
+// -signal-content full, otherwise none.
+func (e *InputEngine) signalText(text string) string {
+	if e.signalContent == contentFull {
+		return text
+	}
+	return ""
+}
+
+// emitResult announces what a key event committed and how it changed the
+// preedit. The preedit is taken from the engine rather than the result, so
+// a key that leaves it alone, such as a release, never announces a change.
+func (e *InputEngine) emitResult(ctx *inputContext, result engine.ProcessResult) {
+	if result.CommitText != "" {
+		e.signal(contextPath(ctx.id), "Committed",
+			e.signalText(result.CommitText), uint32(utf8.RuneCountInString(result.CommitText)))
+	}
+	e.syncPreedit(ctx)
+}
+
+// syncPreedit announces the engine's preedit when it changed, by a key
+// event or otherwise, e.g. by Reset.
+func (e *InputEngine) syncPreedit(ctx *inputContext) {
+	e.emitPreedit(ctx, ctx.engine.GetPreedit(), ctx.engine.CaretPosition())
+}
+
+// emitPreedit sends PreeditChanged when the preedit or its caret moved
+// since the last signal for ctx.
+func (e *InputEngine) emitPreedit(ctx *inputContext, preedit string, cursor int) {
+	if preedit == ctx.preedit && cursor == ctx.cursor {
+		return
+	}
+	ctx.preedit, ctx.cursor = preedit, cursor
+	e.signal(contextPath(ctx.id), "PreeditChanged",
+		e.signalText(preedit), uint32(utf8.RuneCountInString(preedit)), int32(cursor))
+}
+
+// emitModeChanged sends the ModeChanged(context, mode) signal.
+func (e *InputEngine) emitModeChanged(ctx *inputContext, mode engine.InputMode) {
+	e.signal(contextPath(ctx.id), "ModeChanged", ctx.id, mode.String())
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// signalRecorder records emitted signals as "path Name args", prefixed with
+// "dest: " when sent to one connection. A ProcessKey2 result shows as its
+// commit and preedit.
+type signalRecorder []string
+
+func (r *signalRecorder) Emit(path dbus.ObjectPath, name string, values ...any) error {
+	return r.EmitTo("", path, name, values...)
+}
+
+func (r *signalRecorder) EmitTo(dest string, path dbus.ObjectPath, name string, values ...any) error {
+	args := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case string:
+			args[i] = fmt.Sprintf("%q", v)
+		case map[string]dbus.Variant: // A ProcessKey2 result
+			args[i] = fmt.Sprintf("%q %q", v["commit"].Value(), v["preedit"].Value())
+		default:
+			args[i] = fmt.Sprint(v)
+		}
+	}
+	if dest != "" {
+		dest += ": "
+	}
+	*r = append(*r, fmt.Sprintf("%s%s %s %s", dest, path, strings.TrimPrefix(name, interfaceName+"."), strings.Join(args, " ")))
+	return nil
+}
+
+// take returns the signals recorded since the last call.
+func (r *signalRecorder) take() []string {
+	signals := *r
+	*r = nil
+	return signals
+}
+
+func TestContextPath(t *testing.T) {
+	tests := map[string]dbus.ObjectPath{
+		"":         "/Engine/Context/_",
+		"editor":   "/Engine/Context/editor",
+		"editor-1": "/Engine/Context/editor_2d1",
+		"a_b":      "/Engine/Context/a_5fb",
+		"ô":        "/Engine/Context/_c3_b4",
+	}
+	for id, want := range tests {
+		if got := contextPath(id); got != want || !got.IsValid() {
+			t.Errorf("contextPath(%q) = %s, want %s", id, got, want)
+		}
+	}
+}
+
+func TestSignals_Typing(t *testing.T) {
+	for _, content := range []logContent{contentRedacted, contentFull} {
+		e := NewInputEngine(nil)
+		signals := new(signalRecorder)
+		e.conn, e.signalContent = signals, content
+		e.FocusIn("", "editor")
+
+		for _, key := range "vieet " {
+			e.ProcessKey("", uint32(key), 0)
+		}
+		text := func(s string) string {
+			if content == contentRedacted {
+				s = ""
+			}
+			return fmt.Sprintf("%q", s)
+		}
+		want := []string{
+			"/Engine/Context/editor PreeditChanged " + text("v") + " 1 1",
+			"/Engine/Context/editor PreeditChanged " + text("vi") + " 2 2",
+			"/Engine/Context/editor PreeditChanged " + text("vie") + " 3 3",
+			"/Engine/Context/editor PreeditChanged " + text("viê") + " 3 3",
+			"/Engine/Context/editor PreeditChanged " + text("viêt") + " 4 4",
+			"/Engine/Context/editor Committed " + text("viêt ") + " 5",
+			`/Engine/Context/editor PreeditChanged "" 0 0`,
+		}
+		if got := signals.take(); !slices.Equal(got, want) {
+			t.Errorf("content %d: signals\n%s\nwant\n%s", content, strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+
+		// Reset only announces a preedit that was there
+		e.Reset("")
+		e.ProcessKey("", 'a', 0)
+		e.Reset("")
+		e.Reset("")
+		if got := signals.take(); len(got) != 2 || got[1] != `/Engine/Context/editor PreeditChanged "" 0 0` {
+			t.Errorf("content %d: signals after Reset: %q", content, got)
+		}
+	}
+}
+
+func TestSignals_Releases(t *testing.T) {
+	e := NewInputEngine(nil)
+	signals := new(signalRecorder)
+	e.conn, e.signalContent = signals, contentFull
+
+	// Releases and ignored keys leave the preedit as it is
+	for _, key := range []engine.KeyEvent{
+		{KeySym: 'v'}, {KeySym: 'v', Modifiers: engine.ModRelease},
+		{KeySym: 'i'}, {KeySym: 'i', Modifiers: engine.ModRelease},
+		{KeySym: engine.KeyShiftL}, {KeySym: engine.KeyShiftL, Modifiers: engine.ModRelease},
+	} {
+		e.ProcessKey("", key.KeySym, key.Modifiers)
+	}
+	want := []string{
+		`/Engine/Context/_ PreeditChanged "v" 1 1`,
+		`/Engine/Context/_ PreeditChanged "vi" 2 2`,
+	}
+	if got := signals.take(); !slices.Equal(got, want) {
+		t.Errorf("signals\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// So does the result of a pipelined release
+	e.SubmitKey(frontend, "", 1, 'v', engine.ModRelease)
+	checkSent(t, sentTo(e, signals, frontend), []string{`/Engine/Context/_ KeyProcessed 1 "" "vi"`})
+}
+
+func TestSignals_State(t *testing.T) {
+	e := NewInputEngine(nil)
+	signals := new(signalRecorder)
+	e.conn = signals
+	e.FocusIn("", "a")
+	e.FocusIn("", "b")
+	signals.take()
+
+	// A global mode switch reaches every context
+	e.SetEnabled("", false)
+	want := []string{
+		`/Engine/Context/_ ModeChanged "" "en"`,
+		`/Engine/Context/a ModeChanged "a" "en"`,
+		`/Engine/Context/b ModeChanged "b" "en"`,
+	}
+	got := signals.take()
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("signals\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	e.Set("", "rule", "old")
+	e.Set("", "method", "VNI")
+	if got := signals.take(); !slices.Equal(got, []string{`/Engine InputMethodChanged "VNI"`}) {
+		t.Errorf("signals after Set: %q", got)
+	}
+
+	e.configFile = writeConfig(t, "method = VNI\n")
+	e.Reload("")
+	want = []string{fmt.Sprintf(`/Engine ConfigReloaded %q`, e.configFile)}
+	if got := signals.take(); !slices.Equal(got, want) {
+		t.Errorf("signals after Reload: %q, want %q", got, want)
+	}
+}
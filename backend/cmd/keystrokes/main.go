@@ -0,0 +1,86 @@
+// Command goviet-keystrokes prints the keys that type Vietnamese text with
+// an input method.
+//
+//	goviet-keystrokes người                 # nguwowif
+//	goviet-keystrokes -method vni người     # ngu7o7i2
+//	goviet-keystrokes -all người            # every sequence, one per line
+//	echo "Tiếng Việt" | goviet-keystrokes   # reads stdin without arguments
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+func main() {
+	methodName := flag.String("method", "Telex", "input method: "+strings.Join(engine.InputMethods(), ", "))
+	all := flag.Bool("all", false, "print every key sequence of each word instead of the canonical one")
+	flag.Parse()
+
+	method, err := engine.NewInputMethod(*methodName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "goviet-keystrokes:", err)
+		os.Exit(2)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	if flag.NArg() > 0 {
+		err = convert(out, method, strings.Join(flag.Args(), " "), *all)
+	} else {
+		err = convertLines(out, method, os.Stdin, *all)
+	}
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "goviet-keystrokes:", err)
+		os.Exit(1)
+	}
+}
+
+// convertLines converts r line by line.
+func convertLines(w io.Writer, method engine.InputMethod, r io.Reader, all bool) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if err := convert(w, method, scanner.Text(), all); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// convert writes the keystrokes for one line of text. With all set it
+// writes each word followed by all of its key sequences instead.
+func convert(w io.Writer, method engine.InputMethod, text string, all bool) error {
+	if !all {
+		keys, err := engine.TextKeystrokes(method, text)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, keys)
+		return err
+	}
+
+	for _, word := range strings.FieldsFunc(text, isSeparator) {
+		sequences, err := engine.Keystrokes(method, word)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", word, strings.Join(sequences, "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isSeparator reports whether r separates words.
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r)
+}
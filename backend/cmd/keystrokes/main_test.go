@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+func TestConvertLines(t *testing.T) {
+	var out bytes.Buffer
+	in := strings.NewReader("Tiếng Việt\nngười ơi!\n")
+	if err := convertLines(&out, engine.NewVNIMethod(), in, false); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Tie6ng1 Vie6t5\nngu7o7i2 o7i!\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestConvert_All(t *testing.T) {
+	var out bytes.Buffer
+	if err := convert(&out, engine.NewTelexMethod(), "hòa, quá", true); err != nil {
+		t.Fatal(err)
+	}
+	if want := "hòa\thoaf\thofa\nquá\tquas\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestConvert_Error(t *testing.T) {
+	var out bytes.Buffer
+	if err := convert(&out, engine.NewTelexMethod(), "café", false); err == nil {
+		t.Errorf("want an error, got %q", out.String())
+	}
+}
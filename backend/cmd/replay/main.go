@@ -0,0 +1,191 @@
+// Command goviet-replay replays session recordings of the daemon against
+// the current engine and prints every key whose commit or preedit differs.
+//
+//	goviet-daemon -record session.jsonl     # record while typing
+//	goviet-replay session.jsonl             # replay, exit status 1 on differences
+//	goviet-replay -v session.jsonl          # print every key
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+func main() {
+	verbose := flag.Bool("v", false, "print every key, not only the ones that differ")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: goviet-replay [-v] session.jsonl...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	differ := false
+	for _, path := range flag.Args() {
+		n, err := replayFile(os.Stdout, path, *verbose)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "goviet-replay:", err)
+			os.Exit(2)
+		}
+		differ = differ || n > 0
+	}
+	if differ {
+		os.Exit(1)
+	}
+}
+
+// replayFile replays one session file and returns the number of keys whose
+// result differs.
+func replayFile(w io.Writer, path string, verbose bool) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	header, events, err := engine.ReadSession(f)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	fmt.Fprintf(w, "%s: recorded %s with %s\n", path, header.Created.Format("2006-01-02 15:04"), header.Config.InputMethodName)
+	return replay(w, header, events, verbose), nil
+}
+
+// replay runs the events through new engines and writes the keys whose
+// result differs from the recording.
+func replay(w io.Writer, header engine.SessionHeader, events []engine.SessionEvent, verbose bool) int {
+	p := newPlayer(header)
+	keys, differ := 0, 0
+	for i, event := range events {
+		if event.Op != engine.SessionKey {
+			p.apply(event)
+			continue
+		}
+		keys++
+		got := p.key(event)
+		want := engine.SessionEvent{Handled: event.Handled, Commit: event.Commit, Preedit: event.Preedit}
+		same := got == want
+		if !same {
+			differ++
+		}
+		if same && !verbose {
+			continue
+		}
+
+		fmt.Fprintf(w, "#%d %s %s\n", i+1, contextName(event.Context), event.Key)
+		if same {
+			fmt.Fprintf(w, "    %s\n", formatResult(got))
+		} else {
+			fmt.Fprintf(w, "  - %s\n  + %s\n", formatResult(want), formatResult(got))
+		}
+	}
+
+	fmt.Fprintf(w, "%d keys, %d differ\n", keys, differ)
+	return differ
+}
+
+// contextName names a context in the output.
+func contextName(id string) string {
+	if id == "" {
+		return "(default)"
+	}
+	return id
+}
+
+// formatResult writes the recorded part of a key's result.
+func formatResult(e engine.SessionEvent) string {
+	return fmt.Sprintf("handled=%v commit=%q preedit=%q", e.Handled, e.Commit, e.Preedit)
+}
+
+// player keeps the input contexts of a replayed session the way the daemon
+// keeps them: one engine per context, with the Vietnamese/English mode
+// shared unless the config keeps it per context.
+type player struct {
+	config    *engine.EngineConfig
+	contexts  map[string]*engine.ConfiguredEngine
+	current   *engine.ConfiguredEngine
+	anonymize *engine.Anonymizer
+}
+
+func newPlayer(header engine.SessionHeader) *player {
+	p := &player{
+		config:    header.Config,
+		contexts:  make(map[string]*engine.ConfiguredEngine),
+		anonymize: header.Anonymizer(),
+	}
+	p.current = p.context("")
+	return p
+}
+
+// context returns the engine of a context, creating it in the current mode.
+func (p *player) context(id string) *engine.ConfiguredEngine {
+	if ctx, ok := p.contexts[id]; ok {
+		return ctx
+	}
+	ctx := engine.NewConfiguredEngine(p.config)
+	if !p.config.ModePerContext && p.current != nil {
+		ctx.SetEnabled(p.current.IsEnabled())
+	}
+	p.contexts[id] = ctx
+	return ctx
+}
+
+// key types a recorded key and returns the result as it would be recorded.
+func (p *player) key(event engine.SessionEvent) engine.SessionEvent {
+	keys, _ := engine.ParseKeyScript(event.Key)
+	ctx := p.context(event.Context)
+	before := ctx.Mode()
+	result := ctx.ProcessKey(keys[0])
+	p.syncMode(ctx, before)
+
+	got := engine.SessionEvent{Handled: result.Handled, Commit: result.CommitText, Preedit: result.Preedit}
+	if p.anonymize != nil {
+		got.Commit, got.Preedit = p.anonymize.Text(got.Commit), p.anonymize.Text(got.Preedit)
+	}
+	return got
+}
+
+// apply replays an event other than a key.
+func (p *player) apply(event engine.SessionEvent) {
+	switch event.Op {
+	case engine.SessionFocusIn:
+		p.current = p.context(event.Context)
+	case engine.SessionFocusOut, engine.SessionReset:
+		if ctx, ok := p.contexts[event.Context]; ok {
+			ctx.Reset()
+		}
+	case engine.SessionEnable, engine.SessionDisable:
+		ctx := p.context(event.Context)
+		before := ctx.Mode()
+		ctx.SetEnabled(event.Op == engine.SessionEnable)
+		p.syncMode(ctx, before)
+	case engine.SessionDestroy:
+		if p.contexts[event.Context] == p.current {
+			p.current = nil
+		}
+		delete(p.contexts, event.Context)
+		if p.current == nil {
+			p.current = p.context("")
+		}
+	}
+}
+
+// syncMode gives the other contexts the mode of ctx when the mode is global.
+func (p *player) syncMode(ctx *engine.ConfiguredEngine, before engine.InputMode) {
+	mode := ctx.Mode()
+	if mode == before || p.config.ModePerContext {
+		return
+	}
+	for _, other := range p.contexts {
+		if other != ctx {
+			other.SetEnabled(mode == engine.ModeVietnamese)
+		}
+	}
+}
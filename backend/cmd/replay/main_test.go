@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// session is a recording of "Tiếng" typed in an editor, switched to English
+// with Alt+Z in a terminal, and "ok" typed back in the editor.
+const session = `{"version":1,"created":"2026-01-02T03:04:05Z","config":{"ToneRule":1,"EnableValidation":true,"EnableDoubleKeyRevert":true,"EnableWAsVowel":true,"InputMethodName":"Telex","OutputFormatName":"Unicode","ToggleHotkey":"alt+z"}}
+{"op":"focus-in","ctx":"editor"}
+{"op":"key","ctx":"editor","key":"<S-t>","handled":true,"preedit":"T"}
+{"op":"key","ctx":"editor","key":"i","handled":true,"preedit":"Ti"}
+{"op":"key","ctx":"editor","key":"e","handled":true,"preedit":"Tie"}
+{"op":"key","ctx":"editor","key":"e","handled":true,"preedit":"Tiê"}
+{"op":"key","ctx":"editor","key":"n","handled":true,"preedit":"Tiên"}
+{"op":"key","ctx":"editor","key":"g","handled":true,"preedit":"Tiêng"}
+{"op":"key","ctx":"editor","key":"s","handled":true,"preedit":"Tiếng"}
+{"op":"key","ctx":"editor","key":"<Space>","handled":true,"commit":"Tiếng "}
+{"op":"focus-out","ctx":"editor"}
+{"op":"focus-in","ctx":"terminal"}
+{"op":"key","ctx":"terminal","key":"<A-z>","handled":true}
+{"op":"focus-in","ctx":"editor"}
+{"op":"key","ctx":"editor","key":"o"}
+{"op":"key","ctx":"editor","key":"k"}
+`
+
+func readSession(t *testing.T, data string) (engine.SessionHeader, []engine.SessionEvent) {
+	t.Helper()
+	header, events, err := engine.ReadSession(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header, events
+}
+
+func TestReplay_Same(t *testing.T) {
+	header, events := readSession(t, session)
+	var out bytes.Buffer
+	if n := replay(&out, header, events, false); n != 0 {
+		t.Errorf("%d keys differ:\n%s", n, out.String())
+	}
+	if want := "11 keys, 0 differ\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestReplay_Differs(t *testing.T) {
+	// A recording made while the tone went on the wrong vowel
+	data := strings.Replace(session, `"preedit":"Tiếng"}`, `"preedit":"Tíêng"}`, 1)
+	header, events := readSession(t, data)
+	var out bytes.Buffer
+	if n := replay(&out, header, events, false); n != 1 {
+		t.Errorf("%d keys differ, want 1:\n%s", n, out.String())
+	}
+	want := `#8 editor s
+  - handled=true commit="" preedit="Tíêng"
+  + handled=true commit="" preedit="Tiếng"
+11 keys, 1 differ
+`
+	if out.String() != want {
+		t.Errorf("output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestReplay_Anonymized(t *testing.T) {
+	config := engine.DefaultConfig()
+	e := engine.NewConfiguredEngine(config)
+	var buf bytes.Buffer
+	w, err := engine.NewSessionWriter(&buf, config, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := engine.ParseKeyScript("tooi<Space>ddi<Space>nguwowif<Space>ww")
+	for _, key := range keys {
+		if err := w.Key("", key, e.ProcessKey(key), e.LastKeyStats()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	header, events := readSession(t, buf.String())
+	var out bytes.Buffer
+	if n := replay(&out, header, events, true); n != 0 {
+		t.Errorf("%d keys differ:\n%s", n, out.String())
+	}
+	if !strings.Contains(out.String(), `#5 (default) <Space>`) {
+		t.Errorf("verbose output should list every key:\n%s", out.String())
+	}
+}
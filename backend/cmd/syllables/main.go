@@ -0,0 +1,156 @@
+// Command goviet-syllables exports the Vietnamese syllable inventory as
+// plain text, JSON or a Hunspell dictionary.
+//
+//	goviet-syllables                          # one syllable per line
+//	goviet-syllables -format json -o vi.json
+//	goviet-syllables -format hunspell -o vi   # writes vi.dic and vi.aff
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+// toneNames are the JSON names of the tones, indexed by engine.ToneMark.
+var toneNames = []string{"ngang", "sac", "huyen", "hoi", "nga", "nang"}
+
+// hunspellTry lists the letters Hunspell tries when suggesting corrections,
+// most frequent first.
+const hunspellTry = "nhgtcaiuoươêôâăeyđmklbdvsxrpqàáảãạằắẳẵặầấẩẫậèéẻẽẹềếểễệìíỉĩịòóỏõọồốổỗộờớởỡợùúủũụừứửữựỳýỷỹỵ"
+
+// jsonEntry is one syllable in the JSON export.
+type jsonEntry struct {
+	Text   string     `json:"text"`
+	Onset  string     `json:"onset"`
+	Rhyme  string     `json:"rhyme"`
+	Tone   string     `json:"tone"`
+	Parsed jsonParsed `json:"parsed"`
+}
+
+// jsonParsed is the syllable as the engine parses it.
+type jsonParsed struct {
+	Onset   string `json:"onset"`
+	Nucleus string `json:"nucleus"`
+	Coda    string `json:"coda"`
+}
+
+func main() {
+	format := flag.String("format", "text", "output format: text, json or hunspell")
+	rule := flag.String("rule", "new", "tone placement rule: new (hoà) or old (hòa)")
+	output := flag.String("o", "", "output file, or base name of the .dic/.aff pair for hunspell (default stdout)")
+	flag.Parse()
+
+	if err := run(*format, *rule, *output); err != nil {
+		fmt.Fprintln(os.Stderr, "goviet-syllables:", err)
+		os.Exit(1)
+	}
+}
+
+func run(format, rule, output string) error {
+	var tones engine.TonePlacement
+	switch strings.ToLower(rule) {
+	case "new":
+		tones.Rule = engine.ToneRuleNew
+	case "old":
+		tones.Rule = engine.ToneRuleOld
+	default:
+		return fmt.Errorf("unknown tone rule %q", rule)
+	}
+	entries := engine.Inventory(tones)
+
+	switch strings.ToLower(format) {
+	case "text":
+		return writeFile(output, func(w io.Writer) error { return writeText(w, entries) })
+	case "json":
+		return writeFile(output, func(w io.Writer) error { return writeJSON(w, entries) })
+	case "hunspell":
+		if output == "" {
+			return fmt.Errorf("hunspell output needs -o with the dictionary base name")
+		}
+		if err := writeFile(output+".dic", func(w io.Writer) error { return writeDic(w, entries) }); err != nil {
+			return err
+		}
+		return writeFile(output+".aff", writeAff)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// writeFile runs write on the named file, or on stdout when name is empty.
+func writeFile(name string, write func(io.Writer) error) error {
+	if name == "" {
+		w := bufio.NewWriter(os.Stdout)
+		if err := write(w); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeText writes one syllable per line.
+func writeText(w io.Writer, entries []engine.InventoryEntry) error {
+	for _, e := range entries {
+		if _, err := fmt.Fprintln(w, e.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeJSON writes the syllables as a JSON array.
+func writeJSON(w io.Writer, entries []engine.InventoryEntry) error {
+	out := make([]jsonEntry, len(entries))
+	for i, e := range entries {
+		out[i] = jsonEntry{
+			Text:  e.Text,
+			Onset: e.Onset,
+			Rhyme: e.Rhyme,
+			Tone:  toneNames[e.Syllable.ToneMark],
+			Parsed: jsonParsed{
+				Onset:   e.Syllable.Onset,
+				Nucleus: e.Syllable.MarkedNucleus(),
+				Coda:    e.Syllable.Coda,
+			},
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// writeDic writes a Hunspell .dic file: the word count, then one word per
+// line. Hunspell accepts the capitalized and upper-case forms by itself.
+func writeDic(w io.Writer, entries []engine.InventoryEntry) error {
+	if _, err := fmt.Fprintln(w, len(entries)); err != nil {
+		return err
+	}
+	return writeText(w, entries)
+}
+
+// writeAff writes the Hunspell .aff file matching writeDic. Syllables take
+// no affixes, so it only declares the encoding and the suggestion letters.
+func writeAff(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "SET UTF-8\nTRY %s\n", hunspellTry)
+	return err
+}
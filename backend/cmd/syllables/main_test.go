@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/username/goviet-ime/internal/engine"
+)
+
+func inventory(t *testing.T) []engine.InventoryEntry {
+	t.Helper()
+	var entries []engine.InventoryEntry
+	for _, e := range engine.Inventory(engine.TonePlacement{Rule: engine.ToneRuleNew}) {
+		if e.Text == "người" || e.Text == "quá" {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want người and quá", len(entries))
+	}
+	return entries
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, inventory(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []jsonEntry
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	want := map[string]jsonEntry{
+		"người": {Text: "người", Onset: "ng", Rhyme: "ươi", Tone: "huyen", Parsed: jsonParsed{"ng", "ươi", ""}},
+		"quá":   {Text: "quá", Onset: "qu", Rhyme: "a", Tone: "sac", Parsed: jsonParsed{"q", "ua", ""}},
+	}
+	for _, e := range got {
+		if e != want[e.Text] {
+			t.Errorf("got %+v, want %+v", e, want[e.Text])
+		}
+	}
+}
+
+func TestRun_Hunspell(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "vi")
+	if err := run("hunspell", "old", base); err != nil {
+		t.Fatal(err)
+	}
+
+	dic, err := os.ReadFile(base + ".dic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(dic), "\n"), "\n")
+	if want := len(engine.Inventory(engine.TonePlacement{})); lines[0] != strconv.Itoa(want) || len(lines) != want+1 {
+		t.Errorf("dic header %q with %d words, want %d", lines[0], len(lines)-1, want)
+	}
+	if !strings.Contains(string(dic), "\nhòa\n") {
+		t.Error("old rule dictionary lacks hòa")
+	}
+
+	aff, err := os.ReadFile(base + ".aff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(aff), "SET UTF-8\n") {
+		t.Errorf("aff = %q", aff)
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	for _, args := range [][3]string{
+		{"hunspell", "new", ""},
+		{"yaml", "new", ""},
+		{"text", "middle", ""},
+	} {
+		if err := run(args[0], args[1], args[2]); err == nil {
+			t.Errorf("run%q: want an error", args)
+		}
+	}
+}
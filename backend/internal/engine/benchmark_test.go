@@ -34,15 +34,17 @@ func BenchmarkProcessKeyVietnameseWord(b *testing.B) {
 	}
 }
 
-func BenchmarkUpdateSyllableStructure(b *testing.B) {
+func BenchmarkParseSyllable(b *testing.B) {
 	engine := NewCompositionEngine()
 
 	// Pre-populate with some content
-	engine.buffer.raw.WriteString("nghieng")
+	for _, r := range "nghieng" {
+		engine.ProcessKey(KeyEvent{KeySym: uint32(r)})
+	}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		engine.updateSyllableStructure()
+		engine.buffer.state.parse(&engine.buffer.syllable, 'g')
 	}
 }
 
@@ -83,6 +85,106 @@ func BenchmarkBackspace(b *testing.B) {
 	}
 }
 
+// longInput is typed into a single composition by the long-buffer
+// benchmarks, like a pasted URL or an identifier typed in Vietnamese mode.
+var longInput = func() []rune {
+	var keys []rune
+	for len(keys) < 1000 {
+		keys = append(keys, []rune("nguwowif-dduowcj/tieengs.vieetj_")...)
+	}
+	return keys[:1000]
+}()
+
+func BenchmarkProcessKeyLongBuffer(b *testing.B) {
+	engine := NewCompositionEngine()
+	for _, r := range longInput {
+		engine.ProcessKey(KeyEvent{KeySym: uint32(r)})
+	}
+
+	// Type one more key and delete it again at the end of a long buffer
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine.processKeyInternal('a')
+		engine.popKey()
+	}
+}
+
+func BenchmarkBackspaceLongBuffer(b *testing.B) {
+	engine := NewCompositionEngine()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		engine.Reset()
+		for _, r := range longInput {
+			engine.processKeyInternal(r)
+		}
+		b.StartTimer()
+
+		for len(engine.buffer.keys) > 0 {
+			engine.popKey()
+		}
+	}
+}
+
+// Allocation guards: once the engine has seen a word, reducing its keys and
+// popping them again must not allocate.
+func TestAllocs_Keystroke(t *testing.T) {
+	for _, method := range []InputMethod{NewTelexMethod(), NewVNIMethod()} {
+		engine := NewCompositionEngine()
+		engine.SetInputMethod(method)
+
+		for _, word := range []string{"nguwowif", "DDUOWCJ", "tieengs", "aaa", "ng2u7o7i", "d9uoc75", "a66"} {
+			typeKeys(engine, word, 0) // Warm up the interned strings
+			engine.Reset()
+
+			allocs := testing.AllocsPerRun(100, func() {
+				for _, r := range word {
+					engine.processKeyInternal(r)
+				}
+				for len(engine.buffer.keys) > 0 {
+					engine.popKey()
+				}
+			})
+			if allocs != 0 {
+				t.Errorf("%s %q: %v allocations, want 0", method.Name(), word, allocs)
+			}
+		}
+	}
+}
+
+func TestAllocs_LongBuffer(t *testing.T) {
+	engine := NewCompositionEngine()
+	for _, r := range longInput {
+		engine.processKeyInternal(r)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		engine.processKeyInternal('a')
+		engine.popKey()
+	})
+	if allocs != 0 {
+		t.Errorf("key at the end of a long buffer: %v allocations, want 0", allocs)
+	}
+}
+
+func TestAllocs_ProcessKey(t *testing.T) {
+	// A key event returns a new preedit and its segments, nothing more
+	engine := NewCompositionEngine()
+	word := "nguwowif"
+	typeKeys(engine, word, 0)
+	engine.Reset()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		typeKeys(engine, word, 0)
+		engine.Reset()
+	})
+	if perKey := allocs / float64(len(word)); perKey > 2 {
+		t.Errorf("ProcessKey: %.1f allocations per key, want at most 2", perKey)
+	}
+}
+
 // VNI Input Method Tests
 func TestVNIBasicTones(t *testing.T) {
 	engine := NewCompositionEngine()
@@ -278,28 +380,29 @@ func TestWAsVowelAfterConsonant(t *testing.T) {
 
 // Modern Tone Rule Tests
 func TestModernToneRule(t *testing.T) {
-	// Test that findTonePositionWithRule works correctly
+	// Test that the tone placement table follows the selected rule
 	tests := []struct {
 		nucleus string
 		coda    string
 		oldPos  int
 		newPos  int
 	}{
-		{"ia", "", 0, 1}, // nghĩa (old: i) vs nghiã (new: a)
-		{"ua", "", 0, 1}, // của (old: u) vs cùa (new: a)
-		{"oa", "", 1, 1}, // hoá - both rules same
+		{"ia", "", 0, 0},  // nghĩa under both rules
+		{"ua", "", 0, 0},  // của under both rules
+		{"oa", "", 0, 1},  // hòa (old) vs hoà (new)
+		{"oa", "n", 1, 1}, // hoàn - both rules same
 	}
 
 	for _, tt := range tests {
 		nucleus := []rune(tt.nucleus)
 
-		oldPos := findTonePositionWithRule(nucleus, tt.coda, ToneRuleOld)
+		oldPos := TonePlacement{Rule: ToneRuleOld}.Position("", nucleus, tt.coda)
 		if oldPos != tt.oldPos {
 			t.Errorf("nucleus=%q coda=%q: old rule got pos %d, want %d",
 				tt.nucleus, tt.coda, oldPos, tt.oldPos)
 		}
 
-		newPos := findTonePositionWithRule(nucleus, tt.coda, ToneRuleNew)
+		newPos := TonePlacement{Rule: ToneRuleNew}.Position("", nucleus, tt.coda)
 		if newPos != tt.newPos {
 			t.Errorf("nucleus=%q coda=%q: new rule got pos %d, want %d",
 				tt.nucleus, tt.coda, newPos, tt.newPos)
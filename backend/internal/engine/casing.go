@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"unicode"
+)
+
+// Casing rules
+//
+//  1. A letter key's case comes from its keysym, corrected by the modifier
+//     state: when Shift or Caps Lock is active the letter is uppercase if
+//     exactly one of them is (Caps Lock inverts Shift). This gives the same
+//     result whether the frontend sends resolved keysyms ('A' with Caps Lock)
+//     or unshifted ones ('a' with Caps Lock).
+//  2. Every composed letter keeps the case of the key that typed its base
+//     letter. Keys that only add marks (tone keys, the second a in aa, w after
+//     a vowel, the second d in dd) never change the case of existing letters,
+//     and a reverted mark key comes back with the case it was typed in.
+//  3. A letter created by a mark key alone (w as ư) takes the key's case,
+//     except inside an all-caps word where it is always uppercase.
+
+// applyKeyCase returns char with the case implied by the Shift and Caps Lock
+// state (rule 1). Non-letters are returned unchanged.
+func applyKeyCase(char rune, modifiers uint32) rune {
+	if !unicode.IsLetter(char) || modifiers&(ModShift|ModLock) == 0 {
+		return char
+	}
+
+	shift := modifiers&ModShift != 0
+	lock := modifiers&ModLock != 0
+	if shift != lock {
+		return unicode.ToUpper(char)
+	}
+	return unicode.ToLower(char)
+}
+
+// isAllCaps reports whether s is an all-caps word: at least two letters and
+// no lowercase letter. A single uppercase letter is title case.
+func isAllCaps(s string) bool {
+	letters := 0
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		if !unicode.IsUpper(r) {
+			return false
+		}
+		letters++
+	}
+	return letters >= 2
+}
+
+// withCaseOf returns r in the case of the key that produced it, forced to
+// uppercase inside an all-caps word (rule 3).
+func withCaseOf(r rune, key rune, allCaps bool) rune {
+	if allCaps || unicode.IsUpper(key) {
+		return unicode.ToUpper(r)
+	}
+	return unicode.ToLower(r)
+}
@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"testing"
+	"unicode"
+)
+
+// typeKeys types each rune of input as a key event with the given modifiers.
+func typeKeys(engine *CompositionEngine, input string, modifiers uint32) {
+	for _, r := range input {
+		engine.ProcessKey(KeyEvent{KeySym: uint32(r), Modifiers: modifiers})
+	}
+}
+
+func TestApplyKeyCase(t *testing.T) {
+	tests := []struct {
+		char      rune
+		modifiers uint32
+		expected  rune
+	}{
+		{'a', ModNone, 'a'},
+		{'A', ModNone, 'A'},
+		{'a', ModShift, 'A'},           // unshifted keysym + Shift
+		{'A', ModShift, 'A'},           // resolved keysym + Shift
+		{'a', ModLock, 'A'},            // unshifted keysym + Caps Lock
+		{'A', ModLock, 'A'},            // resolved keysym + Caps Lock
+		{'a', ModLock | ModShift, 'a'}, // Caps Lock inverts Shift
+		{'A', ModLock | ModShift, 'a'}, // unresolved Caps Lock + Shift
+		{'1', ModLock, '1'},            // not a letter
+		{'đ', ModLock, 'Đ'},            // Unicode keysym
+	}
+
+	for _, tt := range tests {
+		if got := applyKeyCase(tt.char, tt.modifiers); got != tt.expected {
+			t.Errorf("applyKeyCase(%q, %b) = %q, want %q", tt.char, tt.modifiers, got, tt.expected)
+		}
+	}
+}
+
+func TestCasing_EveryToneAndMarkUppercase(t *testing.T) {
+	// Telex keys producing each vowel, and the vowel they produce
+	vowels := []struct {
+		keys  string
+		vowel rune
+	}{
+		{"a", 'a'}, {"aw", 'ă'}, {"aa", 'â'},
+		{"e", 'e'}, {"ee", 'ê'},
+		{"i", 'i'},
+		{"o", 'o'}, {"oo", 'ô'}, {"ow", 'ơ'},
+		{"u", 'u'}, {"uw", 'ư'},
+		{"y", 'y'},
+	}
+	tones := []struct {
+		key  rune
+		tone ToneMark
+	}{
+		{0, ToneNone}, {'s', ToneSac}, {'f', ToneHuyen}, {'r', ToneHoi}, {'x', ToneNga}, {'j', ToneNang},
+	}
+
+	for _, v := range vowels {
+		for _, tn := range tones {
+			keys := v.keys
+			if tn.key != 0 {
+				keys += string(tn.key)
+			}
+			want := string(unicodeVowelTones[unicode.ToUpper(v.vowel)][tn.tone])
+
+			// Shifted keys, Caps Lock with unshifted keysyms, and Caps Lock
+			// with resolved keysyms must all give the same uppercase letter
+			cases := []struct {
+				name      string
+				input     string
+				modifiers uint32
+			}{
+				{"shift", keys, ModShift},
+				{"capslock", keys, ModLock},
+				{"resolved", toUpper(keys), ModLock},
+			}
+			for _, c := range cases {
+				engine := NewCompositionEngine()
+				typeKeys(engine, c.input, c.modifiers)
+				if got := engine.GetPreedit(); got != want {
+					t.Errorf("%s %q: got %q, want %q", c.name, c.input, got, want)
+				}
+			}
+		}
+	}
+
+	// Stroke
+	engine := NewCompositionEngine()
+	typeKeys(engine, "dda", ModLock)
+	if got := engine.GetPreedit(); got != "ĐA" {
+		t.Errorf("Caps Lock dda: got %q, want %q", got, "ĐA")
+	}
+}
+
+func TestCasing_VNIUppercase(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"A61", "Ấ"},
+		{"E62", "Ề"},
+		{"O73", "Ở"},
+		{"U74", "Ữ"},
+		{"A85", "Ặ"},
+		{"D9A", "ĐA"},
+	}
+
+	for _, tt := range tests {
+		engine := NewCompositionEngine()
+		engine.SetInputMethod(NewVNIMethod())
+		engine.config.EnableValidation = false
+		typeKeys(engine, tt.input, ModNone)
+		if got := engine.GetPreedit(); got != tt.expected {
+			t.Errorf("VNI %q: got %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestCasing_Words(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		modifiers uint32
+		expected  string
+	}{
+		{"title case", "Dduowngf", ModNone, "Đường"},
+		{"title case with both d shifted", "DDuowngf", ModNone, "Đường"},
+		{"all caps", "DDUOWNGF", ModNone, "ĐƯỜNG"},
+		{"all caps with lowercase mark keys", "DDUOwNGf", ModNone, "ĐƯỜNG"},
+		{"caps lock", "dduowngf", ModLock, "ĐƯỜNG"},
+		{"caps lock inverted by shift", "dduowngf", ModLock | ModShift, "đường"},
+		{"caps lock with shift, unresolved keysyms", "DDUOWNGF", ModLock | ModShift, "đường"},
+		{"w as vowel in all-caps word", "NHw", ModNone, "NHƯ"},
+		{"w as vowel in title case", "Nhw", ModNone, "Như"},
+		{"w as vowel after single capital", "Tw", ModNone, "Tư"},
+		{"mark keys keep letter case", "vIEeTj", ModNone, "vIỆT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewCompositionEngine()
+			typeKeys(engine, tt.input, tt.modifiers)
+			if got := engine.GetPreedit(); got != tt.expected {
+				t.Errorf("%q: got %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCasing_RevertKeepsTypedCase(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"AaA", "Aa"},
+		{"aaA", "aa"},
+		{"AAa", "AA"},
+		{"ASs", "AS"},
+		{"AsS", "As"},
+		{"OoO", "Oo"},
+	}
+
+	for _, tt := range tests {
+		engine := NewCompositionEngine()
+		typeKeys(engine, tt.input, ModNone)
+		if got := engine.GetPreedit(); got != tt.expected {
+			t.Errorf("%q: got %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+// toUpper uppercases every letter in s.
+func toUpper(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		runes[i] = unicode.ToUpper(r)
+	}
+	return string(runes)
+}
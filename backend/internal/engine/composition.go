@@ -1,65 +1,63 @@
 package engine
 
 import (
-	"strings"
+	"slices"
 	"unicode"
+	"unicode/utf8"
 )
 
-// TransformType indicates the type of transformation
-type TransformType int
-
-const (
-	TransformNone      TransformType = iota
-	TransformTone                    // Tone mark (s, f, r, x, j)
-	TransformVowelMark               // Vowel mark (aa, ee, oo, w)
-	TransformStroke                  // D-bar (dd)
-	TransformWAsVowel                // W as ư
-)
-
-const breakMarker = '\u200b' // Zero-width space to break patterns (e.g. aa -> aa literal)
-
-// LastTransform tracks the last transformation for double-key revert
-type LastTransform struct {
-	Key      rune          // The key that triggered the transform
-	Type     TransformType // What kind of transform
-	Position int           // Position in nucleus (for vowel marks)
-	Original string        // Original value before transform
-}
-
 // CompositionEngine is the main engine that processes keyboard input.
+//
+// An engine is not safe for concurrent use: callers serialize the calls to
+// each engine, as the daemon does with its lock. Separate engines may run on
+// different goroutines, even when they share an EngineConfig.
 type CompositionEngine struct {
-	inputMethod   InputMethod
-	outputFormat  OutputFormat
-	buffer        *CompositionBuffer
-	enabled       bool
-	lastTransform LastTransform // For double-key revert
-	config        *EngineConfig // Engine configuration
+	inputMethod  InputMethod
+	outputFormat OutputFormat
+	buffer       *CompositionBuffer
+	enabled      bool
+	config       *EngineConfig // Engine configuration
+	hotkey       hotkeyState   // Toggle hotkey tracking
+	stats        KeyStats      // Work done for the last key event
 }
 
 // CompositionBuffer holds the current composition state.
 type CompositionBuffer struct {
-	raw           strings.Builder // Raw input characters
-	syllable      *Syllable       // Parsed syllable structure
-	committed     string          // Text to commit
-	modifierCount int             // Number of modifier characters consumed (tones, vowel marks)
+	keys         []rune   // Keystroke log, exactly as typed
+	state        keyState // Composition reduced from the keystroke log
+	syllable     Syllable // Parsed syllable structure
+	committed    string   // Text to commit
+	caretFromEnd int      // Keystrokes after the caret (0 = caret at the end)
+
+	// Preedit cache, rebuilt when the composition changes
+	preedit     string
+	composedLen int  // Bytes of preedit composed from the syllable
+	fresh       bool // preedit matches the composition
 }
 
 // NewCompositionBuffer creates a new empty buffer.
 func NewCompositionBuffer() *CompositionBuffer {
-	return &CompositionBuffer{
-		syllable: &Syllable{},
-	}
+	return &CompositionBuffer{}
+}
+
+// reset empties the buffer, keeping its storage for the next word.
+func (b *CompositionBuffer) reset() {
+	b.keys = b.keys[:0]
+	b.state.reset()
+	b.syllable = Syllable{}
+	b.committed = ""
+	b.caretFromEnd = 0
+	b.preedit, b.composedLen, b.fresh = "", 0, false
 }
 
 // NewCompositionEngine creates a new composition engine with default settings.
 func NewCompositionEngine() *CompositionEngine {
 	return &CompositionEngine{
-		inputMethod:   NewTelexMethod(), // Default to Telex
-		outputFormat:  NewUnicodeFormat(),
-		buffer:        NewCompositionBuffer(),
-		enabled:       true,
-		lastTransform: LastTransform{},
-		config:        DefaultConfig(),
+		inputMethod:  NewTelexMethod(), // Default to Telex
+		outputFormat: NewUnicodeFormat(),
+		buffer:       NewCompositionBuffer(),
+		enabled:      true,
+		config:       DefaultConfig(),
 	}
 }
 
@@ -71,6 +69,7 @@ func (e *CompositionEngine) SetInputMethod(method InputMethod) {
 // SetOutputFormat sets the output encoding format.
 func (e *CompositionEngine) SetOutputFormat(format OutputFormat) {
 	e.outputFormat = format
+	e.buffer.fresh = false
 }
 
 // SetEnabled enables or disables the engine.
@@ -88,58 +87,171 @@ func (e *CompositionEngine) IsEnabled() bool {
 
 // Reset clears the current composition state.
 func (e *CompositionEngine) Reset() {
-	e.buffer = NewCompositionBuffer()
-	e.lastTransform = LastTransform{}
+	e.buffer.reset()
 }
 
 // GetPreedit returns the current preedit string.
 func (e *CompositionEngine) GetPreedit() string {
-	raw := e.buffer.raw.String()
-	if raw == "" {
+	b := e.buffer
+	if len(b.keys) == 0 {
 		return ""
 	}
 
-	syllable := e.buffer.syllable
-	if syllable == nil {
-		return raw
-	}
-
-	// Always try to compose from structure first
-	composed := e.outputFormat.Compose(syllable)
-
-	// Append any unparsed characters from the raw buffer,
-	// but skip characters that are input method modifiers if they were likely already consumed.
-	runes := []rune(raw)
-	if syllable.Consumed < len(runes) && syllable.Consumed >= 0 {
-		modifierIdx := 0
-		for _, r := range runes[syllable.Consumed:] {
-			// If it's a modifier, only skip it if it was "consumed" by the engine
-			if e.isInputModifier(r) {
-				if modifierIdx < syllable.ConsumedModifiers {
-					modifierIdx++
-					continue
-				}
-			}
-			composed += string(r)
+	if !b.fresh {
+		b.syllable.Tones = TonePlacement{Rule: e.config.ToneRule, Overrides: e.config.ToneOverrides}
+		composed := e.outputFormat.Compose(&b.syllable)
+		b.preedit = composed
+		if tail := b.state.tail(b.syllable.Consumed); tail != "" {
+			b.preedit = composed + tail
+		}
+		b.composedLen = len(composed)
+		b.fresh = true
+	}
+	return b.preedit
+}
+
+// AppendRawKeys appends the keystrokes of the word being composed, exactly
+// as typed, to dst.
+func (e *CompositionEngine) AppendRawKeys(dst []rune) []rune {
+	return append(dst, e.buffer.keys...)
+}
+
+// preeditParts returns the text composed from the syllable structure and the
+// letters that could not be parsed into it.
+func (e *CompositionEngine) preeditParts() (string, string) {
+	preedit := e.GetPreedit()
+	return preedit[:e.buffer.composedLen], preedit[e.buffer.composedLen:]
+}
+
+// PreeditSegments returns the preedit split into runs with display attributes.
+// Parts of the syllable rejected by the validator and characters that could
+// not be parsed into the syllable are highlighted.
+func (e *CompositionEngine) PreeditSegments() []PreeditSegment {
+	if len(e.buffer.keys) == 0 {
+		return nil
+	}
+
+	preedit := e.GetPreedit()
+	composed := preedit[:e.buffer.composedLen]
+
+	onsetAttr, codaAttr := PreeditAttrUnderline, PreeditAttrUnderline
+	syllable := &e.buffer.syllable
+	switch ValidateVietnamese(syllable.Onset, syllable.Nucleus, syllable.Coda).Reason {
+	case "invalid_initial", "spelling_rule_violation":
+		onsetAttr |= PreeditAttrHighlight
+	case "invalid_final":
+		codaAttr |= PreeditAttrHighlight
+	}
+
+	// Compose always emits the onset first and the coda last, so the composed
+	// text can be split by rune counts.
+	runeCount := utf8.RuneCountInString(composed)
+	onsetLen := min(utf8.RuneCountInString(syllable.Onset), runeCount)
+	codaStart := max(runeCount-utf8.RuneCountInString(syllable.Coda), onsetLen)
+
+	// Runs end at these byte offsets; neighbours with the same attributes
+	// are merged by slicing the preedit
+	runs := [...]struct {
+		end  int
+		attr PreeditAttr
+	}{
+		{runeOffset(composed, onsetLen), onsetAttr},
+		{runeOffset(composed, codaStart), PreeditAttrUnderline},
+		{len(composed), codaAttr},
+		{len(preedit), PreeditAttrUnderline | PreeditAttrHighlight},
+	}
+
+	segments := make([]PreeditSegment, 0, len(runs))
+	start, segStart := 0, 0
+	for _, run := range runs {
+		if run.end == start {
+			continue
+		}
+		if n := len(segments); n > 0 && segments[n-1].Attr == run.attr {
+			segments[n-1].Text = preedit[segStart:run.end]
+		} else {
+			segStart = start
+			segments = append(segments, PreeditSegment{Text: preedit[start:run.end], Attr: run.attr})
 		}
+		start = run.end
 	}
+	return segments
+}
 
-	if composed != "" {
-		// Filter out pattern breakers
-		return strings.ReplaceAll(composed, string(breakMarker), "")
+// runeOffset returns the byte offset of the n-th rune of s.
+func runeOffset(s string, n int) int {
+	for i := range s {
+		if n == 0 {
+			return i
+		}
+		n--
 	}
+	return len(s)
+}
 
-	return strings.ReplaceAll(raw, string(breakMarker), "")
+// Mode returns the current input mode.
+func (e *CompositionEngine) Mode() InputMode {
+	if !e.enabled {
+		return ModeEnglish
+	}
+	return ModeVietnamese
 }
 
 // ProcessKey handles a key event and returns the result.
 func (e *CompositionEngine) ProcessKey(event KeyEvent) ProcessResult {
+	e.stats = KeyStats{}
+	result := e.processKey(event)
+
+	// Fill in the display details from the state left behind by the key
+	if result.Preedit != "" {
+		result.Segments = e.PreeditSegments()
+		result.Cursor = e.CaretPosition()
+	}
+	result.Mode = e.Mode()
+	return result
+}
+
+// LastKeyStats returns the work done for the last key event.
+func (e *CompositionEngine) LastKeyStats() KeyStats {
+	return e.stats
+}
+
+// ToggleMode switches between Vietnamese and English, returning the
+// composition that was committed on the way.
+func (e *CompositionEngine) ToggleMode() string {
+	preedit := e.GetPreedit()
+	e.Reset()
+	e.SetEnabled(!e.enabled)
+	return preedit
+}
+
+// toggleMode handles the toggle hotkey. Chords ending in a regular key are
+// consumed; modifier-only chords are left for the application to see.
+func (e *CompositionEngine) toggleMode(event KeyEvent) ProcessResult {
+	return ProcessResult{
+		Handled:    modifierForKeysym(event.KeySym) == 0,
+		CommitText: e.ToggleMode(),
+	}
+}
+
+// processKey dispatches a key event to the special key handlers or the composer.
+func (e *CompositionEngine) processKey(event KeyEvent) ProcessResult {
 	result := ProcessResult{
 		Handled:    false,
 		CommitText: "",
 		Preedit:    "",
 	}
 
+	// The toggle hotkey works in both modes
+	if e.matchToggleHotkey(event) {
+		return e.toggleMode(event)
+	}
+
+	// Releases only matter for hotkey tracking
+	if event.Modifiers&ModRelease != 0 {
+		return e.ignoreKey()
+	}
+
 	// If disabled, don't process
 	if !e.enabled {
 		return result
@@ -152,7 +264,7 @@ func (e *CompositionEngine) ProcessKey(event KeyEvent) ProcessResult {
 
 	// Check for modifiers (Ctrl, Alt) - commit and don't process these
 	if event.Modifiers&(ModControl|ModMod1) != 0 {
-		if e.buffer.raw.Len() > 0 {
+		if len(e.buffer.keys) > 0 {
 			preedit := e.GetPreedit()
 			e.Reset()
 			result.Handled = false
@@ -163,16 +275,22 @@ func (e *CompositionEngine) ProcessKey(event KeyEvent) ProcessResult {
 		return result
 	}
 
-	// Convert keysym to character
-	char := KeysymToRune(event.KeySym)
+	// Convert keysym to character, resolving Shift and Caps Lock
+	char := applyKeyCase(KeysymToRune(event.KeySym), event.Modifiers)
 	if char == 0 {
-		return result
+		return e.ignoreKey()
 	}
 
 	// Process the character
 	return e.processChar(char)
 }
 
+// ignoreKey is the result of a key that leaves the composition alone, such
+// as a release or a modifier press: not handled, with the preedit as it is.
+func (e *CompositionEngine) ignoreKey() ProcessResult {
+	return ProcessResult{Preedit: e.GetPreedit()}
+}
+
 // handleSpecialKey handles special keys like Backspace, Space, Enter.
 func (e *CompositionEngine) handleSpecialKey(event KeyEvent) (ProcessResult, bool) {
 	result := ProcessResult{}
@@ -214,7 +332,7 @@ func (e *CompositionEngine) handleSpecialKey(event KeyEvent) (ProcessResult, boo
 
 	case KeyTab:
 		// Commit current composition and pass through tab
-		if e.buffer.raw.Len() > 0 {
+		if len(e.buffer.keys) > 0 {
 			preedit := e.GetPreedit()
 			e.Reset()
 			result.Handled = true
@@ -225,8 +343,19 @@ func (e *CompositionEngine) handleSpecialKey(event KeyEvent) (ProcessResult, boo
 		return result, false // Let tab pass through
 
 	case KeyDelete:
+		// With the caret inside the word, delete the character after it
+		if e.buffer.caretFromEnd > 0 {
+			keys := e.buffer.keys
+			caret := e.caretIndex(len(keys))
+			e.replay(slices.Delete(slices.Clone(keys), caret, caret+1))
+			e.buffer.caretFromEnd = max(e.buffer.caretFromEnd-1, 0)
+			result.Handled = true
+			result.Preedit = e.GetPreedit()
+			return result, true
+		}
+
 		// If we have preedit, commit it and then let Delete pass to app
-		if e.buffer.raw.Len() > 0 {
+		if len(e.buffer.keys) > 0 {
 			preedit := e.GetPreedit()
 			e.Reset()
 			result.Handled = false
@@ -235,697 +364,208 @@ func (e *CompositionEngine) handleSpecialKey(event KeyEvent) (ProcessResult, boo
 			return result, true
 		}
 		return result, false
+
+	case KeyLeft, KeyRight, KeyHome, KeyEnd:
+		return e.handleCaretKey(event.KeySym)
 	}
 
 	return result, false
 }
 
-// handleBackspace handles the backspace key.
-func (e *CompositionEngine) handleBackspace() ProcessResult {
-	result := ProcessResult{Handled: true}
+// handleCaretKey moves the caret inside the composing word. When
+// CommitOnCaretKeys is set it commits the word and lets the key through instead.
+func (e *CompositionEngine) handleCaretKey(keysym uint32) (ProcessResult, bool) {
+	result := ProcessResult{}
 
-	raw := e.buffer.raw.String()
-	if len(raw) == 0 {
-		// Nothing to delete, pass through
+	if len(e.buffer.keys) == 0 {
+		// Nothing composing - let the application move its own caret
+		return result, false
+	}
+
+	if e.config.CommitOnCaretKeys {
+		preedit := e.GetPreedit()
+		e.Reset()
 		result.Handled = false
-		return result
+		result.CommitText = preedit
+		result.Preedit = ""
+		return result, true
 	}
 
-	// Remove the last character
-	runes := []rune(raw)
-	newRaw := string(runes[:len(runes)-1])
+	runes := e.buffer.keys
+	caret := e.caretIndex(len(runes))
 
-	// Re-parse the syllable using full processKeyInternal logic
-	e.Reset()
-	// OPTIMIZATION: processKeyInternal will call updateSyllableStructure
-	// which is now faster due to global map.
-	for _, r := range newRaw {
-		e.processKeyInternal(r)
+	switch keysym {
+	case KeyHome:
+		caret = 0
+	case KeyEnd:
+		caret = len(runes)
+	case KeyLeft:
+		caret = e.stepCaret(runes, caret, -1)
+	case KeyRight:
+		caret = e.stepCaret(runes, caret, 1)
 	}
 
+	e.buffer.caretFromEnd = len(runes) - caret
+	result.Handled = true
 	result.Preedit = e.GetPreedit()
-	return result
-}
-
-// processChar processes a regular character input.
-func (e *CompositionEngine) processChar(char rune) ProcessResult {
-	e.processKeyInternal(char)
-	return ProcessResult{
-		Handled: true,
-		Preedit: e.GetPreedit(),
-	}
+	return result, true
 }
 
-// processKeyInternal is a helper for re-parsing
-func (e *CompositionEngine) processKeyInternal(char rune) {
-	// Check for double-key revert FIRST (before any processing)
-	if e.config.EnableDoubleKeyRevert && e.checkDoubleKeyRevert(char) {
-		return
-	}
-
-	transformed, tone, vowelMark, consumed := e.inputMethod.ProcessChar(char, e.buffer.syllable)
-
-	if consumed {
-		// VALIDATION FIRST: Check if we should apply transformation
-		// Only validate if validation is enabled and we have a vowel (potential Vietnamese)
-		if e.config.EnableValidation {
-			// Check if the buffer forms valid Vietnamese before transformation
-			if !e.shouldTransform(char) {
-				// Not valid Vietnamese - treat as literal character
-				e.buffer.raw.WriteRune(char)
-				e.updateSyllableStructure()
-				e.lastTransform = LastTransform{} // Clear last transform
-				return
-			}
-		}
-
-		if e.inputMethod.IsToneKey(char) {
-
-			if e.buffer.syllable.ToneMark == tone && tone != ToneNone {
-				e.buffer.syllable.ToneMark = ToneNone
-			} else {
-				e.buffer.syllable.ToneMark = tone
-			}
-
-			// Track this transformation for double-key revert
-			e.lastTransform = LastTransform{
-				Key:      char,
-				Type:     TransformTone,
-				Original: "", // We don't need to track original tone value for revert
-			}
-		} else if vowelMark != VowelNone || len(transformed) > 0 {
-			// Save original for potential revert
-			originalNucleus := e.buffer.syllable.Nucleus
-			originalOnset := e.buffer.syllable.Onset
-
-			// For VNI input method: we need to modify the raw buffer directly
-			// because VNI modifiers (6,7,8,9) transform vowels but shouldn't appear in output
-			if e.inputMethod.Name() == "VNI" && len(transformed) > 0 {
-				// VNI: Replace the target character in raw buffer with transformed result
-				e.applyVNITransformation(vowelMark, transformed, char)
-			} else {
-				e.applyVowelMark(vowelMark, transformed)
-				e.buffer.raw.WriteRune(char)
-			}
-
-			// Track this transformation
-			if vowelMark == VowelDBar {
-				e.lastTransform = LastTransform{
-					Key:      char,
-					Type:     TransformStroke,
-					Original: originalOnset,
-				}
-			} else {
-				e.lastTransform = LastTransform{
-					Key:      char,
-					Type:     TransformVowelMark,
-					Original: originalNucleus,
-				}
-			}
-			e.updateSyllableStructure()
-			return
-		}
-		e.buffer.raw.WriteRune(char)
-		e.updateSyllableStructure()
-	} else {
-		// Handle W-as-Vowel feature
-		if e.config.EnableWAsVowel && unicode.ToLower(char) == 'w' {
-			if e.tryWAsVowel(char) {
-				return
-			}
+// stepCaret moves the caret in direction dir until it crosses a visible
+// character, skipping keystrokes (tone and vowel modifiers) that have no width.
+func (e *CompositionEngine) stepCaret(runes []rune, caret int, dir int) int {
+	start := e.displayOffset(runes[:caret])
+	for caret+dir >= 0 && caret+dir <= len(runes) {
+		caret += dir
+		if e.displayOffset(runes[:caret]) != start {
+			break
 		}
-
-		e.processCharInternal(char)
-		e.lastTransform = LastTransform{} // Clear since no transform happened
 	}
+	return caret
 }
 
-// shouldTransform checks if the current buffer + modifier would form valid Vietnamese
-func (e *CompositionEngine) shouldTransform(modifierKey rune) bool {
-	syllable := e.buffer.syllable
-	if syllable == nil {
-		return false
-	}
-
-	// Must have at least a nucleus (vowel) to transform
-	if syllable.Nucleus == "" {
-		return false
-	}
-
-	// Validate the syllable structure
-	result := ValidateVietnamese(syllable.Onset, syllable.Nucleus, syllable.Coda)
-	return result.Valid
+// caretIndex returns the caret as an index into a raw buffer of length n.
+func (e *CompositionEngine) caretIndex(n int) int {
+	return max(n-e.buffer.caretFromEnd, 0)
 }
 
-// checkDoubleKeyRevert checks if this key should revert the last transformation
-// Returns true if revert was performed
-func (e *CompositionEngine) checkDoubleKeyRevert(char rune) bool {
-	last := e.lastTransform
-	if last.Type == TransformNone {
-		return false
+// CaretPosition returns the caret position within the preedit, in runes.
+func (e *CompositionEngine) CaretPosition() int {
+	preeditLen := utf8.RuneCountInString(e.GetPreedit())
+	if e.buffer.caretFromEnd == 0 {
+		return preeditLen
 	}
 
-	// Check if same key pressed again
-	if unicode.ToLower(last.Key) != unicode.ToLower(char) {
-		return false
-	}
-
-	raw := e.buffer.raw.String()
-	runes := []rune(raw)
-	if len(runes) == 0 {
-		return false
-	}
-
-	// Remove the last modifier key from raw buffer
-	// (It's always the last one for these transforms)
-	newRaw := string(runes[:len(runes)-1])
-
-	switch last.Type {
-	case TransformTone:
-		// Revert tone: remove tone mark and restore literal
-		e.buffer.syllable.ToneMark = ToneNone
-		e.buffer.raw.Reset()
-		e.buffer.raw.WriteString(newRaw)
-		e.buffer.raw.WriteRune(char)
-		e.updateSyllableStructure()
-		e.lastTransform = LastTransform{}
-		return true
-
-	case TransformVowelMark, TransformStroke:
-		// Revert vowel mark or stroke: break the pattern
-		e.buffer.syllable.VowelMark = VowelNone
-		e.buffer.raw.Reset()
-		e.buffer.raw.WriteString(newRaw)
-		e.buffer.raw.WriteRune(breakMarker)
-		e.buffer.raw.WriteRune(char)
-		e.updateSyllableStructure()
-		e.lastTransform = LastTransform{}
-		return true
-
-	case TransformWAsVowel:
-		// Revert ư -> w + literal w (already handled mostly)
-		e.revertWAsVowel(char)
-		e.lastTransform = LastTransform{}
-		return true
-	}
-
-	return false
+	keys := e.buffer.keys
+	return min(e.displayOffset(keys[:e.caretIndex(len(keys))]), preeditLen)
 }
 
-// revertVowelTransform reverts a vowel transformation (aa->â back to aa)
-func (e *CompositionEngine) revertVowelTransform(char rune) {
-	raw := e.buffer.raw.String()
-	runes := []rune(raw)
-
-	// Find and revert the transformed vowel
-	// For patterns like aa -> â, we need to change â back to a
-	for i := len(runes) - 1; i >= 0; i-- {
-		r := runes[i]
-		lower := unicode.ToLower(r)
-
-		// Check if this is a transformed vowel that matches our key
-		var baseVowel rune
-		switch lower {
-		case 'â', 'Â':
-			if unicode.ToLower(char) == 'a' {
-				baseVowel = 'a'
-			}
-		case 'ê', 'Ê':
-			if unicode.ToLower(char) == 'e' {
-				baseVowel = 'e'
-			}
-		case 'ô', 'Ô':
-			if unicode.ToLower(char) == 'o' {
-				baseVowel = 'o'
-			}
-		case 'ơ', 'Ơ', 'ư', 'Ư', 'ă', 'Ă':
-			if unicode.ToLower(char) == 'w' {
-				// For horn/breve, revert to base
-				switch lower {
-				case 'ơ', 'Ơ':
-					baseVowel = 'o'
-				case 'ư', 'Ư':
-					baseVowel = 'u'
-				case 'ă', 'Ă':
-					baseVowel = 'a'
-				}
-			}
-		}
-
-		if baseVowel != 0 {
-			// Find this vowel in raw (for Telex) or already transformed in runes
-			// In Telex, "â" is "aa". We want "aa" -> "aaa"
-			// Actually, if it's already "â" in runes (VNI), we revert it.
-			// If it's Telex, runes still contains "aa".
-
-			if r == 'â' || r == 'Â' || r == 'ê' || r == 'Ê' || r == 'ô' || r == 'Ô' ||
-				r == 'ơ' || r == 'Ơ' || r == 'ư' || r == 'Ư' || r == 'ă' || r == 'Ă' {
-				// VNI Case: already transformed character in raw
-				if unicode.IsUpper(r) {
-					runes[i] = unicode.ToUpper(baseVowel)
-				} else {
-					runes[i] = baseVowel
-				}
-			} else {
-				// Telex Case: the transformation is implicit.
-				// We don't need to change anything in runes, just append the new char
-				// so "aa" + "a" -> "aaa"
-			}
-			break
-		}
+// displayOffset returns how many preedit characters the given keystroke
+// prefix composes to. The prefix is reduced in a scratch engine so the
+// current composition is left untouched.
+func (e *CompositionEngine) displayOffset(prefix []rune) int {
+	if len(prefix) == 0 {
+		return 0
 	}
 
-	// Rebuild buffer and add the new character
-	e.Reset()
-	for _, r := range runes {
-		e.buffer.raw.WriteRune(r)
+	scratch := &CompositionEngine{
+		inputMethod:  e.inputMethod,
+		outputFormat: e.outputFormat,
+		buffer:       NewCompositionBuffer(),
+		enabled:      true,
+		config:       e.config,
 	}
-	e.buffer.raw.WriteRune(char)
-	e.updateSyllableStructure()
-}
-
-// revertStrokeTransform reverts đ -> dd
-func (e *CompositionEngine) revertStrokeTransform(char rune) {
-	raw := e.buffer.raw.String()
-	runes := []rune(raw)
-
-	for i := len(runes) - 1; i >= 0; i-- {
-		r := runes[i]
-		if r == 'đ' {
-			runes[i] = 'd'
-			break
-		} else if r == 'Đ' {
-			runes[i] = 'D'
-			break
-		}
+	for _, r := range prefix {
+		scratch.processKeyInternal(r)
 	}
+	return utf8.RuneCountInString(scratch.GetPreedit())
+}
 
+// replay rebuilds the composition from the given keystrokes, keeping the
+// caret at the same distance from the end of the buffer.
+func (e *CompositionEngine) replay(keys []rune) {
+	caretFromEnd := e.buffer.caretFromEnd
 	e.Reset()
-	for _, r := range runes {
-		e.buffer.raw.WriteRune(r)
+	for _, r := range keys {
+		e.processKeyInternal(r)
 	}
-	e.buffer.raw.WriteRune(char)
-	e.updateSyllableStructure()
+	e.stats.Replayed = len(keys)
+	e.buffer.caretFromEnd = min(caretFromEnd, len(e.buffer.keys))
 }
 
-// tryWAsVowel attempts to use 'w' as the vowel 'ư'
-// This is for cases like single 'w' at start of syllable
-func (e *CompositionEngine) tryWAsVowel(char rune) bool {
-	syllable := e.buffer.syllable
-
-	// Only works if no nucleus yet
-	if syllable.Nucleus != "" {
-		return false
-	}
-
-	// Try adding 'ư' as nucleus
-	testNucleus := "ư"
-	if unicode.IsUpper(char) {
-		testNucleus = "Ư"
-	}
+// handleBackspace handles the backspace key by removing the keystroke before
+// the caret and reducing the log again.
+func (e *CompositionEngine) handleBackspace() ProcessResult {
+	result := ProcessResult{Handled: true}
 
-	// Validate if this would be valid Vietnamese
-	result := ValidateVietnamese(syllable.Onset, testNucleus, syllable.Coda)
-	if !result.Valid {
-		return false
+	keys := e.buffer.keys
+	if len(keys) == 0 {
+		// Nothing to delete, pass through
+		result.Handled = false
+		return result
 	}
 
-	// Apply the W as vowel
-	e.buffer.raw.WriteRune(char)
-	e.buffer.syllable.Nucleus = testNucleus
-	e.buffer.syllable.VowelMark = VowelHorn
-	e.updateSyllableStructure()
-
-	// Track for potential revert
-	e.lastTransform = LastTransform{
-		Key:  char,
-		Type: TransformWAsVowel,
+	caret := e.caretIndex(len(keys))
+	if caret == 0 {
+		// Caret at the start of the word - nothing before it to delete
+		result.Preedit = e.GetPreedit()
+		return result
 	}
 
-	return true
-}
-
-// revertWAsVowel reverts ư back to w
-func (e *CompositionEngine) revertWAsVowel(char rune) {
-	// Simply treat w as literal
-	e.buffer.raw.WriteRune(char)
-	e.buffer.syllable.Nucleus = ""
-	e.buffer.syllable.VowelMark = VowelNone
-	e.updateSyllableStructure()
-}
-
-// processCharInternal adds a character to the buffer and updates the syllable.
-func (e *CompositionEngine) processCharInternal(char rune) {
-	e.buffer.raw.WriteRune(char)
-
-	// Update syllable structure
-	// The raw string is now the source of truth for updateSyllableStructure
-	e.updateSyllableStructure()
-}
-
-// applyVowelMark applies a vowel mark to the current syllable.
-func (e *CompositionEngine) applyVowelMark(mark VowelMark, transformed string) {
-	e.buffer.syllable.VowelMark = mark
-
-	// Special case for đ - it modifies the onset consonant 'd', not a vowel
-	if mark == VowelDBar && len(transformed) > 0 {
-		// Check if onset ends with 'd' and replace with 'đ"
-		if len(e.buffer.syllable.Onset) > 0 {
-			onset := []rune(e.buffer.syllable.Onset)
-			last := onset[len(onset)-1]
-			if last == 'd' || last == 'D' {
-				onset[len(onset)-1] = []rune(transformed)[0]
-				e.buffer.syllable.Onset = string(onset)
-			}
-		} else if e.buffer.syllable.Raw != "" {
-			// If no onset parsed yet, but we have raw 'd', update raw
-			rawRunes := []rune(e.buffer.syllable.Raw)
-			if len(rawRunes) > 0 {
-				last := rawRunes[len(rawRunes)-1]
-				if last == 'd' || last == 'D' {
-					rawRunes[len(rawRunes)-1] = []rune(transformed)[0]
-					e.buffer.syllable.Raw = string(rawRunes)
-				}
-			}
+	// A key that reverted a modifier shows as the modifier typed literally;
+	// deleting that letter removes both keys
+	if caret == len(keys) {
+		e.popKey()
+	} else {
+		start := caret - 1
+		if e.buffer.state.frames[start].reverted {
+			start--
 		}
-		return
+		e.replay(slices.Delete(slices.Clone(keys), start, caret))
 	}
 
-	// If transformed contains the result, update nucleus
-	if len(transformed) > 0 && len(e.buffer.syllable.Nucleus) > 0 {
-		// Replace the last vowel in the nucleus
-		nucleus := []rune(e.buffer.syllable.Nucleus)
-		nucleus[len(nucleus)-1] = []rune(transformed)[0]
-		e.buffer.syllable.Nucleus = string(nucleus)
-	}
+	result.Preedit = e.GetPreedit()
+	return result
 }
 
-// applyVNITransformation handles VNI vowel mark transformation
-// It modifies the raw buffer directly, replacing the target character with the transformed result
-func (e *CompositionEngine) applyVNITransformation(mark VowelMark, transformed string, modifierKey rune) {
-	e.buffer.syllable.VowelMark = mark
-
-	if len(transformed) == 0 {
-		return
+// popKey removes the last keystroke from the log, and the modifier before it
+// if the keystroke reverted one, and parses the syllable again.
+func (e *CompositionEngine) popKey() {
+	b := e.buffer
+	n := len(b.keys) - 1
+	if b.state.pop() {
+		b.state.pop()
+		n--
 	}
+	b.keys = b.keys[:n]
 
-	raw := e.buffer.raw.String()
-	runes := []rune(raw)
-	transformedRunes := []rune(transformed)
-
-	// Handle đ (stroke) - find 'd' and replace
-	if mark == VowelDBar {
-		for i := len(runes) - 1; i >= 0; i-- {
-			r := runes[i]
-			if r == 'd' || r == 'D' {
-				runes[i] = transformedRunes[0]
-				break
-			}
-		}
-	} else if len(transformedRunes) == 1 {
-		// Single character transformation (â, ê, ô, ơ, ư, ă)
-		// Find the last vowel that matches and replace it
-		targetTransformed := transformedRunes[0]
-
-		// Determine what base vowel we're looking for based on the transformed result
-		var targetBases []rune
-		switch unicode.ToLower(targetTransformed) {
-		case 'â':
-			targetBases = []rune{'a', 'A'}
-		case 'ê':
-			targetBases = []rune{'e', 'E'}
-		case 'ô':
-			targetBases = []rune{'o', 'O'}
-		case 'ơ':
-			targetBases = []rune{'o', 'O'}
-		case 'ư':
-			targetBases = []rune{'u', 'U'}
-		case 'ă':
-			targetBases = []rune{'a', 'A'}
-		}
-
-		// Find and replace the last matching vowel
-		for i := len(runes) - 1; i >= 0; i-- {
-			r := runes[i]
-			for _, target := range targetBases {
-				if r == target {
-					if unicode.IsUpper(r) {
-						runes[i] = unicode.ToUpper(targetTransformed)
-					} else {
-						runes[i] = targetTransformed
-					}
-					goto done
-				}
-			}
-		}
-	done:
-	} else if len(transformedRunes) > 1 {
-		// Multi-character transformation (ươ for UO compound)
-		// Find the UO pattern and replace both
-		for i := 0; i < len(runes)-1; i++ {
-			if unicode.ToLower(runes[i]) == 'u' && unicode.ToLower(runes[i+1]) == 'o' {
-				if unicode.IsUpper(runes[i]) {
-					runes[i] = 'Ư'
-				} else {
-					runes[i] = 'ư'
-				}
-				if unicode.IsUpper(runes[i+1]) {
-					runes[i+1] = 'Ơ'
-				} else {
-					runes[i+1] = 'ơ'
-				}
-				break
-			}
-		}
-	}
-
-	// Rebuild the raw buffer with transformed content
-	e.buffer.raw.Reset()
-	for _, r := range runes {
-		e.buffer.raw.WriteRune(r)
+	lastKey := rune(0)
+	if n > 0 {
+		lastKey = b.keys[n-1]
 	}
+	b.state.parse(&b.syllable, lastKey)
+	b.fresh = false
 }
 
-// updateSyllableStructure parses the raw input into onset, nucleus, coda.
-func (e *CompositionEngine) updateSyllableStructure() {
-	raw := e.buffer.raw.String()
-	if raw == "" {
-		e.buffer.syllable = &Syllable{}
-		return
-	}
-
-	// Preserve ToneMark and reset structure
-	tone := e.buffer.syllable.ToneMark
-	vMark := e.buffer.syllable.VowelMark
-	e.buffer.syllable = &Syllable{Raw: raw, ToneMark: tone, VowelMark: vMark}
-
-	consumedModifiers := 0
-	if tone != ToneNone {
-		consumedModifiers++
-	}
-	// Note: vowel mark modifiers are also tracked below
-
-	runes := []rune(raw)
-	onset := ""
-	nucleus := ""
-	coda := ""
-	i := 0
-
-	// Parse onset
-	for i < len(runes) {
-		r := runes[i]
-		if isVietnameseVowelRune(r) {
-			break
-		}
-
-		if r == breakMarker {
-			i++
-			continue
-		}
-
-		// Double-consonant 'dd' -> 'đ'
-		if (r == 'd' || r == 'D') && i+1 < len(runes) && (runes[i+1] == 'd' || runes[i+1] == 'D') {
-			if r == 'd' {
-				onset += "đ"
-			} else {
-				onset += "Đ"
-			}
-			i += 2
-			continue
-		}
-
-		// Only skip keys that are DEFINITELY not consonants in onset
-		if r == 'f' || r == 'F' || r == 'j' || r == 'J' || r == 'z' || r == 'Z' {
-			i++
-			continue
-		}
-
-		if isVietnameseConsonantRune(r) {
-			onset += string(r)
-			i++
-		} else {
-			break
-		}
+// processChar processes a regular character input.
+func (e *CompositionEngine) processChar(char rune) ProcessResult {
+	index := e.caretIndex(len(e.buffer.keys))
+	if e.buffer.caretFromEnd > 0 {
+		// Insert at the caret and reparse the whole word
+		keys := slices.Clone(e.buffer.keys)
+		e.replay(slices.Insert(keys, index, char))
+	} else {
+		e.processKeyInternal(char)
 	}
-
-	// Parse nucleus
-	for i < len(runes) {
-		r := runes[i]
-		if r == breakMarker {
-			i++
-			continue
-		}
-		if isVietnameseVowelRune(r) {
-			// Double-vowel transformations
-			if i+1 < len(runes) && unicode.ToLower(runes[i+1]) == unicode.ToLower(r) && runes[i+1] != breakMarker {
-				var transformed rune
-				switch unicode.ToLower(r) {
-				case 'a':
-					transformed = 'â'
-				case 'e':
-					transformed = 'ê'
-				case 'o':
-					transformed = 'ô'
-				}
-				if transformed != 0 {
-					if unicode.IsUpper(r) {
-						nucleus += string(unicode.ToUpper(transformed))
-					} else {
-						nucleus += string(transformed)
-					}
-					i += 2
-					continue
-				}
-			}
-			nucleus += string(r)
-			i++
-		} else if unicode.ToLower(r) == 'w' {
-			// Handle 'w' as vowel 'ư'
-			if len(nucleus) == 0 && e.config.EnableWAsVowel {
-				if unicode.IsUpper(r) {
-					nucleus += "Ư"
-				} else {
-					nucleus += "ư"
-				}
-				i++
-				consumedModifiers++
-				continue
-			}
-
-			// Horn/Breve modifier
-			if len(nucleus) > 0 {
-				nucleusRunes := []rune(nucleus)
-				lastIdx := len(nucleusRunes) - 1
-				last := nucleusRunes[lastIdx]
-
-				var transformed rune
-				switch unicode.ToLower(last) {
-				case 'a':
-					transformed = 'ă'
-				case 'o':
-					// Special case: uo + w -> ươ
-					if len(nucleusRunes) >= 2 && unicode.ToLower(nucleusRunes[len(nucleusRunes)-2]) == 'u' {
-						u := nucleusRunes[len(nucleusRunes)-2]
-						transformedU := 'ư'
-						if unicode.IsUpper(u) {
-							transformedU = 'Ư'
-						}
-						nucleusRunes[len(nucleusRunes)-2] = transformedU
-					}
-					transformed = 'ơ'
-				case 'u':
-					transformed = 'ư'
-				}
-
-				if transformed != 0 {
-					if unicode.IsUpper(last) {
-						nucleusRunes[lastIdx] = unicode.ToUpper(transformed)
-					} else {
-						nucleusRunes[lastIdx] = transformed
-					}
-					nucleus = string(nucleusRunes)
-					consumedModifiers++
-				}
-			}
-			i++
-		} else {
-			break
-		}
+	f := e.buffer.state.frames[index]
+	e.stats.Edited, e.stats.Reverted, e.stats.Rejected = f.edited, f.reverted, f.rejected
+	return ProcessResult{
+		Handled: true,
+		Preedit: e.GetPreedit(),
 	}
+}
 
-	// Parse coda
-	for i < len(runes) {
-		r := runes[i]
-		if isVietnameseConsonantRune(r) {
-			// 2-character coda
-			if i+1 < len(runes) {
-				nextR := runes[i+1]
-				if isVietnameseConsonantRune(nextR) && isValidCoda(string(r)+string(nextR)) {
-					coda += string(r) + string(nextR)
-					i += 2
-					continue
-				}
-			}
-			if isValidCoda(string(r)) {
-				coda += string(r)
-				i++
-			} else {
-				break
-			}
-		} else {
-			break
-		}
+// processKeyInternal appends a keystroke to the log and reduces it: the
+// input method turns the key into an edit, which is applied to the
+// composition before the syllable is parsed again.
+func (e *CompositionEngine) processKeyInternal(char rune) {
+	b := e.buffer
+	var edit Edit
+	if isModifierKey(e.inputMethod, char) {
+		edit = e.inputMethod.ProcessChar(char, &b.syllable)
 	}
+	b.keys = append(b.keys, char)
+	b.fresh = false
 
-	// Rule: Automatic vowel mark transformation for ia/ua/ia patterns followed by a coda.
-	// E.g. i + e + n -> iên, u + o + n -> uôn
-	nRunes := []rune(nucleus)
-	if coda != "" && len(nRunes) >= 2 {
-		first := unicode.ToLower(nRunes[0])
-		second := unicode.ToLower(nRunes[1])
-
-		// 'ia' + coda -> iê (tiền, tiếng)
-		if first == 'i' && second == 'e' {
-			if unicode.IsUpper(nRunes[1]) {
-				nRunes[1] = 'Ê'
-			} else {
-				nRunes[1] = 'ê'
-			}
-			nucleus = string(nRunes)
-		}
-		// 'ua' + coda -> uô (buồn, muốn)
-		if first == 'u' && second == 'o' {
-			if unicode.IsUpper(nRunes[1]) {
-				nRunes[1] = 'Ô'
-			} else {
-				nRunes[1] = 'ô'
-			}
-			nucleus = string(nRunes)
-		}
-	}
+	trailing := b.syllable.Consumed < len(b.state.letters)
+	reverted := b.state.apply(char, edit, &b.syllable, e.config)
 
-	// Important: Skip as many modifiers as were actually consumed.
-	consumedSoFar := 0
-	for i < len(runes) {
-		if e.isInputModifier(runes[i]) {
-			if consumedSoFar < consumedModifiers {
-				i++
-				consumedSoFar++
-			} else {
-				break
-			}
-		} else {
-			break
-		}
+	// A letter typed after trailing letters cannot change the syllable
+	if trailing && !reverted && b.state.last.kind == EditLetter {
+		b.syllable.LastKey = char
+		return
 	}
-
-	e.buffer.syllable.Onset = onset
-	e.buffer.syllable.Nucleus = nucleus
-	e.buffer.syllable.Coda = coda
-	e.buffer.syllable.Consumed = i
-	e.buffer.syllable.ConsumedModifiers = consumedModifiers
-
-	// Tone and VowelMark are already set in processChar
+	b.state.parse(&b.syllable, char)
 }
 
 // isMarkedVowelRune checks if a rune is a vowel with diacritic mark
@@ -957,46 +597,21 @@ func isVietnameseConsonantRune(r rune) bool {
 	return false
 }
 
-var validCodas = map[string]bool{
-	"c": true, "ch": true, "m": true, "n": true,
-	"ng": true, "nh": true, "p": true, "t": true,
-}
-
-// isValidCoda checks if a consonant can be a valid coda in Vietnamese.
-func isValidCoda(s string) bool {
-	lower := strings.ToLower(s)
-	return validCodas[lower]
-}
-
-// isTelexModifier checks if a character is a Telex modifier key.
-// These characters are consumed as modifiers and should be skipped when parsing raw buffer.
-func isTelexModifier(r rune) bool {
-	switch r {
-	case 's', 'S', 'f', 'F', 'r', 'R', 'x', 'X', 'j', 'J', 'z', 'Z', 'w', 'W':
-		return true
+// isValidCodaPair checks if consonant a, or a followed by b, is a valid coda
+// in Vietnamese (c, ch, m, n, ng, nh, p, t). b is 0 for a single consonant.
+func isValidCodaPair(a, b rune) bool {
+	a, b = unicode.ToLower(a), unicode.ToLower(b)
+	switch b {
+	case 0:
+		return a == 'c' || a == 'm' || a == 'n' || a == 'p' || a == 't'
+	case 'h':
+		return a == 'c' || a == 'n'
+	case 'g':
+		return a == 'n'
 	}
 	return false
 }
 
-// isVNIModifier checks if a character is a VNI modifier key (number 0-9)
-func isVNIModifier(r rune) bool {
-	return r >= '0' && r <= '9'
-}
-
-// isInputModifier checks if a character is a modifier for the given input method
-func (e *CompositionEngine) isInputModifier(r rune) bool {
-	if e.inputMethod == nil {
-		return isTelexModifier(r)
-	}
-
-	switch e.inputMethod.Name() {
-	case "VNI":
-		return isVNIModifier(r)
-	default:
-		return isTelexModifier(r)
-	}
-}
-
 // KeysymToRune converts an X11 keysym to a rune.
 func KeysymToRune(keysym uint32) rune {
 	// ASCII printable characters (0x20 - 0x7E)
@@ -1016,3 +631,12 @@ func KeysymToRune(keysym uint32) rune {
 
 	return 0
 }
+
+// RuneToKeysym returns the X keysym that types r: Latin-1 characters are
+// their own keysym and other characters are 0x01000000 plus the code point.
+func RuneToKeysym(r rune) uint32 {
+	if r >= 0x20 && r <= 0x7e || r >= 0xa0 && r <= 0xff {
+		return uint32(r)
+	}
+	return 0x01000000 + uint32(r)
+}
@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"sync"
 	"testing"
 )
 
@@ -277,3 +278,303 @@ func TestKeysymToRune(t *testing.T) {
 		})
 	}
 }
+
+func TestCompositionEngine_ResultDetails(t *testing.T) {
+	engine := NewCompositionEngine()
+
+	var result ProcessResult
+	for _, r := range "vieetj" {
+		result = engine.ProcessKey(KeyEvent{KeySym: uint32(r)})
+	}
+
+	if result.Preedit != "việt" {
+		t.Fatalf("Preedit = %q, want %q", result.Preedit, "việt")
+	}
+	if result.Cursor != 4 {
+		t.Errorf("Cursor = %d, want 4", result.Cursor)
+	}
+	if result.Mode != ModeVietnamese {
+		t.Errorf("Mode = %v, want %v", result.Mode, ModeVietnamese)
+	}
+	want := []PreeditSegment{{Text: "việt", Attr: PreeditAttrUnderline}}
+	if len(result.Segments) != len(want) || result.Segments[0] != want[0] {
+		t.Errorf("Segments = %+v, want %+v", result.Segments, want)
+	}
+
+	// Committing clears the preedit details
+	result = engine.ProcessKey(KeyEvent{KeySym: KeySpace})
+	if result.Segments != nil || result.Cursor != 0 {
+		t.Errorf("After commit: Segments = %+v, Cursor = %d, want none", result.Segments, result.Cursor)
+	}
+}
+
+func TestCompositionEngine_PreeditSegments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []PreeditSegment
+	}{
+		{"ban", []PreeditSegment{{"ban", PreeditAttrUnderline}}},
+		// Invalid initial is highlighted, rest of the syllable is not
+		{"bla", []PreeditSegment{
+			{"bl", PreeditAttrUnderline | PreeditAttrHighlight},
+			{"a", PreeditAttrUnderline},
+		}},
+		// Characters that could not be parsed into the syllable are highlighted
+		{"ab1", []PreeditSegment{
+			{"a", PreeditAttrUnderline},
+			{"b1", PreeditAttrUnderline | PreeditAttrHighlight},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			engine := NewCompositionEngine()
+			var result ProcessResult
+			for _, r := range tt.input {
+				result = engine.ProcessKey(KeyEvent{KeySym: uint32(r)})
+			}
+
+			joined := ""
+			for _, seg := range result.Segments {
+				joined += seg.Text
+			}
+			if joined != result.Preedit {
+				t.Errorf("Segments join to %q, want preedit %q", joined, result.Preedit)
+			}
+			if len(result.Segments) != len(tt.expected) {
+				t.Fatalf("Segments = %+v, want %+v", result.Segments, tt.expected)
+			}
+			for i := range tt.expected {
+				if result.Segments[i] != tt.expected[i] {
+					t.Errorf("Segments[%d] = %+v, want %+v", i, result.Segments[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCompositionEngine_ModeDisabled(t *testing.T) {
+	engine := NewCompositionEngine()
+	engine.SetEnabled(false)
+
+	result := engine.ProcessKey(KeyEvent{KeySym: 0x0061})
+	if result.Mode != ModeEnglish {
+		t.Errorf("Mode = %v, want %v", result.Mode, ModeEnglish)
+	}
+	if ModeEnglish.String() != "en" || ModeVietnamese.String() != "vi" {
+		t.Errorf("Mode labels = %q/%q, want en/vi", ModeEnglish, ModeVietnamese)
+	}
+}
+
+func TestCompositionEngine_CaretMovement(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		keys           []uint32
+		expected       string
+		expectedCursor int
+	}{
+		{"left moves over one character", "ban", []uint32{KeyLeft}, "ban", 2},
+		{"left skips tone keystrokes", "bans", []uint32{KeyLeft}, "bán", 2},
+		{"home then end", "ban", []uint32{KeyHome, KeyEnd}, "ban", 3},
+		{"left stops at start", "ab", []uint32{KeyLeft, KeyLeft, KeyLeft}, "ab", 0},
+		{"right stops at end", "ab", []uint32{KeyHome, KeyRight, KeyRight, KeyRight}, "ab", 2},
+		{"insert tone at caret", "ban", []uint32{KeyLeft, 's'}, "bán", 2},
+		{"insert onset at start", "ao", []uint32{KeyHome, 'c', 'h', KeyEnd, 'f'}, "chào", 4},
+		{"backspace at caret", "chao", []uint32{KeyLeft, KeyLeft, KeyBackspace}, "cao", 1},
+		{"backspace at start keeps word", "ab", []uint32{KeyHome, KeyBackspace}, "ab", 0},
+		{"delete at caret", "chao", []uint32{KeyHome, KeyRight, KeyDelete}, "cao", 1},
+		{"reparse after insertion", "tng", []uint32{KeyHome, KeyRight, 'i', 'e'}, "tiêng", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewCompositionEngine()
+			for _, r := range tt.input {
+				engine.ProcessKey(KeyEvent{KeySym: uint32(r)})
+			}
+			var result ProcessResult
+			for _, k := range tt.keys {
+				result = engine.ProcessKey(KeyEvent{KeySym: k})
+				if !result.Handled {
+					t.Fatalf("key 0x%x not handled", k)
+				}
+			}
+			if result.Preedit != tt.expected {
+				t.Errorf("Preedit = %q, want %q", result.Preedit, tt.expected)
+			}
+			if result.Cursor != tt.expectedCursor {
+				t.Errorf("Cursor = %d, want %d", result.Cursor, tt.expectedCursor)
+			}
+		})
+	}
+}
+
+func TestCompositionEngine_CaretKeysEmptyBuffer(t *testing.T) {
+	engine := NewCompositionEngine()
+
+	for _, k := range []uint32{KeyLeft, KeyRight, KeyHome, KeyEnd} {
+		if result := engine.ProcessKey(KeyEvent{KeySym: k}); result.Handled {
+			t.Errorf("key 0x%x on empty buffer should pass through", k)
+		}
+	}
+}
+
+func TestCompositionEngine_CaretKeysCommit(t *testing.T) {
+	engine := NewCompositionEngine()
+	engine.config.CommitOnCaretKeys = true
+
+	for _, r := range "bans" {
+		engine.ProcessKey(KeyEvent{KeySym: uint32(r)})
+	}
+	result := engine.ProcessKey(KeyEvent{KeySym: KeyLeft})
+
+	if result.Handled {
+		t.Error("Left should pass through after committing")
+	}
+	if result.CommitText != "bán" {
+		t.Errorf("CommitText = %q, want %q", result.CommitText, "bán")
+	}
+	if engine.GetPreedit() != "" {
+		t.Errorf("Preedit after commit = %q, want empty", engine.GetPreedit())
+	}
+}
+
+func TestCompositionEngine_TelexVNIParity(t *testing.T) {
+	// Equivalent keystrokes give the same preedit after every key and after
+	// every Backspace
+	tests := []struct {
+		telex string
+		vni   string
+	}{
+		{"dduwowcj", "d9u7o7c5"},
+		{"nguwowif", "ngu7o7i2"},
+		{"tieengs", "tie6ng1"},
+		{"muwaf", "mu7a2"},
+	}
+
+	telex := NewCompositionEngine()
+	vni := NewCompositionEngine()
+	vni.SetInputMethod(NewVNIMethod())
+	for _, tt := range tests {
+		telex.Reset()
+		vni.Reset()
+		t.Run(tt.telex, func(t *testing.T) {
+			keys, digits := []rune(tt.telex), []rune(tt.vni)
+			for i := range keys {
+				telex.ProcessKey(KeyEvent{KeySym: uint32(keys[i])})
+				vni.ProcessKey(KeyEvent{KeySym: uint32(digits[i])})
+				if got, want := vni.GetPreedit(), telex.GetPreedit(); got != want {
+					t.Errorf("after %q: VNI %q, Telex %q", string(digits[:i+1]), got, want)
+				}
+			}
+			for telex.GetPreedit() != "" {
+				telex.ProcessKey(KeyEvent{KeySym: KeyBackspace})
+				vni.ProcessKey(KeyEvent{KeySym: KeyBackspace})
+				if got, want := vni.GetPreedit(), telex.GetPreedit(); got != want {
+					t.Errorf("Backspace: VNI %q, Telex %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompositionEngine_RevertAndBackspace(t *testing.T) {
+	// A repeated modifier is typed as a letter, and Backspace removes the
+	// revert together with the edit it undid
+	tests := []struct {
+		method    InputMethod
+		input     string
+		reverted  string
+		backspace string
+	}{
+		{NewTelexMethod(), "aaa", "aa", "a"},
+		{NewTelexMethod(), "ass", "as", "a"},
+		{NewTelexMethod(), "ddd", "dd", "d"},
+		{NewVNIMethod(), "a66", "a6", "a"},
+		{NewVNIMethod(), "a11", "a1", "a"},
+		{NewVNIMethod(), "d99", "d9", "d"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method.Name()+"/"+tt.input, func(t *testing.T) {
+			engine := NewCompositionEngine()
+			engine.SetInputMethod(tt.method)
+			typeKeys(engine, tt.input, 0)
+			if got := engine.GetPreedit(); got != tt.reverted {
+				t.Errorf("%q: got %q, want %q", tt.input, got, tt.reverted)
+			}
+			engine.ProcessKey(KeyEvent{KeySym: KeyBackspace})
+			if got := engine.GetPreedit(); got != tt.backspace {
+				t.Errorf("%q + Backspace: got %q, want %q", tt.input, got, tt.backspace)
+			}
+		})
+	}
+}
+
+func TestCompositionEngine_LastKeyStats(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		keys  []uint32
+		want  KeyStats
+	}{
+		{"plain letter", "ba", []uint32{'n'}, KeyStats{}},
+		{"tone", "ban", []uint32{'s'}, KeyStats{Edited: true}},
+		{"revert", "aa", []uint32{'a'}, KeyStats{Edited: true, Reverted: true}},
+		{"tone on an invalid syllable", "ka", []uint32{'s'}, KeyStats{Rejected: true}},
+		{"backspace at the end pops", "ban", []uint32{KeyBackspace}, KeyStats{}},
+		{"backspace at caret replays", "chao", []uint32{KeyLeft, KeyLeft, KeyBackspace}, KeyStats{Replayed: 3}},
+		{"insert at caret replays", "ban", []uint32{KeyLeft, 's'}, KeyStats{Edited: true, Replayed: 4}},
+		{"modifier typed as a letter", "", []uint32{'s'}, KeyStats{}},
+		{"stats are per key", "ban", []uint32{KeyLeft, 's', KeyEnd}, KeyStats{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewCompositionEngine()
+			typeKeys(engine, tt.input, 0)
+			for _, k := range tt.keys {
+				engine.ProcessKey(KeyEvent{KeySym: k})
+			}
+			if got := engine.LastKeyStats(); got != tt.want {
+				t.Errorf("LastKeyStats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompositionEngine_AppendRawKeys(t *testing.T) {
+	engine := NewCompositionEngine()
+	typeKeys(engine, "Tieeng", 0)
+	engine.ProcessKey(KeyEvent{KeySym: 's'})
+	if got := string(engine.AppendRawKeys([]rune("> "))); got != "> Tieengs" {
+		t.Errorf("AppendRawKeys() = %q, want %q", got, "> Tieengs")
+	}
+	engine.ProcessKey(KeyEvent{KeySym: KeySpace})
+	if got := engine.AppendRawKeys(nil); len(got) != 0 {
+		t.Errorf("AppendRawKeys() after commit = %q, want empty", string(got))
+	}
+}
+
+func TestCompositionEngine_SharedConfigConcurrently(t *testing.T) {
+	// Engines sharing a config may run on different goroutines; run with
+	// -race to check that nothing else is shared
+	config := DefaultConfig()
+	config.InputMethodName = "VNI"
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			engine := NewConfiguredEngine(config)
+			for range 200 {
+				typeKeys(engine.CompositionEngine, "Tie6ng1 Vie6t5", 0)
+				if got := engine.GetPreedit(); got != "Việt" {
+					t.Errorf("preedit = %q, want Việt", got)
+					return
+				}
+				engine.Reset()
+			}
+		})
+	}
+	wg.Wait()
+}
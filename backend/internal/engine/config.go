@@ -1,23 +1,42 @@
 package engine
 
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
 // ToneRule defines which tone placement rule to use
 type ToneRule int
 
 const (
 	// ToneRuleOld is the traditional rule (quy tắc cũ)
-	// - hoà (on 'o'), của (on 'u'), mùa (on 'u')
+	// - hòa (on 'o'), khỏe (on 'o'), thủy (on 'u')
 	ToneRuleOld ToneRule = iota
 
 	// ToneRuleNew is the modern rule (quy tắc mới)
-	// - hòa (on 'a'), của (on 'a'), mùa (on 'a')
+	// - hoà (on 'a'), khoẻ (on 'e'), thuỷ (on 'y')
+	// Both rules put the tone on 'u' in của and mùa
 	ToneRuleNew
 )
 
-// EngineConfig holds configuration options for the engine
+// EngineConfig holds configuration options for the engine.
+// Engines keep a pointer to their config, so one config may be shared by
+// several engines; it must not be modified while any of them is in use.
+// Switch to a modified copy with SetConfig instead.
 type EngineConfig struct {
 	// ToneRule determines which tone placement rule to use
 	ToneRule ToneRule
 
+	// ToneOverrides replaces entries of the tone placement table: it maps a
+	// rhyme such as "oa" or "uynh" to the index of the vowel taking the tone
+	// (see TonePlacement)
+	ToneOverrides map[string]int
+
 	// EnableValidation enables Vietnamese validation before transformation
 	// When true, non-Vietnamese text won't be transformed
 	EnableValidation bool
@@ -29,19 +48,224 @@ type EngineConfig struct {
 	// EnableWAsVowel allows single 'w' to become 'ư' when valid
 	EnableWAsVowel bool
 
-	// InputMethodName specifies which input method to use ("Telex" or "VNI")
+	// InputMethodName specifies which registered input method to use
+	// ("Telex", "VNI", see InputMethods)
 	InputMethodName string
+
+	// OutputFormatName specifies which registered output format to use
+	// ("Unicode", see OutputFormats)
+	OutputFormatName string
+
+	// CommitOnCaretKeys makes Left/Right/Home/End commit the composing word
+	// and pass through to the application instead of moving the engine's caret
+	CommitOnCaretKeys bool
+
+	// ToggleHotkey is the chord that switches between Vietnamese and English,
+	// e.g. "ctrl+shift", "alt+z" or "shift+shift" (see ParseHotkey).
+	// Empty disables the hotkey.
+	ToggleHotkey string
+
+	// ModePerContext makes the daemon remember the Vietnamese/English mode
+	// separately for each input context instead of sharing one global mode
+	ModePerContext bool
 }
 
 // DefaultConfig returns the default engine configuration
 func DefaultConfig() *EngineConfig {
 	return &EngineConfig{
-		ToneRule:              ToneRuleOld, // Traditional rule by default
+		ToneRule:              ToneRuleNew, // Modern rule by default (hoà, thuỷ)
 		EnableValidation:      true,        // Enable validation
 		EnableDoubleKeyRevert: true,        // Enable double-key revert
 		EnableWAsVowel:        true,        // Enable W as vowel
 		InputMethodName:       "Telex",     // Default to Telex
+		OutputFormatName:      "Unicode",   // Default to Unicode
+		CommitOnCaretKeys:     false,       // Caret keys edit inside the word
+		ToggleHotkey:          "",          // No toggle hotkey
+		ModePerContext:        false,       // One mode for all contexts
+	}
+}
+
+// Clone returns a copy of the config that can be modified on its own.
+func (c *EngineConfig) Clone() *EngineConfig {
+	clone := *c
+	clone.ToneOverrides = maps.Clone(c.ToneOverrides)
+	return &clone
+}
+
+// Settings
+//
+// Every option has a short name used by configuration files, the corpus
+// directives and the daemon's Set method: method=VNI, rule=old, tone=oa:0,
+// validation=off. On/off options take on or off.
+
+// configSetting reads and writes one option by name.
+type configSetting struct {
+	get func(c *EngineConfig) string
+	set func(c *EngineConfig, value string) error
+}
+
+// configSettings maps setting names to options, in the order Settings lists
+// them.
+var configSettings = []struct {
+	name string
+	configSetting
+}{
+	{"method", configSetting{
+		func(c *EngineConfig) string { return c.InputMethodName },
+		func(c *EngineConfig, v string) error {
+			if _, err := NewInputMethod(v); err != nil {
+				return err
+			}
+			c.InputMethodName = v
+			return nil
+		},
+	}},
+	{"format", configSetting{
+		func(c *EngineConfig) string { return c.OutputFormatName },
+		func(c *EngineConfig, v string) error {
+			if _, err := NewOutputFormat(v); err != nil {
+				return err
+			}
+			c.OutputFormatName = v
+			return nil
+		},
+	}},
+	{"rule", configSetting{
+		func(c *EngineConfig) string {
+			if c.ToneRule == ToneRuleOld {
+				return "old"
+			}
+			return "new"
+		},
+		func(c *EngineConfig, v string) error {
+			switch v {
+			case "new":
+				c.ToneRule = ToneRuleNew
+			case "old":
+				c.ToneRule = ToneRuleOld
+			default:
+				return fmt.Errorf("unknown tone rule %q (want new or old)", v)
+			}
+			return nil
+		},
+	}},
+	{"tone", configSetting{
+		// rhyme:index pairs separated by commas
+		func(c *EngineConfig) string {
+			var pairs []string
+			for _, rhyme := range slices.Sorted(maps.Keys(c.ToneOverrides)) {
+				pairs = append(pairs, rhyme+":"+strconv.Itoa(c.ToneOverrides[rhyme]))
+			}
+			return strings.Join(pairs, ",")
+		},
+		func(c *EngineConfig, v string) error {
+			// tone=oa:0 puts the tone of the rhyme oa on its first vowel;
+			// tone= with nothing clears the overrides
+			if v == "" {
+				c.ToneOverrides = nil
+				return nil
+			}
+			for _, pair := range strings.Split(v, ",") {
+				rhyme, index, ok := strings.Cut(pair, ":")
+				pos, err := strconv.Atoi(index)
+				if !ok || rhyme == "" || err != nil {
+					return fmt.Errorf("want tone=rhyme:index, got %q", v)
+				}
+				if c.ToneOverrides == nil {
+					c.ToneOverrides = make(map[string]int)
+				}
+				c.ToneOverrides[rhyme] = pos
+			}
+			return nil
+		},
+	}},
+	{"validation", boolSetting(func(c *EngineConfig) *bool { return &c.EnableValidation })},
+	{"revert", boolSetting(func(c *EngineConfig) *bool { return &c.EnableDoubleKeyRevert })},
+	{"wvowel", boolSetting(func(c *EngineConfig) *bool { return &c.EnableWAsVowel })},
+	{"caretcommit", boolSetting(func(c *EngineConfig) *bool { return &c.CommitOnCaretKeys })},
+	{"hotkey", configSetting{
+		func(c *EngineConfig) string { return c.ToggleHotkey },
+		func(c *EngineConfig, v string) error {
+			if _, err := ParseHotkey(v); err != nil {
+				return err
+			}
+			c.ToggleHotkey = v
+			return nil
+		},
+	}},
+	{"modepercontext", boolSetting(func(c *EngineConfig) *bool { return &c.ModePerContext })},
+}
+
+// boolSetting reads and writes an on/off option.
+func boolSetting(field func(*EngineConfig) *bool) configSetting {
+	return configSetting{
+		func(c *EngineConfig) string {
+			if *field(c) {
+				return "on"
+			}
+			return "off"
+		},
+		func(c *EngineConfig, v string) error {
+			switch v {
+			case "on":
+				*field(c) = true
+			case "off":
+				*field(c) = false
+			default:
+				return fmt.Errorf("want on or off, got %q", v)
+			}
+			return nil
+		},
+	}
+}
+
+// Set changes the option with the given setting name.
+func (c *EngineConfig) Set(name, value string) error {
+	for _, s := range configSettings {
+		if s.name == name {
+			if err := s.set(c, value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown setting %q", name)
+}
+
+// Settings returns every option as name=value, in a fixed order.
+func (c *EngineConfig) Settings() []string {
+	settings := make([]string, 0, len(configSettings))
+	for _, s := range configSettings {
+		settings = append(settings, s.name+"="+s.get(c))
+	}
+	return settings
+}
+
+// ParseConfig reads a configuration file: one name = value setting per
+// line, with # comments. Options not set keep their defaults, and tone may
+// be set once per rhyme.
+func ParseConfig(r io.Reader) (*EngineConfig, error) {
+	config := DefaultConfig()
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want name = value, got %q", line, text)
+		}
+		if err := config.Set(strings.TrimSpace(name), strings.TrimSpace(value)); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
+	return config, nil
 }
 
 // ConfiguredEngine is an extended composition engine with configuration
@@ -57,32 +281,38 @@ func NewConfiguredEngine(config *EngineConfig) *ConfiguredEngine {
 	}
 
 	engine := NewCompositionEngine()
+	engine.config = config
 
-	// Set input method based on config
-	switch config.InputMethodName {
-	case "VNI":
-		engine.SetInputMethod(NewVNIMethod())
-	default:
-		engine.SetInputMethod(NewTelexMethod())
-	}
-
-	return &ConfiguredEngine{
+	e := &ConfiguredEngine{
 		CompositionEngine: engine,
 		config:            config,
 	}
+	e.applyNames()
+	return e
 }
 
 // SetConfig updates the engine configuration
 func (e *ConfiguredEngine) SetConfig(config *EngineConfig) {
 	e.config = config
+	e.CompositionEngine.config = config
 
-	// Update input method if changed
-	switch config.InputMethodName {
-	case "VNI":
-		e.SetInputMethod(NewVNIMethod())
-	default:
-		e.SetInputMethod(NewTelexMethod())
+	e.applyNames()
+}
+
+// applyNames sets the input method and output format named in the config.
+// Unknown or empty names fall back to Telex and Unicode.
+func (e *ConfiguredEngine) applyNames() {
+	method, err := NewInputMethod(e.config.InputMethodName)
+	if err != nil {
+		method = NewTelexMethod()
 	}
+	e.SetInputMethod(method)
+
+	format, err := NewOutputFormat(e.config.OutputFormatName)
+	if err != nil {
+		format = NewUnicodeFormat()
+	}
+	e.SetOutputFormat(format)
 }
 
 // GetConfig returns the current configuration
@@ -93,6 +323,7 @@ func (e *ConfiguredEngine) GetConfig() *EngineConfig {
 // SetToneRule sets the tone placement rule
 func (e *ConfiguredEngine) SetToneRule(rule ToneRule) {
 	e.config.ToneRule = rule
+	e.buffer.fresh = false
 }
 
 // SetEnableValidation enables or disables Vietnamese validation
@@ -110,6 +341,20 @@ func (e *ConfiguredEngine) SetEnableWAsVowel(enable bool) {
 	e.config.EnableWAsVowel = enable
 }
 
+// SetCommitOnCaretKeys chooses whether caret keys commit or move inside the word
+func (e *ConfiguredEngine) SetCommitOnCaretKeys(enable bool) {
+	e.config.CommitOnCaretKeys = enable
+}
+
+// SetToggleHotkey sets the Vietnamese/English toggle chord
+func (e *ConfiguredEngine) SetToggleHotkey(spec string) error {
+	if _, err := ParseHotkey(spec); err != nil {
+		return err
+	}
+	e.config.ToggleHotkey = spec
+	return nil
+}
+
 // UsesModernToneRule returns true if using the modern tone placement rule
 func (e *ConfiguredEngine) UsesModernToneRule() bool {
 	return e.config.ToneRule == ToneRuleNew
@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEngineConfig_Set(t *testing.T) {
+	config := DefaultConfig()
+	for _, setting := range []string{"method=VNI", "rule=old", "tone=oa:0,uy:0", "validation=off", "hotkey=alt+z", "modepercontext=on"} {
+		name, value, _ := strings.Cut(setting, "=")
+		if err := config.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := DefaultConfig()
+	want.InputMethodName = "VNI"
+	want.ToneRule = ToneRuleOld
+	want.ToneOverrides = map[string]int{"oa": 0, "uy": 0}
+	want.EnableValidation = false
+	want.ToggleHotkey = "alt+z"
+	want.ModePerContext = true
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("config = %+v, want %+v", config, want)
+	}
+
+	if err := config.Set("tone", ""); err != nil || config.ToneOverrides != nil {
+		t.Errorf("tone= should clear the overrides: %v %v", config.ToneOverrides, err)
+	}
+}
+
+func TestEngineConfig_SetErrors(t *testing.T) {
+	tests := []struct{ name, value, want string }{
+		{"speed", "fast", `unknown setting "speed"`},
+		{"method", "Dvorak", "method: unknown input method"},
+		{"rule", "newest", "rule: unknown tone rule"},
+		{"tone", "oa", "tone: want tone=rhyme:index"},
+		{"validation", "yes", "validation: want on or off"},
+		{"hotkey", "ctrl+nothing", "hotkey:"},
+	}
+	for _, tt := range tests {
+		err := DefaultConfig().Set(tt.name, tt.value)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Set(%q, %q) = %v, want %q", tt.name, tt.value, err, tt.want)
+		}
+	}
+}
+
+func TestEngineConfig_Settings(t *testing.T) {
+	want := "method=Telex format=Unicode rule=new tone= validation=on revert=on wvowel=on caretcommit=off hotkey= modepercontext=off"
+	if got := strings.Join(DefaultConfig().Settings(), " "); got != want {
+		t.Errorf("Settings() = %q\nwant %q", got, want)
+	}
+
+	// Settings read back give the same config
+	config := DefaultConfig()
+	config.Set("tone", "uy:0,oa:0")
+	config.Set("method", "VIQR")
+	parsed, err := ParseConfig(strings.NewReader(strings.Join(config.Settings(), "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, config) {
+		t.Errorf("parsed = %+v, want %+v", parsed, config)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(`
+# Typing
+method = VNI
+rule=old
+
+tone = oa:0
+tone = uy:0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.InputMethodName != "VNI" || config.ToneRule != ToneRuleOld || len(config.ToneOverrides) != 2 {
+		t.Errorf("config = %+v", config)
+	}
+	if !config.EnableValidation {
+		t.Error("options not set should keep their defaults")
+	}
+
+	if _, err := ParseConfig(strings.NewReader("method = VNI\nrule old\n")); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("err = %v, want a line 2 error", err)
+	}
+	if _, err := ParseConfig(strings.NewReader("revert = maybe\n")); err == nil || !strings.HasPrefix(err.Error(), "line 1: revert:") {
+		t.Errorf("err = %v, want a line 1 error", err)
+	}
+}
+
+func TestEngineConfig_Clone(t *testing.T) {
+	config := DefaultConfig()
+	config.Set("tone", "oa:0")
+	clone := config.Clone()
+	clone.Set("tone", "uy:0")
+	clone.InputMethodName = "VNI"
+	if len(config.ToneOverrides) != 1 || config.InputMethodName != "Telex" {
+		t.Errorf("changing the clone changed the original: %+v", config)
+	}
+}
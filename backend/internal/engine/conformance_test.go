@@ -0,0 +1,290 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"text/tabwriter"
+)
+
+// Round-trip conformance
+//
+// Every syllable of the inventory, in lower and upper case, is typed with
+// its canonical keystrokes under each input method and tone rule. The final
+// preedit must be the syllable, and backspacing to empty must show the
+// preedits typing showed, in reverse. Failures are counted per rhyme so a
+// broken rule shows up as a row of the matrix rather than a wall of words.
+//
+// The inventory writes its tones with TonePlacement, the code under test, so
+// the expected text is worked out again here from the written onset and the
+// rhyme table alone.
+
+// conformanceRun is one column of the failure matrix.
+type conformanceRun struct {
+	method string
+	rule   ToneRule
+	upper  bool
+}
+
+func (r conformanceRun) String() string {
+	rule, letters := "new", "lower"
+	if r.rule == ToneRuleOld {
+		rule = "old"
+	}
+	if r.upper {
+		letters = "upper"
+	}
+	return r.method + "/" + rule + "/" + letters
+}
+
+// conformanceMatrix counts failures by rhyme and run.
+type conformanceMatrix struct {
+	mu       sync.Mutex
+	failures map[string]map[string]int // Rhyme -> run -> failures
+	examples map[string][]string       // Run -> first failures
+	runs     []string
+}
+
+// conformanceExamples is how many failures are listed per run.
+const conformanceExamples = 5
+
+func (m *conformanceMatrix) add(run, rhyme, failure string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failures[rhyme] == nil {
+		m.failures[rhyme] = make(map[string]int)
+	}
+	m.failures[rhyme][run]++
+	if len(m.examples[run]) < conformanceExamples {
+		m.examples[run] = append(m.examples[run], failure)
+	}
+}
+
+// String renders the rhymes with failures as rows and the runs as columns.
+func (m *conformanceMatrix) String() string {
+	rhymes := make([]string, 0, len(m.failures))
+	for rhyme := range m.failures {
+		rhymes = append(rhymes, rhyme)
+	}
+	sort.Strings(rhymes)
+
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "rhyme\t")
+	for _, run := range m.runs {
+		fmt.Fprintf(w, "%s\t", run)
+	}
+	fmt.Fprintln(w)
+	for _, rhyme := range rhymes {
+		fmt.Fprintf(w, "%s\t", rhyme)
+		for _, run := range m.runs {
+			if n := m.failures[rhyme][run]; n > 0 {
+				fmt.Fprintf(w, "%d\t", n)
+			} else {
+				fmt.Fprint(w, ".\t")
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+
+	for _, run := range m.runs {
+		for _, example := range m.examples[run] {
+			fmt.Fprintf(&sb, "%s: %s\n", run, example)
+		}
+	}
+	return sb.String()
+}
+
+// conformanceRhyme is the matrix row of an entry: the rhyme, prefixed with
+// qu or gi since those onsets share a vowel with it.
+func conformanceRhyme(e InventoryEntry) string {
+	if e.Onset == "qu" || e.Onset == "gi" {
+		return e.Onset + "+" + e.Rhyme
+	}
+	return e.Rhyme
+}
+
+// conformanceText returns the syllable of e with the tone where the rhyme
+// table puts it: on the rhyme written after the onset, so never on the u of
+// qu or the i of gi unless the rhyme has no vowel of its own (gì, gìn). The
+// table lists quynh and quych under uy, so a rhyme it does not have after qu
+// or gi is looked up again with the glide.
+func conformanceText(e InventoryEntry, rule ToneRule) (string, error) {
+	var runes []rune
+	tone := ToneNone
+	for _, r := range e.Text {
+		base, t := GetBaseVowel(r)
+		if t != ToneNone {
+			tone = t
+		}
+		runes = append(runes, base)
+	}
+	if tone == ToneNone {
+		return string(runes), nil
+	}
+
+	start := len([]rune(e.Onset))
+	end := start
+	for end < len(runes) && IsVietnameseVowel(runes[end]) {
+		end++
+	}
+	if start == end {
+		runes[start-1] = toneVowel(runes[start-1], tone)
+		return string(runes), nil
+	}
+
+	lookup := func(start int) int {
+		entry, ok := rhymeTones[string(runes[start:end])]
+		if !ok {
+			return -1
+		}
+		pos := entry.open
+		if end < len(runes) {
+			pos = entry.closed
+		}
+		if rule == ToneRuleOld {
+			return int(pos.old)
+		}
+		return int(pos.new)
+	}
+	i := lookup(start)
+	if i < 0 && (e.Onset == "qu" || e.Onset == "gi") {
+		start--
+		i = lookup(start)
+	}
+	if i < 0 {
+		return "", fmt.Errorf("rhyme of %s is not in the tone table", e.Text)
+	}
+	runes[start+i] = toneVowel(runes[start+i], tone)
+	return string(runes), nil
+}
+
+// knownUntypeable reports the syllables the engine cannot type by design.
+func knownUntypeable(e InventoryEntry) bool {
+	// gi+e with a coda is always read as giê (giết)
+	return e.Onset == "gi" && e.Rhyme[0] == 'e' && e.Syllable.Coda != ""
+}
+
+// roundTrip types keys and backspaces them away, and returns what went wrong
+// or "" when the preedits are right.
+func roundTrip(engine *CompositionEngine, keys, want string) string {
+	engine.Reset()
+
+	// Preedits after each key; a key that reverted a modifier is deleted
+	// together with it, so its preedit is skipped on the way back
+	type step struct {
+		preedit  string
+		reverted bool
+	}
+	var steps []step
+	for _, r := range keys {
+		engine.ProcessKey(KeyEvent{KeySym: uint32(r)})
+		frames := engine.buffer.state.frames
+		steps = append(steps, step{engine.GetPreedit(), frames[len(frames)-1].reverted})
+	}
+	if got := steps[len(steps)-1].preedit; got != want {
+		return fmt.Sprintf("%s typed %q, want %q", keys, got, want)
+	}
+
+	for len(steps) > 0 {
+		reverted := steps[len(steps)-1].reverted
+		steps = steps[:len(steps)-1]
+		if reverted {
+			steps = steps[:len(steps)-1]
+		}
+		expected := ""
+		if len(steps) > 0 {
+			expected = steps[len(steps)-1].preedit
+		}
+		result := engine.ProcessKey(KeyEvent{KeySym: KeyBackspace})
+		if !result.Handled || result.Preedit != expected {
+			return fmt.Sprintf("%s backspaced to %q, want %q", keys, result.Preedit, expected)
+		}
+	}
+	if result := engine.ProcessKey(KeyEvent{KeySym: KeyBackspace}); result.Handled {
+		return fmt.Sprintf("%s left keys after backspacing to empty", keys)
+	}
+	return ""
+}
+
+func TestConformance_RoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("types the whole inventory under every method and rule")
+	}
+
+	var runs []conformanceRun
+	for _, method := range InputMethods() {
+		for _, rule := range []ToneRule{ToneRuleOld, ToneRuleNew} {
+			for _, upper := range []bool{false, true} {
+				runs = append(runs, conformanceRun{method, rule, upper})
+			}
+		}
+	}
+	matrix := &conformanceMatrix{
+		failures: make(map[string]map[string]int),
+		examples: make(map[string][]string),
+	}
+	for _, run := range runs {
+		matrix.runs = append(matrix.runs, run.String())
+	}
+
+	typed := 0
+	t.Run("runs", func(t *testing.T) {
+		for _, run := range runs {
+			t.Run(run.String(), func(t *testing.T) {
+				t.Parallel()
+				method, err := NewInputMethod(run.method)
+				if err != nil {
+					t.Fatal(err)
+				}
+				km, err := newKeyMap(method)
+				if err != nil {
+					t.Fatal(err)
+				}
+				config := DefaultConfig()
+				config.ToneRule = run.rule
+				engine := NewConfiguredEngine(config)
+				engine.SetInputMethod(method)
+
+				n := 0
+				for _, e := range Inventory(TonePlacement{Rule: run.rule}) {
+					if knownUntypeable(e) {
+						continue
+					}
+					n++
+					want, err := conformanceText(e, run.rule)
+					if err != nil {
+						matrix.add(run.String(), conformanceRhyme(e), err.Error())
+						continue
+					}
+					if want != e.Text {
+						matrix.add(run.String(), conformanceRhyme(e),
+							fmt.Sprintf("inventory writes %s, want %s", e.Text, want))
+					}
+					if run.upper {
+						want = strings.ToUpper(want)
+					}
+					keys, err := km.keystrokes(want)
+					if err != nil {
+						matrix.add(run.String(), conformanceRhyme(e), err.Error())
+						continue
+					}
+					if failure := roundTrip(engine.CompositionEngine, keys[0], want); failure != "" {
+						matrix.add(run.String(), conformanceRhyme(e), failure)
+					}
+				}
+				matrix.mu.Lock()
+				typed += n
+				matrix.mu.Unlock()
+			})
+		}
+	})
+
+	if len(matrix.failures) > 0 {
+		t.Errorf("round trip failures by rhyme:\n%s", matrix)
+	}
+	t.Logf("typed %d syllables in %d runs", typed, len(runs))
+}
@@ -0,0 +1,409 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Keystroke corpus
+//
+// A corpus is a plain-text file of typing cases, one per line:
+//
+//	[directives |] keys | expected step per key
+//
+//	tooi s               | t to tô tôi tối
+//	method=VNI rule=old  | hoa2 | h ho hoa hòa
+//	vieet <BS> <Esc>     | v vi vie viê vie []
+//
+// Directives are name=value settings of the engine (see EngineConfig.Set).
+// Keys are typed one by one; spaces between them are ignored and special
+// keys are written in angle brackets as in Vim: <BS>, <Esc>, <CR>, <Space>,
+// <S-a> for Shift+a, <C-a> for Ctrl+a. Each expected step is the preedit
+// after the key, preceded by the text the key committed in square brackets:
+// [tối<Space>] is a commit of "tối " with an empty preedit and [] is nothing
+// at all. * accepts any result. Lines starting with # are comments.
+
+// CorpusCase is one line of a keystroke corpus.
+type CorpusCase struct {
+	Line       int          // Line number in the corpus, from 1
+	Directives []string     // name=value settings as written
+	Script     string       // Keys as written
+	Keys       []KeyEvent   // Keys parsed from Script
+	Steps      []CorpusStep // Expected result of each key; empty when not written yet
+}
+
+// CorpusStep is the result of one key: what it committed and the preedit
+// after it.
+type CorpusStep struct {
+	Commit  string
+	Preedit string
+	Any     bool // Written as *: matches every result
+}
+
+// ParseCorpus reads the cases of a corpus.
+func ParseCorpus(r io.Reader) ([]CorpusCase, error) {
+	var cases []CorpusCase
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		c, err := parseCorpusLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		c.Line = line
+		cases = append(cases, c)
+	}
+	return cases, scanner.Err()
+}
+
+// parseCorpusLine parses one case.
+func parseCorpusLine(text string) (CorpusCase, error) {
+	var c CorpusCase
+	fields := strings.Split(text, "|")
+	switch len(fields) {
+	case 2:
+	case 3:
+		c.Directives = strings.Fields(fields[0])
+		fields = fields[1:]
+	default:
+		return c, fmt.Errorf("want [directives |] keys | steps, got %d fields", len(fields))
+	}
+	if _, err := c.Config(); err != nil {
+		return c, err
+	}
+
+	c.Script = strings.TrimSpace(fields[0])
+	keys, err := ParseKeyScript(c.Script)
+	if err != nil {
+		return c, err
+	}
+	if len(keys) == 0 {
+		return c, fmt.Errorf("no keys")
+	}
+	c.Keys = keys
+
+	for _, token := range strings.Fields(fields[1]) {
+		step, err := parseCorpusStep(token)
+		if err != nil {
+			return c, err
+		}
+		c.Steps = append(c.Steps, step)
+	}
+	if len(c.Steps) > 0 && len(c.Steps) != len(c.Keys) {
+		return c, fmt.Errorf("%d keys but %d steps", len(c.Keys), len(c.Steps))
+	}
+	return c, nil
+}
+
+// Config returns the default configuration with the case's directives
+// applied.
+func (c CorpusCase) Config() (*EngineConfig, error) {
+	config := DefaultConfig()
+	for _, d := range c.Directives {
+		name, value, ok := strings.Cut(d, "=")
+		if !ok {
+			return nil, fmt.Errorf("unknown directive %q", d)
+		}
+		if err := config.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// Run types the keys into a new engine and returns the result of each.
+func (c CorpusCase) Run() ([]CorpusStep, error) {
+	config, err := c.Config()
+	if err != nil {
+		return nil, err
+	}
+	engine := NewConfiguredEngine(config)
+	steps := make([]CorpusStep, len(c.Keys))
+	for i, key := range c.Keys {
+		result := engine.ProcessKey(key)
+		steps[i] = CorpusStep{Commit: result.CommitText, Preedit: engine.GetPreedit()}
+	}
+	return steps, nil
+}
+
+// String formats the case as a corpus line.
+func (c CorpusCase) String() string {
+	var sb strings.Builder
+	if len(c.Directives) > 0 {
+		sb.WriteString(strings.Join(c.Directives, " "))
+		sb.WriteString(" | ")
+	}
+	sb.WriteString(c.Script)
+	sb.WriteString(" |")
+	for _, step := range c.Steps {
+		sb.WriteByte(' ')
+		sb.WriteString(step.String())
+	}
+	return sb.String()
+}
+
+// Matches reports whether actual is the result the step expects.
+func (s CorpusStep) Matches(actual CorpusStep) bool {
+	return s.Any || s.Commit == actual.Commit && s.Preedit == actual.Preedit
+}
+
+// String formats the step as a corpus token.
+func (s CorpusStep) String() string {
+	if s.Any {
+		return "*"
+	}
+	if s.Commit == "" && s.Preedit == "" {
+		return "[]"
+	}
+	preedit := encodeCorpusText(s.Preedit)
+	if s.Commit == "" {
+		if preedit == "*" {
+			return "<U+002A>"
+		}
+		return preedit
+	}
+	return "[" + encodeCorpusText(s.Commit) + "]" + preedit
+}
+
+// parseCorpusStep parses an expected step token.
+func parseCorpusStep(token string) (CorpusStep, error) {
+	if token == "*" {
+		return CorpusStep{Any: true}, nil
+	}
+	var step CorpusStep
+	if rest, ok := strings.CutPrefix(token, "["); ok {
+		commit, preedit, ok := strings.Cut(rest, "]")
+		if !ok {
+			return step, fmt.Errorf("unclosed [ in %q", token)
+		}
+		text, err := decodeCorpusText(commit)
+		if err != nil {
+			return step, err
+		}
+		step.Commit = text
+		token = preedit
+	}
+	text, err := decodeCorpusText(token)
+	if err != nil {
+		return step, err
+	}
+	step.Preedit = text
+	return step, nil
+}
+
+// Key scripts
+
+// corpusKeys names the special keys of a key script.
+var corpusKeys = map[string]uint32{
+	"BS":       KeyBackspace,
+	"Del":      KeyDelete,
+	"Esc":      KeyEscape,
+	"CR":       KeyReturn,
+	"Enter":    KeyReturn,
+	"Tab":      KeyTab,
+	"Space":    KeySpace,
+	"Left":     KeyLeft,
+	"Right":    KeyRight,
+	"Up":       KeyUp,
+	"Down":     KeyDown,
+	"Home":     KeyHome,
+	"End":      KeyEnd,
+	"PageUp":   KeyPageUp,
+	"PageDown": KeyPageDown,
+	"Shift":    KeyShiftL,
+	"Ctrl":     KeyControlL,
+	"Alt":      KeyAltL,
+	"Super":    KeySuperL,
+	"lt":       '<',
+	"Bar":      '|',
+}
+
+// corpusKeyNames is corpusKeys inverted, without the aliases.
+var corpusKeyNames = func() map[uint32]string {
+	names := make(map[uint32]string, len(corpusKeys))
+	for name, keysym := range corpusKeys {
+		if name != "Enter" {
+			names[keysym] = name
+		}
+	}
+	return names
+}()
+
+// corpusModifiers are the modifier prefixes of a key script, in the order
+// they are written.
+var corpusModifiers = []struct {
+	prefix string
+	mask   uint32
+}{
+	{"R-", ModRelease},
+	{"C-", ModControl},
+	{"A-", ModMod1},
+	{"W-", ModMod4},
+	{"L-", ModLock},
+	{"S-", ModShift},
+}
+
+// ParseKeyScript parses the keys of a corpus line. Each character is a key
+// and whitespace is ignored. A key in angle brackets is a special key
+// (<BS>, <Esc>, <Space>, <lt> for <), a character or key with modifiers
+// (<S-a> Shift, <C-a> Ctrl, <A-a> Alt, <W-a> Super, <L-a> Caps Lock, <R-Ctrl>
+// a release), <U+01B0> for a character by code point or <0xff08> for a raw
+// keysym.
+func ParseKeyScript(script string) ([]KeyEvent, error) {
+	var keys []KeyEvent
+	for rest := script; rest != ""; {
+		r, size := utf8.DecodeRuneInString(rest)
+		switch {
+		case unicode.IsSpace(r):
+			rest = rest[size:]
+		case r == '<':
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed < in %q", script)
+			}
+			key, err := parseKeyName(rest[1:end])
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+			rest = rest[end+1:]
+		default:
+			keys = append(keys, KeyEvent{KeySym: RuneToKeysym(r)})
+			rest = rest[size:]
+		}
+	}
+	return keys, nil
+}
+
+// parseKeyName parses the inside of <...>.
+func parseKeyName(name string) (KeyEvent, error) {
+	var key KeyEvent
+	for _, m := range corpusModifiers {
+		// A single character after the prefix is the key itself (<S-->)
+		if rest, ok := strings.CutPrefix(name, m.prefix); ok && rest != "" {
+			key.Modifiers |= m.mask
+			name = rest
+		}
+	}
+
+	if keysym, ok := corpusKeys[name]; ok {
+		key.KeySym = keysym
+		return key, nil
+	}
+	if hex, ok := strings.CutPrefix(name, "U+"); ok {
+		r, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return key, fmt.Errorf("bad code point <%s>", name)
+		}
+		key.KeySym = RuneToKeysym(rune(r))
+		return key, nil
+	}
+	if hex, ok := strings.CutPrefix(name, "0x"); ok {
+		keysym, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return key, fmt.Errorf("bad keysym <%s>", name)
+		}
+		key.KeySym = uint32(keysym)
+		return key, nil
+	}
+	if r, size := utf8.DecodeRuneInString(name); size > 0 && size == len(name) {
+		key.KeySym = RuneToKeysym(r)
+		return key, nil
+	}
+	return key, fmt.Errorf("unknown key <%s>", name)
+}
+
+// FormatKeyScript writes keys as a key script that ParseKeyScript reads back.
+func FormatKeyScript(keys []KeyEvent) string {
+	var sb strings.Builder
+	for _, key := range keys {
+		name, named := corpusKeyNames[key.KeySym]
+		r := KeysymToRune(key.KeySym)
+		if key.Modifiers == 0 && !named && r > ' ' && r != 0x7f && RuneToKeysym(r) == key.KeySym {
+			sb.WriteRune(r)
+			continue
+		}
+
+		sb.WriteByte('<')
+		for _, m := range corpusModifiers {
+			if key.Modifiers&m.mask != 0 {
+				sb.WriteString(m.prefix)
+			}
+		}
+		switch {
+		case named:
+			sb.WriteString(name)
+		case r > ' ' && r != 0x7f && r != '>' && RuneToKeysym(r) == key.KeySym:
+			sb.WriteRune(r)
+		case r != 0 && RuneToKeysym(r) == key.KeySym:
+			fmt.Fprintf(&sb, "U+%04X", r)
+		default:
+			fmt.Fprintf(&sb, "0x%x", key.KeySym)
+		}
+		sb.WriteByte('>')
+	}
+	return sb.String()
+}
+
+// corpusEscapes are the characters of expected text written by name.
+var corpusEscapes = map[rune]string{
+	' ':  "Space",
+	'\t': "Tab",
+	'<':  "lt",
+	'|':  "Bar",
+	'[':  "U+005B",
+	']':  "U+005D",
+}
+
+// encodeCorpusText writes text so it fits in one step token.
+func encodeCorpusText(text string) string {
+	var sb strings.Builder
+	for _, r := range text {
+		if name, ok := corpusEscapes[r]; ok {
+			sb.WriteString("<" + name + ">")
+		} else if unicode.IsSpace(r) || unicode.IsControl(r) {
+			fmt.Fprintf(&sb, "<U+%04X>", r)
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// decodeCorpusText reads text written by encodeCorpusText.
+func decodeCorpusText(token string) (string, error) {
+	var sb strings.Builder
+	for rest := token; rest != ""; {
+		if !strings.HasPrefix(rest, "<") {
+			r, size := utf8.DecodeRuneInString(rest)
+			sb.WriteRune(r)
+			rest = rest[size:]
+			continue
+		}
+		end := strings.IndexByte(rest, '>')
+		if end < 0 {
+			return "", fmt.Errorf("unclosed < in %q", token)
+		}
+		key, err := parseKeyName(rest[1:end])
+		r := KeysymToRune(key.KeySym)
+		if err != nil || key.Modifiers != 0 || r == 0 && key.KeySym != KeyTab {
+			return "", fmt.Errorf("<%s> is not a character in %q", rest[1:end], token)
+		}
+		if key.KeySym == KeyTab {
+			r = '\t'
+		}
+		sb.WriteRune(r)
+		rest = rest[end+1:]
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// updateCorpus rewrites the expected steps of the corpus files with what the
+// engine does now: go test -run TestCorpus -update
+var updateCorpus = flag.Bool("update", false, "rewrite the expected steps in testdata/corpus")
+
+// TestCorpus runs the keystroke corpus in testdata/corpus (see corpus.go).
+func TestCorpus(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "corpus", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no corpus files")
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			runCorpusFile(t, file)
+		})
+	}
+}
+
+func runCorpusFile(t *testing.T, file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases, err := ParseCorpus(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("%s: %v", file, err)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for _, c := range cases {
+		actual, err := c.Run()
+		if err != nil {
+			t.Errorf("%s:%d: %v", file, c.Line, err)
+			continue
+		}
+		if *updateCorpus {
+			c.Steps = actual
+			lines[c.Line-1] = c.String()
+			continue
+		}
+		if len(c.Steps) == 0 {
+			t.Errorf("%s:%d: %s has no expected steps (run with -update)", file, c.Line, c.Script)
+			continue
+		}
+		for i, step := range c.Steps {
+			if !step.Matches(actual[i]) {
+				t.Errorf("%s:%d: %s: key %d (%s) gave %s, want %s", file, c.Line, c.Script,
+					i+1, FormatKeyScript(c.Keys[i:i+1]), actual[i], step)
+				break
+			}
+		}
+	}
+
+	if *updateCorpus {
+		if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParseCorpus(t *testing.T) {
+	input := `# comment
+
+tooi s | t to tô tôi tối
+method=VNI rule=old | hoa2 | h ho hoa hòa
+a<Space><S-b><C-a> | a [a<Space>] B [B]
+<BS>x | * x
+`
+	cases, err := ParseCorpus(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) != 4 {
+		t.Fatalf("got %d cases, want 4", len(cases))
+	}
+
+	if c := cases[0]; c.Line != 3 || len(c.Keys) != 5 || c.Steps[4].Preedit != "tối" {
+		t.Errorf("case 1 = %+v", c)
+	}
+	config, err := cases[1].Config()
+	if err != nil || config.InputMethodName != "VNI" || config.ToneRule != ToneRuleOld {
+		t.Errorf("case 2 config = %+v, %v", config, err)
+	}
+
+	keys := cases[2].Keys
+	want := []KeyEvent{{KeySym: 'a'}, {KeySym: KeySpace}, {KeySym: 'b', Modifiers: ModShift}, {KeySym: 'a', Modifiers: ModControl}}
+	if len(keys) != len(want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("key %d = %v, want %v", i, keys[i], want[i])
+		}
+	}
+	if step := cases[2].Steps[1]; step.Commit != "a " || step.Preedit != "" {
+		t.Errorf("step = %+v, want commit \"a \"", step)
+	}
+	if !cases[3].Steps[0].Any {
+		t.Error("* should match any result")
+	}
+
+	for _, c := range cases {
+		if got := c.String(); !strings.Contains(input, got) {
+			t.Errorf("String() = %q, not a line of the input", got)
+		}
+	}
+}
+
+func TestParseCorpus_Errors(t *testing.T) {
+	tests := []string{
+		"abc",                 // no steps field
+		"a | b | c | d",       // too many fields
+		"method=Nope | a | a", // unknown method
+		"speed=fast | a | a",  // unknown directive
+		"rule=middle | a | a", // bad value
+		"ab | a",              // step count
+		"<Nope> | x",          // unknown key
+		"<S-a | A",            // unclosed key
+		"a | [a",              // unclosed commit
+		"validation=yes | a | a",
+	}
+	for _, input := range tests {
+		if _, err := ParseCorpus(strings.NewReader(input)); err == nil {
+			t.Errorf("ParseCorpus(%q) succeeded", input)
+		}
+	}
+}
+
+func TestKeyScript_RoundTrip(t *testing.T) {
+	keys := []KeyEvent{
+		{KeySym: 'a'}, {KeySym: 'A'}, {KeySym: 'a', Modifiers: ModShift},
+		{KeySym: KeyBackspace}, {KeySym: KeyEscape}, {KeySym: KeySpace},
+		{KeySym: '<'}, {KeySym: '>'}, {KeySym: '|'},
+		{KeySym: 'a', Modifiers: ModControl | ModShift},
+		{KeySym: KeyControlL, Modifiers: ModRelease | ModControl},
+		{KeySym: RuneToKeysym('ư')}, {KeySym: 0xfe03},
+	}
+	script := FormatKeyScript(keys)
+	if want := "aA<S-a><BS><Esc><Space><lt>><Bar><C-S-a><R-C-Ctrl>ư<0xfe03>"; script != want {
+		t.Errorf("FormatKeyScript = %q, want %q", script, want)
+	}
+	parsed, err := ParseKeyScript(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != len(keys) {
+		t.Fatalf("parsed %d keys, want %d", len(parsed), len(keys))
+	}
+	for i := range keys {
+		if parsed[i] != keys[i] {
+			t.Errorf("key %d = %+v, want %+v", i, parsed[i], keys[i])
+		}
+	}
+}
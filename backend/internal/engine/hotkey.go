@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Hotkey describes the key chord that toggles between Vietnamese and English.
+//
+// Chords ending in a regular key (e.g. "alt+z") fire when the key is pressed
+// and are consumed. Modifier-only chords (e.g. "ctrl+shift") and double taps
+// (e.g. "shift+shift") fire when the modifier is released without any other
+// key in between, so they need the frontend to forward releases (ModRelease).
+type Hotkey struct {
+	Modifiers uint32 // Modifier flags that make up the chord
+	KeySym    uint32 // Regular key completing the chord, 0 for modifier-only chords
+	Double    bool   // Chord is a double tap of a single modifier
+}
+
+// hotkeyModifierMask is the set of modifiers considered when matching chords.
+// Caps Lock and other lock states are ignored.
+const hotkeyModifierMask = ModShift | ModControl | ModMod1 | ModMod4
+
+// Modifier names accepted in hotkey specs
+var hotkeyModifierNames = map[string]uint32{
+	"shift":   ModShift,
+	"ctrl":    ModControl,
+	"control": ModControl,
+	"alt":     ModMod1,
+	"super":   ModMod4,
+}
+
+// Named keys accepted in hotkey specs
+var hotkeyKeyNames = map[string]uint32{
+	"space":  KeySpace,
+	"tab":    KeyTab,
+	"escape": KeyEscape,
+}
+
+// ParseHotkey parses a chord such as "ctrl+shift", "alt+z" or "shift+shift".
+// An empty string or "none" disables the hotkey.
+func ParseHotkey(spec string) (Hotkey, error) {
+	var hotkey Hotkey
+
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if spec == "" || spec == "none" {
+		return hotkey, nil
+	}
+
+	for _, token := range strings.Split(spec, "+") {
+		token = strings.TrimSpace(token)
+
+		if mod, ok := hotkeyModifierNames[token]; ok {
+			if hotkey.Modifiers&mod != 0 {
+				hotkey.Double = true
+			}
+			hotkey.Modifiers |= mod
+			continue
+		}
+
+		if hotkey.KeySym != 0 {
+			return Hotkey{}, fmt.Errorf("hotkey %q: more than one non-modifier key", spec)
+		}
+		if keysym, ok := hotkeyKeyNames[token]; ok {
+			hotkey.KeySym = keysym
+			continue
+		}
+		runes := []rune(token)
+		if len(runes) != 1 || runes[0] > 0x7e || !unicode.IsPrint(runes[0]) {
+			return Hotkey{}, fmt.Errorf("hotkey %q: unknown key %q", spec, token)
+		}
+		hotkey.KeySym = uint32(runes[0])
+	}
+
+	switch {
+	case hotkey.Modifiers == 0:
+		return Hotkey{}, fmt.Errorf("hotkey %q: needs at least one modifier", spec)
+	case hotkey.Double && (hotkey.KeySym != 0 || !isSingleModifier(hotkey.Modifiers)):
+		return Hotkey{}, fmt.Errorf("hotkey %q: double tap must use a single modifier", spec)
+	case !hotkey.Double && hotkey.KeySym == 0 && isSingleModifier(hotkey.Modifiers):
+		return Hotkey{}, fmt.Errorf("hotkey %q: single modifier needs a key or a double tap", spec)
+	}
+
+	return hotkey, nil
+}
+
+// IsZero reports whether the hotkey is disabled.
+func (h Hotkey) IsZero() bool {
+	return h.Modifiers == 0 && h.KeySym == 0
+}
+
+// NeedsReleases reports whether the hotkey is a modifier-only chord or a
+// double tap, which only the key releases complete.
+func (h Hotkey) NeedsReleases() bool {
+	return !h.IsZero() && h.KeySym == 0
+}
+
+// isSingleModifier reports whether exactly one modifier flag is set.
+func isSingleModifier(mods uint32) bool {
+	return mods != 0 && mods&(mods-1) == 0
+}
+
+// modifierForKeysym returns the modifier flag a modifier key sets, or 0 if the
+// keysym is not a modifier key.
+func modifierForKeysym(keysym uint32) uint32 {
+	switch keysym {
+	case KeyShiftL, KeyShiftR:
+		return ModShift
+	case KeyControlL, KeyControlR:
+		return ModControl
+	case KeyAltL, KeyAltR:
+		return ModMod1
+	case KeySuperL, KeySuperR:
+		return ModMod4
+	}
+	return 0
+}
+
+// hotkeyState tracks modifier presses between events for modifier-only chords.
+type hotkeyState struct {
+	spec    string // Spec the hotkey was parsed from
+	hotkey  Hotkey // Parsed toggle hotkey
+	armed   bool   // Modifier-only chord is held with no other key pressed
+	tapping bool   // Double-tap modifier is held with no other key pressed
+	tapped  bool   // One clean tap of the double-tap modifier was completed
+}
+
+// toggleHotkey returns the parsed toggle hotkey, reparsing it when the
+// configuration changed. An invalid spec disables the hotkey.
+func (e *CompositionEngine) toggleHotkey() Hotkey {
+	if e.config.ToggleHotkey != e.hotkey.spec {
+		hotkey, _ := ParseHotkey(e.config.ToggleHotkey)
+		e.hotkey = hotkeyState{spec: e.config.ToggleHotkey, hotkey: hotkey}
+	}
+	return e.hotkey.hotkey
+}
+
+// matchToggleHotkey feeds the event to the hotkey tracker and reports whether
+// it completes the toggle chord.
+func (e *CompositionEngine) matchToggleHotkey(event KeyEvent) bool {
+	hotkey := e.toggleHotkey()
+	if hotkey.IsZero() {
+		return false
+	}
+
+	release := event.Modifiers&ModRelease != 0
+	held := event.Modifiers & hotkeyModifierMask
+	mod := modifierForKeysym(event.KeySym)
+	state := &e.hotkey
+
+	if mod == 0 {
+		// Any regular key breaks modifier-only chords
+		state.armed, state.tapping, state.tapped = false, false, false
+		if release || hotkey.KeySym == 0 {
+			return false
+		}
+		return uint32(unicode.ToLower(rune(event.KeySym))) == hotkey.KeySym && held == hotkey.Modifiers
+	}
+
+	switch {
+	case hotkey.KeySym != 0:
+		return false
+
+	case hotkey.Double:
+		if !release {
+			// The modifier's own flag is not yet in the state on press
+			state.tapping = mod == hotkey.Modifiers && held&^mod == 0
+			if !state.tapping {
+				state.tapped = false
+			}
+			return false
+		}
+		if !state.tapping || mod != hotkey.Modifiers {
+			state.tapping, state.tapped = false, false
+			return false
+		}
+		state.tapping = false
+		if state.tapped {
+			state.tapped = false
+			return true
+		}
+		state.tapped = true
+		return false
+
+	default:
+		if !release {
+			state.armed = mod&hotkey.Modifiers != 0 && held|mod == hotkey.Modifiers
+			return false
+		}
+		if state.armed && mod&hotkey.Modifiers != 0 {
+			state.armed = false
+			return true
+		}
+		state.armed = false
+		return false
+	}
+}
@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"testing"
+)
+
+func TestParseHotkey(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected Hotkey
+		wantErr  bool
+	}{
+		{"", Hotkey{}, false},
+		{"none", Hotkey{}, false},
+		{"ctrl+shift", Hotkey{Modifiers: ModControl | ModShift}, false},
+		{"Alt+Z", Hotkey{Modifiers: ModMod1, KeySym: 'z'}, false},
+		{"ctrl+space", Hotkey{Modifiers: ModControl, KeySym: KeySpace}, false},
+		{"shift+shift", Hotkey{Modifiers: ModShift, Double: true}, false},
+		{"z", Hotkey{}, true},           // would eat a letter
+		{"shift", Hotkey{}, true},       // single modifier needs a key
+		{"alt+z+x", Hotkey{}, true},     // two keys
+		{"ctrl+ctrl+z", Hotkey{}, true}, // double tap with key
+		{"hyper+z", Hotkey{}, true},     // unknown key name
+		{"ctrl+shift+shift", Hotkey{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			hotkey, err := ParseHotkey(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHotkey(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if hotkey != tt.expected {
+				t.Errorf("ParseHotkey(%q) = %+v, want %+v", tt.spec, hotkey, tt.expected)
+			}
+		})
+	}
+}
+
+func TestToggleHotkey_KeyChord(t *testing.T) {
+	engine := NewCompositionEngine()
+	engine.config.ToggleHotkey = "alt+z"
+
+	for _, r := range "vieetj" {
+		engine.ProcessKey(KeyEvent{KeySym: uint32(r)})
+	}
+
+	result := engine.ProcessKey(KeyEvent{KeySym: 'z', Modifiers: ModMod1})
+	if !result.Handled {
+		t.Error("Alt+Z should be consumed")
+	}
+	if result.CommitText != "việt" {
+		t.Errorf("CommitText = %q, want %q", result.CommitText, "việt")
+	}
+	if result.Mode != ModeEnglish {
+		t.Errorf("Mode = %v, want %v", result.Mode, ModeEnglish)
+	}
+
+	// English mode passes keys through
+	if result := engine.ProcessKey(KeyEvent{KeySym: 'a'}); result.Handled {
+		t.Error("Keys should pass through in English mode")
+	}
+
+	// Toggle back
+	result = engine.ProcessKey(KeyEvent{KeySym: 'Z', Modifiers: ModMod1 | ModShift})
+	if result.Mode != ModeEnglish {
+		t.Error("Alt+Shift+Z should not match Alt+Z")
+	}
+	result = engine.ProcessKey(KeyEvent{KeySym: 'z', Modifiers: ModMod1 | ModLock})
+	if result.Mode != ModeVietnamese {
+		t.Errorf("Mode after second Alt+Z = %v, want %v", result.Mode, ModeVietnamese)
+	}
+}
+
+func TestToggleHotkey_ModifierChord(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  []KeyEvent
+		toggled bool
+	}{
+		{
+			"ctrl then shift, released",
+			[]KeyEvent{
+				{KeySym: KeyControlL},
+				{KeySym: KeyShiftL, Modifiers: ModControl},
+				{KeySym: KeyShiftL, Modifiers: ModControl | ModShift | ModRelease},
+			},
+			true,
+		},
+		{
+			"shift then ctrl, released",
+			[]KeyEvent{
+				{KeySym: KeyShiftR},
+				{KeySym: KeyControlR, Modifiers: ModShift},
+				{KeySym: KeyControlR, Modifiers: ModControl | ModShift | ModRelease},
+			},
+			true,
+		},
+		{
+			"shortcut ctrl+shift+t does not toggle",
+			[]KeyEvent{
+				{KeySym: KeyControlL},
+				{KeySym: KeyShiftL, Modifiers: ModControl},
+				{KeySym: 'T', Modifiers: ModControl | ModShift},
+				{KeySym: KeyShiftL, Modifiers: ModControl | ModShift | ModRelease},
+			},
+			false,
+		},
+		{
+			"press without release does not toggle",
+			[]KeyEvent{
+				{KeySym: KeyControlL},
+				{KeySym: KeyShiftL, Modifiers: ModControl},
+			},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewCompositionEngine()
+			engine.config.ToggleHotkey = "ctrl+shift"
+
+			var result ProcessResult
+			for _, ev := range tt.events {
+				result = engine.ProcessKey(ev)
+			}
+			if result.Handled {
+				t.Error("Modifier-only chord should not be consumed")
+			}
+			if got := engine.Mode() == ModeEnglish; got != tt.toggled {
+				t.Errorf("toggled = %v, want %v", got, tt.toggled)
+			}
+		})
+	}
+}
+
+func TestToggleHotkey_DoubleShift(t *testing.T) {
+	tap := []KeyEvent{
+		{KeySym: KeyShiftL},
+		{KeySym: KeyShiftL, Modifiers: ModShift | ModRelease},
+	}
+
+	engine := NewCompositionEngine()
+	engine.config.ToggleHotkey = "shift+shift"
+
+	engine.ProcessKey(KeyEvent{KeySym: 'a'})
+	for _, ev := range tap {
+		engine.ProcessKey(ev)
+	}
+	if engine.Mode() != ModeVietnamese {
+		t.Fatal("A single Shift tap should not toggle")
+	}
+
+	var result ProcessResult
+	for _, ev := range tap {
+		result = engine.ProcessKey(ev)
+	}
+	if engine.Mode() != ModeEnglish {
+		t.Fatal("Double Shift should toggle")
+	}
+	if result.CommitText != "a" {
+		t.Errorf("CommitText = %q, want %q", result.CommitText, "a")
+	}
+
+	// A letter between taps breaks the double tap
+	for _, ev := range tap {
+		engine.ProcessKey(ev)
+	}
+	engine.ProcessKey(KeyEvent{KeySym: 'A', Modifiers: ModShift})
+	for _, ev := range tap {
+		engine.ProcessKey(ev)
+	}
+	if engine.Mode() != ModeEnglish {
+		t.Error("Shift taps separated by a letter should not toggle")
+	}
+}
+
+func TestToggleHotkey_Disabled(t *testing.T) {
+	engine := NewCompositionEngine()
+	engine.config.ToggleHotkey = "bogus+key"
+
+	engine.ProcessKey(KeyEvent{KeySym: 'a'})
+	result := engine.ProcessKey(KeyEvent{KeySym: 'z', Modifiers: ModMod1})
+	if result.Mode != ModeVietnamese || result.CommitText != "a" {
+		t.Errorf("Invalid hotkey should be ignored, got mode %v commit %q", result.Mode, result.CommitText)
+	}
+}
+
+func TestHotkey_NeedsReleases(t *testing.T) {
+	for spec, want := range map[string]bool{"": false, "alt+z": false, "ctrl+space": false, "ctrl+shift": true, "shift+shift": true} {
+		hotkey, err := ParseHotkey(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hotkey.NeedsReleases(); got != want {
+			t.Errorf("%q: NeedsReleases() = %v, want %v", spec, got, want)
+		}
+	}
+}
+
+func TestProcessKey_IgnoredKeysKeepPreedit(t *testing.T) {
+	engine := NewCompositionEngine()
+	engine.ProcessKey(KeyEvent{KeySym: 'v'})
+	engine.ProcessKey(KeyEvent{KeySym: 'i'})
+
+	for _, ev := range []KeyEvent{
+		{KeySym: 'i', Modifiers: ModRelease},
+		{KeySym: KeyShiftL},
+		{KeySym: KeyShiftL, Modifiers: ModShift | ModRelease},
+	} {
+		result := engine.ProcessKey(ev)
+		if result.Handled || result.CommitText != "" || result.Preedit != "vi" || result.Cursor != 2 {
+			t.Errorf("%+v: result %+v, want the preedit vi left alone", ev, result)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"sort"
+	"strings"
+)
+
+// Syllable inventory
+//
+// Inventory lists every syllable the grammar tables accept: each onset of
+// validInitials, each vowel cluster of vowelClusters with the codas it
+// allows, and each tone the coda allows. It is the one list spell checkers,
+// test corpora and candidate generation are built from, so it is derived
+// from the same tables the validator uses rather than kept by hand.
+
+// InventoryEntry is one syllable of the inventory.
+type InventoryEntry struct {
+	// Syllable as the parser reads it from the keys: qu and gi are parsed
+	// as q and g followed by a vowel, so quà is q+ua with ToneHuyen
+	Syllable Syllable
+
+	Onset string // Written onset: qu and gi are onsets here
+	Rhyme string // Cluster of the grammar table and coda, e.g. "a" in quà or "uân" in quân
+	Text  string // Unicode form in lower case
+}
+
+// Inventory returns every valid Vietnamese syllable in lower case, ordered
+// by onset, rhyme and tone. tones decides where the tone is written.
+func Inventory(tones TonePlacement) []InventoryEntry {
+	onsets := []string{""}
+	for onset := range validInitials {
+		// q is only ever written as qu
+		if onset != "q" {
+			onsets = append(onsets, onset)
+		}
+	}
+	sort.Strings(onsets)
+
+	clusters := make([]string, 0, len(vowelClusters))
+	for cluster := range vowelClusters {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+
+	codas := []string{""}
+	for coda := range codaBits {
+		codas = append(codas, coda)
+	}
+	sort.Strings(codas)
+
+	format := NewUnicodeFormat()
+	seen := make(map[string]bool)
+	var entries []InventoryEntry
+	for _, onset := range onsets {
+		for _, cluster := range clusters {
+			parsedOnset, nucleus, ok := inventoryNucleus(onset, cluster)
+			if !ok {
+				continue
+			}
+			rule := vowelClusters[cluster]
+			for _, coda := range codas {
+				if coda == "" && !rule.open || coda != "" && rule.codas&codaBits[coda] == 0 {
+					continue
+				}
+				base := Syllable{Onset: parsedOnset, Coda: coda, Tones: tones}
+				base.SetNucleus(nucleus)
+				if !ValidateSyllable(&base).Valid || clusterFit(parsedOnset, nucleus, coda) != fitComplete {
+					continue
+				}
+
+				for tone := ToneNone; tone <= ToneNang; tone++ {
+					if !toneFitsCoda(tone, coda) {
+						continue
+					}
+					s := base
+					s.ToneMark = tone
+					text := format.Compose(&s)
+					if seen[text] {
+						continue
+					}
+					seen[text] = true
+					entries = append(entries, InventoryEntry{
+						Syllable: s,
+						Onset:    onset,
+						Rhyme:    cluster + coda,
+						Text:     text,
+					})
+				}
+			}
+		}
+	}
+	return entries
+}
+
+// inventoryNucleus returns the onset and nucleus the parser reads for a
+// written onset and vowel cluster, and false when the pair is not written.
+func inventoryNucleus(onset, cluster string) (string, string, bool) {
+	switch onset {
+	case "qu":
+		// qu shares its u with the rhymes that start with u (quý, quốc)
+		if strings.HasPrefix(cluster, "u") {
+			return "q", cluster, cluster != "u"
+		}
+		return "q", "u" + cluster, true
+	case "gi":
+		// gi shares its i with the rhymes i and iê (gì, giếng); giá is gi+a
+		// rather than g+ia
+		if strings.HasPrefix(cluster, "i") {
+			return "g", cluster, cluster == "i" || strings.HasPrefix(cluster, "iê")
+		}
+		return "g", "i" + cluster, true
+	case "g":
+		// g before i is either gh or the onset gi
+		return onset, cluster, !strings.HasPrefix(cluster, "i")
+	}
+	return onset, cluster, true
+}
@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInventory_Words(t *testing.T) {
+	texts := make(map[string]InventoryEntry)
+	for _, e := range Inventory(TonePlacement{Rule: ToneRuleNew}) {
+		if _, dup := texts[e.Text]; dup {
+			t.Errorf("%q listed twice", e.Text)
+		}
+		texts[e.Text] = e
+	}
+
+	for _, word := range []string{
+		"a", "ở", "người", "giường", "giữa", "gì", "giếng", "quá", "quốc",
+		"quyển", "quýt", "khuỷu", "nghiêng", "đường", "hoà", "thuỷ", "oẳn", "xoong",
+	} {
+		if _, ok := texts[word]; !ok {
+			t.Errorf("%q missing", word)
+		}
+	}
+	for _, word := range []string{
+		"qúa", "gía", "hòa", "cem", "kan", "ghan", "ngeo", "q", "quoa", "giuy", "càt", "tìch",
+	} {
+		if _, ok := texts[word]; ok {
+			t.Errorf("%q listed", word)
+		}
+	}
+}
+
+func TestInventory_Entries(t *testing.T) {
+	format := NewUnicodeFormat()
+	for _, e := range Inventory(TonePlacement{}) {
+		s := e.Syllable
+		if !ValidateSyllable(&s).Valid {
+			t.Errorf("%q: does not validate", e.Text)
+		}
+		if !toneFitsCoda(s.ToneMark, s.Coda) {
+			t.Errorf("%q: tone %d after coda %q", e.Text, s.ToneMark, s.Coda)
+		}
+		if got := format.Compose(&s); got != e.Text {
+			t.Errorf("%q: syllable composes to %q", e.Text, got)
+		}
+		if !strings.HasPrefix(e.Text, s.Onset) || !strings.HasSuffix(e.Text, s.Coda) {
+			t.Errorf("%q: onset %q or coda %q not in text", e.Text, s.Onset, s.Coda)
+		}
+	}
+}
+
+func TestInventory_ToneRule(t *testing.T) {
+	has := func(entries []InventoryEntry, text string) bool {
+		for _, e := range entries {
+			if e.Text == text {
+				return true
+			}
+		}
+		return false
+	}
+
+	old := Inventory(TonePlacement{Rule: ToneRuleOld})
+	if !has(old, "hòa") || has(old, "hoà") {
+		t.Error("old rule: want hòa, not hoà")
+	}
+	if !has(old, "quý") {
+		t.Error("old rule: want quý (the u of qu takes no tone)")
+	}
+	if got, want := len(old), len(Inventory(TonePlacement{Rule: ToneRuleNew})); got != want {
+		t.Errorf("old rule lists %d syllables, new rule %d", got, want)
+	}
+}
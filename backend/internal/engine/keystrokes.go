@@ -0,0 +1,261 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Inverse mapping
+//
+// Keystrokes runs the engine backwards: given a Vietnamese word it finds
+// the keys that type it with an input method. The keys for each mark and
+// tone are not hard-coded per method; they are discovered by typing every
+// modifier key after a letter, so any registered input method works. Every
+// sequence returned has been typed through a CompositionEngine and checked
+// against the word.
+
+// Keystrokes returns the key sequences that type word with method, the
+// canonical one first: marks right after their vowel and the tone at the end
+// (người is nguwowif in Telex and ngu7o7i2 in VNI). The others put the tone
+// after its vowel or the marks at the end of the word.
+func Keystrokes(method InputMethod, word string) ([]string, error) {
+	km, err := newKeyMap(method)
+	if err != nil {
+		return nil, err
+	}
+	return km.keystrokes(word)
+}
+
+// TextKeystrokes returns the canonical keystrokes that type text with
+// method. Words are converted one by one; the characters between them are
+// kept as they are.
+func TextKeystrokes(method InputMethod, text string) (string, error) {
+	km, err := newKeyMap(method)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) {
+			sb.WriteRune(runes[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && unicode.IsLetter(runes[j]) {
+			j++
+		}
+		keys, err := km.keystrokes(string(runes[i:j]))
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(keys[0])
+		i = j
+	}
+	return sb.String(), nil
+}
+
+// keyMap holds the keys an input method types marks and tones with.
+type keyMap struct {
+	method InputMethod
+	marks  map[rune]rune     // Marked letter (ă, ư, đ) -> key typed after its base
+	tones  map[ToneMark]rune // Tone -> key
+	typer  *CompositionEngine
+}
+
+// keyMapLetters are the marked letters a key is looked for.
+const keyMapLetters = "ăâêôơưđ"
+
+// newKeyMap discovers the mark and tone keys of method by typing each of its
+// modifier keys after a letter.
+func newKeyMap(method InputMethod) (*keyMap, error) {
+	km := &keyMap{
+		method: method,
+		marks:  make(map[rune]rune),
+		tones:  make(map[ToneMark]rune),
+		typer:  NewCompositionEngine(),
+	}
+	km.typer.SetInputMethod(method)
+	modifiers := method.ModifierKeys()
+
+	for _, marked := range keyMapLetters {
+		base, _ := splitVowelMark(marked)
+		if marked == 'đ' {
+			base = 'd'
+		}
+		for _, key := range modifiers {
+			if km.typeKeys(string([]rune{base, key}), ToneRuleNew) == string(marked) {
+				km.marks[marked] = key
+				break
+			}
+		}
+	}
+	for tone := ToneSac; tone <= ToneNang; tone++ {
+		want := string(toneVowel('a', tone))
+		for _, key := range modifiers {
+			if km.typeKeys(string([]rune{'a', key}), ToneRuleNew) == want {
+				km.tones[tone] = key
+				break
+			}
+		}
+	}
+	if len(km.tones) == 0 && len(km.marks) == 0 {
+		return nil, fmt.Errorf("input method %s types no marks or tones", method.Name())
+	}
+	return km, nil
+}
+
+// typeKeys types keys into a fresh composition and returns the preedit.
+func (km *keyMap) typeKeys(keys string, rule ToneRule) string {
+	e := km.typer
+	e.config.ToneRule = rule
+	e.Reset()
+	for _, r := range keys {
+		e.ProcessKey(KeyEvent{KeySym: uint32(r)})
+	}
+	return e.GetPreedit()
+}
+
+// wordLetter is one letter of a word split into the keys that type it.
+type wordLetter struct {
+	base rune     // Letter key in the word's case (u for ư, d for đ)
+	mark rune     // Key for the vowel mark or stroke, 0 for none
+	tone ToneMark // Tone written on this letter
+}
+
+// keystrokes returns the verified key sequences for word.
+func (km *keyMap) keystrokes(word string) ([]string, error) {
+	letters, tone, err := km.split(word)
+	if err != nil {
+		return nil, err
+	}
+	toneKey, ok := km.tones[tone]
+	if tone != ToneNone && !ok {
+		return nil, fmt.Errorf("%s has no key for the tone of %q", km.method.Name(), word)
+	}
+	// Modifier keys are typed in lower case, except in an all-caps word
+	// typed with Caps Lock, where both cases work
+	casings := []bool{false}
+	if len(letters) > 1 && isAllCaps(word) {
+		casings = []bool{true, false}
+	}
+
+	var candidates []string
+	for _, caps := range casings {
+		modifier := func(r rune) rune {
+			if caps {
+				return unicode.ToUpper(r)
+			}
+			return r
+		}
+
+		var inline, afterVowel, marksLast strings.Builder
+		var pending []rune
+		for _, l := range letters {
+			inline.WriteRune(l.base)
+			afterVowel.WriteRune(l.base)
+			marksLast.WriteRune(l.base)
+			if l.mark != 0 {
+				inline.WriteRune(modifier(l.mark))
+				afterVowel.WriteRune(modifier(l.mark))
+				if len(pending) == 0 || pending[len(pending)-1] != l.mark {
+					pending = append(pending, l.mark)
+				}
+			}
+			if l.tone != ToneNone {
+				afterVowel.WriteRune(modifier(toneKey))
+			}
+		}
+		for _, key := range pending {
+			marksLast.WriteRune(modifier(key))
+		}
+		if tone != ToneNone {
+			inline.WriteRune(modifier(toneKey))
+			marksLast.WriteRune(modifier(toneKey))
+		}
+		candidates = append(candidates, inline.String(), afterVowel.String(), marksLast.String())
+	}
+
+	// A letter the method reads as a modifier (xoong in Telex) is typed
+	// twice so the second key reverts the first
+	var sequences []string
+	for _, keys := range candidates {
+		if keys = km.repair(keys, word); keys != "" && !slices.Contains(sequences, keys) {
+			sequences = append(sequences, keys)
+		}
+	}
+	if len(sequences) == 0 {
+		return nil, fmt.Errorf("cannot type %q with %s", word, km.method.Name())
+	}
+	return sequences, nil
+}
+
+// split breaks word into its letters and returns the tone of the word.
+func (km *keyMap) split(word string) ([]wordLetter, ToneMark, error) {
+	var letters []wordLetter
+	tone := ToneNone
+	for _, r := range word {
+		lower := unicode.ToLower(r)
+		base, t := GetBaseVowel(lower)
+		if t != ToneNone {
+			if tone != ToneNone {
+				return nil, ToneNone, fmt.Errorf("%q has more than one tone", word)
+			}
+			tone = t
+		}
+
+		l := wordLetter{base: base, tone: t}
+		if strings.ContainsRune(keyMapLetters, base) {
+			key, ok := km.marks[base]
+			if !ok {
+				return nil, ToneNone, fmt.Errorf("%s has no key for %q", km.method.Name(), base)
+			}
+			l.mark = key
+			l.base, _ = splitVowelMark(base)
+			if base == 'đ' {
+				l.base = 'd'
+			}
+		} else if !unicode.IsLetter(base) || base > unicode.MaxASCII {
+			return nil, ToneNone, fmt.Errorf("%q is not a Vietnamese letter", r)
+		}
+		if unicode.IsUpper(r) {
+			l.base = unicode.ToUpper(l.base)
+		}
+		letters = append(letters, l)
+	}
+	return letters, tone, nil
+}
+
+// repair types keys and returns them if they produce word under either tone
+// rule. Otherwise it tries typing each modifier key twice, so the second key
+// reverts the first, and returns "" when that does not help either.
+func (km *keyMap) repair(keys, word string) string {
+	if km.verify(keys, word) {
+		return keys
+	}
+	runes := []rune(keys)
+	for i, r := range runes {
+		if !km.isModifier(r) {
+			continue
+		}
+		doubled := string(runes[:i+1]) + string(runes[i:])
+		if km.verify(doubled, word) {
+			return doubled
+		}
+	}
+	return ""
+}
+
+// verify reports whether keys type word under either tone rule.
+func (km *keyMap) verify(keys, word string) bool {
+	return km.typeKeys(keys, ToneRuleNew) == word || km.typeKeys(keys, ToneRuleOld) == word
+}
+
+// isModifier reports whether key is one of the method's modifier keys.
+func (km *keyMap) isModifier(key rune) bool {
+	return isModifierKey(km.method, key)
+}
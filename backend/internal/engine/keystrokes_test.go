@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestKeystrokes(t *testing.T) {
+	tests := []struct {
+		method   InputMethod
+		word     string
+		expected []string
+	}{
+		{NewTelexMethod(), "người", []string{"nguwowif", "nguwowfi", "nguoiwf"}},
+		{NewTelexMethod(), "Đặng", []string{"Ddawngj", "Ddawjng"}},
+		{NewTelexMethod(), "xoong", []string{"xooong"}}, // oo would be ô
+		{NewTelexMethod(), "hòa", []string{"hoaf", "hofa"}},
+		{NewTelexMethod(), "quá", []string{"quas"}},
+		{NewTelexMethod(), "a", []string{"a"}},
+		{NewVNIMethod(), "người", []string{"ngu7o7i2", "ngu7o72i", "nguoi72"}},
+		{NewVNIMethod(), "NGƯỜI", []string{"NGU7O7I2", "NGU7O72I", "NGUOI72"}},
+		{NewVNIMethod(), "quyển", []string{"quye6n3", "quye63n", "quyen63"}},
+		{NewVIQRMethod(), "người", []string{"ngu+o+i`", "ngu+o+`i", "nguoi+`"}},
+		{NewVIQRMethod(), "Việt", []string{"Vie^t.", "Vie^.t", "Viet^."}},
+		{NewVIQRMethod(), "đường", []string{"ddu+o+ng`", "ddu+o+`ng"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method.Name()+"/"+tt.word, func(t *testing.T) {
+			got, err := Keystrokes(tt.method, tt.word)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("got %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestKeystrokes_AllCaps(t *testing.T) {
+	got, err := Keystrokes(NewTelexMethod(), "NGƯỜI")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != "NGUWOWIF" || !slices.Contains(got, "NGUwOwIf") {
+		t.Errorf("got %q, want NGUWOWIF first and NGUwOwIf among them", got)
+	}
+}
+
+func TestKeystrokes_Errors(t *testing.T) {
+	for _, word := range []string{"café", "ñandu", "hoàá"} {
+		if keys, err := Keystrokes(NewTelexMethod(), word); err == nil {
+			t.Errorf("%q: got %q, want an error", word, keys)
+		}
+	}
+}
+
+func TestTextKeystrokes(t *testing.T) {
+	tests := []struct {
+		method   InputMethod
+		text     string
+		expected string
+	}{
+		{NewTelexMethod(), "Tiếng Việt, người ơi!", "Tieengs Vieetj, nguwowif owi!"},
+		{NewVNIMethod(), "Tiếng Việt", "Tie6ng1 Vie6t5"},
+		{NewVIQRMethod(), "Tiếng Việt", "Tie^ng' Vie^t."},
+	}
+	for _, tt := range tests {
+		got, err := TextKeystrokes(tt.method, tt.text)
+		if err != nil || got != tt.expected {
+			t.Errorf("%s %q: got %q, %v; want %q", tt.method.Name(), tt.text, got, err, tt.expected)
+		}
+	}
+}
+
+func TestKeystrokes_Inventory(t *testing.T) {
+	// Every syllable types back to itself with every built-in method
+	if testing.Short() {
+		t.Skip("types the whole inventory")
+	}
+	for _, name := range []string{"Telex", "VNI", "VIQR"} {
+		method, _ := NewInputMethod(name)
+		km, err := newKeyMap(method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		failed := 0
+		for _, e := range Inventory(TonePlacement{Rule: ToneRuleNew}) {
+			// gi+e with a coda is always read as giê (giết), so gièm and
+			// gien cannot be typed
+			if e.Onset == "gi" && e.Rhyme[0] == 'e' && e.Syllable.Coda != "" {
+				continue
+			}
+			if _, err := km.keystrokes(e.Text); err != nil && failed < 10 {
+				t.Errorf("%s: %v", name, err)
+				failed++
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package engine
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Per-vowel marks
+//
+// A Syllable keeps its nucleus as base vowels (a, e, i, o, u, y) with one
+// VowelMark per vowel in Marks. The written cluster is built by applying each
+// vowel's own mark, so ươ is "uo" with {VowelHorn, VowelHorn} and uơ (thuở)
+// is "uo" with {VowelNone, VowelHorn}.
+//
+// Keys like Telex w or VNI 6/7/8 ask for a mark without naming the vowel. The
+// resolver tries every way of putting the mark on the vowels that accept it
+// and keeps the cluster the grammar table (vowelClusters) allows with the
+// syllable's onset and coda.
+
+// markedVowel is a vowel with a diacritic split into its base and mark.
+type markedVowel struct {
+	base rune
+	mark VowelMark
+}
+
+// unicodeMarkedVowels is the reverse of unicodeVowelMarks: ă -> {a, breve}.
+var unicodeMarkedVowels = func() map[rune]markedVowel {
+	reverse := make(map[rune]markedVowel)
+	for base, marks := range unicodeVowelMarks {
+		for mark, marked := range marks {
+			if mark != VowelDBar {
+				reverse[marked] = markedVowel{base, mark}
+			}
+		}
+	}
+	return reverse
+}()
+
+// splitVowelMark returns the base vowel and vowel mark of r. Tone marks are
+// not handled; unmarked runes come back unchanged with VowelNone.
+func splitVowelMark(r rune) (rune, VowelMark) {
+	if mv, ok := unicodeMarkedVowels[r]; ok {
+		return mv.base, mv.mark
+	}
+	return r, VowelNone
+}
+
+// applyMark returns base with mark applied, or base if it cannot carry it.
+func applyMark(base rune, mark VowelMark) rune {
+	if marked, ok := unicodeVowelMarks[base][mark]; ok {
+		return marked
+	}
+	return base
+}
+
+// MarkedNucleus returns the nucleus with each vowel's mark applied, without
+// the tone.
+func (s *Syllable) MarkedNucleus() string {
+	return markNucleus([]rune(s.Nucleus), s.Marks)
+}
+
+// SetNucleus stores a written vowel cluster such as "ươi" as base vowels and
+// per-vowel marks.
+func (s *Syllable) SetNucleus(cluster string) {
+	runes := []rune(cluster)
+	s.Marks = make([]VowelMark, len(runes))
+	for i, r := range runes {
+		runes[i], s.Marks[i] = splitVowelMark(r)
+	}
+	s.Nucleus = string(runes)
+}
+
+// markAt returns the mark of vowel i, VowelNone past the end of marks.
+func markAt(marks []VowelMark, i int) VowelMark {
+	if i < len(marks) {
+		return marks[i]
+	}
+	return VowelNone
+}
+
+// markNucleus applies marks to the base vowels of nucleus.
+func markNucleus(nucleus []rune, marks []VowelMark) string {
+	var sb strings.Builder
+	for i, r := range nucleus {
+		if i < len(marks) {
+			r = applyMark(r, marks[i])
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+var (
+	hatTargets   = MarkTargets{'a': VowelHat, 'e': VowelHat, 'o': VowelHat}
+	breveTargets = MarkTargets{'a': VowelBreve}
+	hornTargets  = MarkTargets{'o': VowelHorn, 'u': VowelHorn}
+)
+
+// targetsForMark returns the vowels a key producing mark can change.
+func targetsForMark(mark VowelMark) MarkTargets {
+	switch mark {
+	case VowelHat:
+		return hatTargets
+	case VowelBreve:
+		return breveTargets
+	case VowelHorn:
+		return hornTargets
+	}
+	return nil
+}
+
+// hasMarkTarget reports whether any vowel of nucleus accepts targets.
+func hasMarkTarget(nucleus []rune, targets MarkTargets) bool {
+	for _, r := range nucleus {
+		if _, ok := targets[unicode.ToLower(r)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveVowelMark decides which vowels of the nucleus a mark key changes and
+// sets their marks in place; marks must be as long as nucleus. Candidates
+// are every non-empty subset of the vowels in targets; the best one forms a
+// complete syllable with onset and coda, or failing that one that can still
+// be completed by a coda. Ties go to the candidate marking the anchor vowel
+// (the vowel the key was typed after), then to the one marking more vowels,
+// then to the rightmost. When no candidate is valid only the anchor vowel is
+// marked.
+func resolveVowelMark(onset string, nucleus []rune, marks []VowelMark, coda string, targets MarkTargets, anchor int) {
+	// No cluster is longer than maxClusterVowels, so longer runs of vowels
+	// go straight to the fallback
+	var eligible [maxClusterVowels]int
+	var buf [maxClusterVowels]VowelMark
+	var candidate []VowelMark
+	n := 0
+	if len(nucleus) <= maxClusterVowels {
+		candidate = buf[:len(nucleus)]
+		for i, r := range nucleus {
+			if _, ok := targets[unicode.ToLower(r)]; ok {
+				eligible[n] = i
+				n++
+			}
+		}
+	}
+
+	bestSet, bestScore := 0, 0
+	for set := 1; set < 1<<n; set++ {
+		copy(candidate, marks)
+		count, last, hasAnchor := 0, 0, false
+		for bit, i := range eligible[:n] {
+			if set&(1<<bit) == 0 {
+				continue
+			}
+			candidate[i] = targets[unicode.ToLower(nucleus[i])]
+			count++
+			last = i
+			hasAnchor = hasAnchor || i == anchor
+		}
+
+		fit := markedClusterFit(onset, nucleus, candidate, coda)
+		if fit == fitNone {
+			continue
+		}
+		score := int(fit) << 8
+		if hasAnchor {
+			score |= 1 << 7
+		}
+		score |= count<<4 | last
+		if score > bestScore {
+			bestSet, bestScore = set, score
+		}
+	}
+
+	if bestScore == 0 {
+		// Nothing valid: mark the vowel the key was typed after
+		if anchor >= 0 && anchor < len(nucleus) {
+			if mark, ok := targets[unicode.ToLower(nucleus[anchor])]; ok {
+				marks[anchor] = mark
+			}
+		}
+		return
+	}
+
+	for bit, i := range eligible[:n] {
+		if bestSet&(1<<bit) != 0 {
+			marks[i] = targets[unicode.ToLower(nucleus[i])]
+		}
+	}
+}
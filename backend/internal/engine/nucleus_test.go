@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSyllable_SetNucleus(t *testing.T) {
+	var s Syllable
+	s.SetNucleus("ƯƠi")
+
+	if s.Nucleus != "UOi" {
+		t.Errorf("Nucleus = %q, want %q", s.Nucleus, "UOi")
+	}
+	if want := []VowelMark{VowelHorn, VowelHorn, VowelNone}; !slices.Equal(s.Marks, want) {
+		t.Errorf("Marks = %v, want %v", s.Marks, want)
+	}
+	if got := s.MarkedNucleus(); got != "ƯƠi" {
+		t.Errorf("MarkedNucleus() = %q, want %q", got, "ƯƠi")
+	}
+}
+
+func TestUnicodeFormat_ComposePerVowelMarks(t *testing.T) {
+	format := NewUnicodeFormat()
+
+	tests := []struct {
+		syllable Syllable
+		expected string
+	}{
+		{Syllable{Onset: "ng", Nucleus: "uoi", Marks: []VowelMark{VowelHorn, VowelHorn}, ToneMark: ToneHuyen}, "người"},
+		{Syllable{Onset: "th", Nucleus: "uo", Marks: []VowelMark{VowelNone, VowelHorn}, ToneMark: ToneHoi}, "thuở"},
+		{Syllable{Onset: "r", Nucleus: "uou", Marks: []VowelMark{VowelHorn, VowelHorn, VowelNone}, ToneMark: ToneNang}, "rượu"},
+		{Syllable{Onset: "kh", Nucleus: "uyu", ToneMark: ToneHoi}, "khuỷu"},
+		{Syllable{Onset: "l", Nucleus: "uo", Marks: []VowelMark{VowelHorn, VowelHorn}, Coda: "n"}, "lươn"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if got := format.Compose(&tt.syllable); got != tt.expected {
+				t.Errorf("Compose() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResolveVowelMark(t *testing.T) {
+	tests := []struct {
+		onset, nucleus, coda string
+		targets              MarkTargets
+		expected             string
+	}{
+		{"ng", "uoi", "", hornTargets, "ươi"},
+		{"th", "uo", "", hornTargets, "uơ"},
+		{"th", "uo", "ng", hornTargets, "ươ"},
+		{"", "uo", "", hornTargets, "ươ"}, // ươ still needs a coda, uơ needs h/kh/q/th
+		{"r", "uou", "", hornTargets, "ươu"},
+		{"m", "ua", "", telexWTargets, "ưa"},
+		{"q", "ua", "n", telexWTargets, "uă"},
+		{"h", "oa", "c", telexWTargets, "oă"},
+		{"ng", "uye", "n", hatTargets, "uyê"},
+		{"q", "ouoa", "", breveTargets, "ouoă"}, // Longer than any cluster: anchor only
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.onset+tt.nucleus+tt.coda, func(t *testing.T) {
+			nucleus := []rune(tt.nucleus)
+			marks := make([]VowelMark, len(nucleus))
+			resolveVowelMark(tt.onset, nucleus, marks, tt.coda, tt.targets, len(nucleus)-1)
+			if got := markNucleus(nucleus, marks); got != tt.expected {
+				t.Errorf("got %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidateSyllable(t *testing.T) {
+	tests := []struct {
+		onset, cluster, coda string
+		valid                bool
+	}{
+		{"ng", "ươi", "", true},
+		{"th", "uơ", "", true},
+		{"l", "ươ", "n", true},
+		{"t", "ươ", "", true}, // Still typing
+		{"d", "uơ", "", false},
+		{"l", "uơ", "n", false},
+		{"t", "ươ", "ch", false},
+		{"q", "ưa", "", false},
+		{"q", "ua", "nh", true}, // qu + anh
+		{"q", "uâ", "n", true},
+		{"g", "iươ", "ng", true}, // gi + ương
+		{"g", "iô", "ng", true},
+		{"g", "iư", "", true},
+		{"q", "uoa", "", false}, // Only one medial
+		{"g", "iuy", "", false},
+		{"c", "ê", "", false}, // Spelled kê
+		{"gh", "ư", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.onset+tt.cluster+tt.coda, func(t *testing.T) {
+			s := Syllable{Onset: tt.onset, Coda: tt.coda}
+			s.SetNucleus(tt.cluster)
+			if got := ValidateSyllable(&s).Valid; got != tt.valid {
+				t.Errorf("Valid = %v, want %v", got, tt.valid)
+			}
+		})
+	}
+}
+
+func TestPerVowelMarks_Words(t *testing.T) {
+	tests := []struct {
+		method   InputMethod
+		input    string
+		expected string
+	}{
+		{NewTelexMethod(), "nguowif", "người"},
+		{NewTelexMethod(), "luown", "lươn"},
+		{NewTelexMethod(), "khuyur", "khuỷu"},
+		{NewTelexMethod(), "thuowr", "thuở"},
+		{NewTelexMethod(), "thuorw", "thuở"},
+		{NewTelexMethod(), "ruowuj", "rượu"},
+		{NewTelexMethod(), "huowu", "hươu"},
+		{NewTelexMethod(), "thuongw", "thương"},
+		{NewTelexMethod(), "quawn", "quăn"},
+		{NewVNIMethod(), "nguoi72", "người"},
+		{NewVNIMethod(), "luon7", "lươn"},
+		{NewVNIMethod(), "khuyu3", "khuỷu"},
+		{NewVNIMethod(), "thuo73", "thuở"},
+		{NewVNIMethod(), "duoc75", "dược"},
+		{NewVNIMethod(), "ruou75", "rượu"},
+		{NewVIQRMethod(), "ngu+o+i`", "người"},
+		{NewVIQRMethod(), "nguoi+`", "người"},
+		{NewVIQRMethod(), "ddu+o+ng`", "đường"},
+		{NewVIQRMethod(), "tha(ng'", "thắng"},
+		{NewVIQRMethod(), "vie^t.", "việt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method.Name()+"/"+tt.input, func(t *testing.T) {
+			engine := NewCompositionEngine()
+			engine.SetInputMethod(tt.method)
+			typeKeys(engine, tt.input, 0)
+			if got := engine.GetPreedit(); got != tt.expected {
+				t.Errorf("got %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
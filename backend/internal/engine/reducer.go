@@ -0,0 +1,364 @@
+package engine
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Keystroke reduction
+//
+// The composition buffer keeps the keys exactly as typed. Each key is turned
+// into an abstract Edit by the input method and applied to a keyState; the
+// syllable is then parsed from the resulting letters. Because the keystroke
+// log is never rewritten, removing or inserting a key and replaying the log
+// gives the same result for every input method.
+//
+// Every applied key pushes a frame recording what it changed, so removing
+// the last key is a pop rather than a replay. The state reuses its slices
+// and interns the onset, nucleus and coda strings, so once warmed up a
+// keystroke does not allocate.
+
+// letter is one letter of the composition.
+type letter struct {
+	r    rune      // Letter in its typed case: a base vowel, a consonant or đ
+	mark VowelMark // Vowel mark placed on this vowel by an explicit edit
+}
+
+// markRequest is a vowel mark whose vowels are picked once the whole
+// syllable is known (Telex w, VNI 6/7/8).
+type markRequest struct {
+	targets MarkTargets
+	anchor  int // Letter index of the vowel the key was typed after
+}
+
+// appliedEdit remembers the last edit so a repeated key can undo it.
+type appliedEdit struct {
+	key     rune
+	kind    EditKind  // EditLetter when there is nothing to undo
+	index   int       // Letter changed by EditMark or EditStroke
+	before  rune      // Previous letter for EditStroke
+	mark    VowelMark // Previous mark for EditMark
+	request bool      // EditMark added a mark request
+}
+
+// frame records what one keystroke changed so it can be popped. A key
+// appends at most one letter or request and overwrites at most one letter.
+type frame struct {
+	edited   bool        // The key's edit was applied or reverted the one before it
+	reverted bool        // The key reverted the one before it
+	rejected bool        // The validator turned the key's edit into a letter
+	letters  int         // len(letters) before the key
+	requests int         // len(requests) before the key
+	tone     ToneMark    // Tone before the key
+	last     appliedEdit // Last edit before the key
+	changed  int         // Letter overwritten by the key, -1 for none
+	before   letter      // Previous value of the changed letter
+	dropped  bool        // A revert removed the last mark request
+	request  markRequest // The request it removed
+}
+
+// keyState is the composition reduced from the keystroke log.
+type keyState struct {
+	letters  []letter
+	requests []markRequest
+	tone     ToneMark
+	last     appliedEdit
+	frames   []frame
+
+	// Scratch space reused by every parse
+	nucleus []rune
+	marks   []VowelMark
+	strings interner
+}
+
+// reset clears the state, keeping its buffers for the next word.
+func (s *keyState) reset() {
+	s.letters = s.letters[:0]
+	s.requests = s.requests[:0]
+	s.frames = s.frames[:0]
+	s.tone = ToneNone
+	s.last = appliedEdit{}
+}
+
+// apply reduces one keystroke and reports whether it reverted the previous
+// one. current is the syllable parsed before the key.
+func (s *keyState) apply(key rune, edit Edit, current *Syllable, config *EngineConfig) bool {
+	f := frame{
+		letters:  len(s.letters),
+		requests: len(s.requests),
+		tone:     s.tone,
+		last:     s.last,
+		changed:  -1,
+	}
+
+	// Double-key revert: the same modifier key again undoes its edit, and the
+	// modifier is typed as a letter in the case it was typed in
+	if config.EnableDoubleKeyRevert && s.last.kind != EditLetter &&
+		unicode.ToLower(s.last.key) == unicode.ToLower(key) {
+		s.undo(&f)
+		s.letters = append(s.letters, letter{r: s.last.key})
+		s.last = appliedEdit{}
+		f.edited, f.reverted = true, true
+		s.frames = append(s.frames, f)
+		return true
+	}
+
+	// Once letters trail the syllable the word is not Vietnamese and
+	// modifier keys are typed as letters
+	if current.Consumed < len(s.letters) {
+		edit = Edit{}
+	} else if !editAllowed(edit, current, config) {
+		// w as ư switched off is a setting, not a rejection
+		f.rejected = edit.Kind != EditInsertVowel || config.EnableWAsVowel
+		edit = Edit{}
+	}
+
+	last := appliedEdit{key: key, kind: edit.Kind}
+	f.edited = edit.Kind != EditLetter
+	switch edit.Kind {
+	case EditTone:
+		if s.tone == edit.Tone && edit.Tone != ToneNone {
+			s.tone = ToneNone
+		} else {
+			s.tone = edit.Tone
+		}
+
+	case EditMark:
+		onsetLen := utf8.RuneCountInString(current.Onset)
+		if edit.Vowel < 0 {
+			anchor := -1
+			for i, r := range current.Nucleus {
+				if _, ok := edit.Targets[unicode.ToLower(r)]; ok {
+					anchor = onsetLen + utf8.RuneCountInString(current.Nucleus[:i])
+				}
+			}
+			s.requests = append(s.requests, markRequest{targets: edit.Targets, anchor: anchor})
+			last.request = true
+			break
+		}
+		last.index = onsetLen + edit.Vowel
+		last.mark = s.letters[last.index].mark
+		f.changed, f.before = last.index, s.letters[last.index]
+		s.letters[last.index].mark = edit.Mark
+
+	case EditStroke:
+		for i, l := range s.letters {
+			if l.r == 'd' || l.r == 'D' {
+				last.index, last.before = i, l.r
+				f.changed, f.before = i, l
+				s.letters[i].r = withCaseOf('đ', l.r, false)
+				break
+			}
+		}
+
+	case EditInsertVowel:
+		r := withCaseOf(edit.Char, key, isAllCaps(current.Onset))
+		s.letters = append(s.letters, letter{r: r, mark: edit.Mark})
+
+	default:
+		s.letters = append(s.letters, letter{r: key})
+	}
+	s.last = last
+	s.frames = append(s.frames, f)
+	return false
+}
+
+// undo reverts the last edit, noting in f what it overwrote.
+func (s *keyState) undo(f *frame) {
+	switch s.last.kind {
+	case EditTone:
+		s.tone = ToneNone
+	case EditMark:
+		if s.last.request {
+			f.dropped, f.request = true, s.requests[len(s.requests)-1]
+			s.requests = s.requests[:len(s.requests)-1]
+		} else {
+			f.changed, f.before = s.last.index, s.letters[s.last.index]
+			s.letters[s.last.index].mark = s.last.mark
+		}
+	case EditStroke:
+		f.changed, f.before = s.last.index, s.letters[s.last.index]
+		s.letters[s.last.index].r = s.last.before
+	case EditInsertVowel:
+		// The revert letter takes the vowel's place
+		n := len(s.letters) - 1
+		f.changed, f.before = n, s.letters[n]
+		s.letters = s.letters[:n]
+	}
+}
+
+// pop removes the last keystroke and reports whether it was a revert.
+func (s *keyState) pop() bool {
+	f := s.frames[len(s.frames)-1]
+	s.frames = s.frames[:len(s.frames)-1]
+
+	s.letters = s.letters[:f.letters]
+	if f.changed >= 0 {
+		s.letters[f.changed] = f.before
+	}
+	s.requests = s.requests[:f.requests]
+	if f.dropped {
+		s.requests[f.requests-1] = f.request
+	}
+	s.tone = f.tone
+	s.last = f.last
+	return f.reverted
+}
+
+// editAllowed reports whether an edit may change the syllable. With
+// validation enabled, marks and tones need a valid Vietnamese syllable and
+// the stroke needs a valid onset. Telex w as ư must always form a valid
+// syllable and can be switched off.
+func editAllowed(edit Edit, current *Syllable, config *EngineConfig) bool {
+	switch edit.Kind {
+	case EditLetter:
+		return true
+	case EditInsertVowel:
+		marks := [1]VowelMark{edit.Mark}
+		test := Syllable{Onset: current.Onset, Nucleus: string(edit.Char), Marks: marks[:], Coda: current.Coda}
+		return config.EnableWAsVowel && ValidateSyllable(&test).Valid
+	}
+
+	if !config.EnableValidation {
+		return true
+	}
+	if edit.Kind == EditStroke {
+		var buf [16]byte
+		return isValidInitial(string(initialKey(buf[:0], current.Onset)))
+	}
+	return current.Nucleus != "" &&
+		ValidateVietnamese(current.Onset, current.Nucleus, current.Coda).Valid
+}
+
+// parse parses the letters into onset, nucleus and coda and places the
+// pending vowel marks. Letters after the coda are not part of the syllable
+// and are counted out by Consumed. The syllable's Marks share the state's
+// scratch space and are only valid until the next parse.
+func (s *keyState) parse(syllable *Syllable, lastKey rune) {
+	letters := s.letters
+	i := 0
+
+	// Parse onset
+	for i < len(letters) && isVietnameseConsonantRune(letters[i].r) {
+		i++
+	}
+	onsetEnd := i
+
+	// Parse nucleus as base vowels with per-vowel marks
+	nucleus, marks := s.nucleus[:0], s.marks[:0]
+	for i < len(letters) && isVietnameseVowelRune(letters[i].r) {
+		nucleus = append(nucleus, letters[i].r)
+		marks = append(marks, letters[i].mark)
+		i++
+	}
+	s.nucleus, s.marks = nucleus, marks
+	codaStart := i
+
+	// Parse coda
+	for i < len(letters) && len(nucleus) > 0 {
+		r := letters[i].r
+		if !isVietnameseConsonantRune(r) {
+			break
+		}
+		// 2-character coda
+		if i+1 < len(letters) && isValidCodaPair(r, letters[i+1].r) {
+			i += 2
+			continue
+		}
+		if !isValidCodaPair(r, 0) {
+			break
+		}
+		i++
+	}
+
+	onset := s.strings.letters(letters[:onsetEnd])
+	coda := s.strings.letters(letters[codaStart:i])
+
+	// Put each requested mark on the vowels that make a valid syllable
+	// (ươ in người, uơ in thuở)
+	for _, req := range s.requests {
+		resolveVowelMark(onset, nucleus, marks, coda, req.targets, req.anchor-onsetEnd)
+	}
+
+	// Rule: Automatic vowel mark transformation for ie/uo patterns followed by a coda.
+	// E.g. i + e + n -> iên, u + o + n -> uôn
+	if coda != "" && len(nucleus) >= 2 && marks[0] == VowelNone && marks[1] == VowelNone {
+		first, second := unicode.ToLower(nucleus[0]), unicode.ToLower(nucleus[1])
+		if first == 'i' && second == 'e' || first == 'u' && second == 'o' {
+			marks[1] = VowelHat
+		}
+	}
+
+	*syllable = Syllable{
+		LastKey:  lastKey,
+		Onset:    onset,
+		Nucleus:  s.strings.runes(nucleus),
+		Marks:    marks,
+		Coda:     coda,
+		ToneMark: s.tone,
+		Consumed: i,
+	}
+}
+
+// tail returns the letters after the syllable as they were typed.
+func (s *keyState) tail(consumed int) string {
+	if consumed >= len(s.letters) {
+		return ""
+	}
+	var sb strings.Builder
+	sb.Grow(len(s.letters) - consumed)
+	for _, l := range s.letters[consumed:] {
+		sb.WriteRune(applyMark(l.r, l.mark))
+	}
+	return sb.String()
+}
+
+// maxInterned bounds the strings an interner keeps, so typing garbage
+// cannot grow it without limit.
+const maxInterned = 1024
+
+// interner returns one shared string per distinct rune sequence, so parsing
+// the same onset, nucleus or coda again does not allocate.
+type interner struct {
+	seen map[string]string
+	buf  []byte
+}
+
+// runes returns rs as an interned string.
+func (in *interner) runes(rs []rune) string {
+	if len(rs) == 0 {
+		return ""
+	}
+	in.buf = in.buf[:0]
+	for _, r := range rs {
+		in.buf = utf8.AppendRune(in.buf, r)
+	}
+	return in.intern()
+}
+
+// letters returns the base letters of ls as an interned string.
+func (in *interner) letters(ls []letter) string {
+	if len(ls) == 0 {
+		return ""
+	}
+	in.buf = in.buf[:0]
+	for _, l := range ls {
+		in.buf = utf8.AppendRune(in.buf, l.r)
+	}
+	return in.intern()
+}
+
+func (in *interner) intern() string {
+	if s, ok := in.seen[string(in.buf)]; ok {
+		return s
+	}
+	s := string(in.buf)
+	if in.seen == nil {
+		in.seen = make(map[string]string)
+	}
+	if len(in.seen) < maxInterned {
+		in.seen[s] = s
+	}
+	return s
+}
@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Input method and output format registry
+//
+// Input methods and output formats register a factory under their name,
+// usually from an init function next to their implementation. The engine
+// and its configuration look them up by name (case-insensitive), so adding a
+// method or an encoding does not touch the composition engine.
+
+// InputMethodFactory creates a new instance of an input method.
+type InputMethodFactory func() InputMethod
+
+// OutputFormatFactory creates a new instance of an output format.
+type OutputFormatFactory func() OutputFormat
+
+type registryEntry[F any] struct {
+	name    string // Name as registered
+	factory F
+}
+
+// registry maps lower-cased names to factories.
+type registry[F any] struct {
+	mu      sync.RWMutex
+	kind    string // "input method" or "output format", for messages
+	entries map[string]registryEntry[F]
+}
+
+func newRegistry[F any](kind string) *registry[F] {
+	return &registry[F]{kind: kind, entries: make(map[string]registryEntry[F])}
+}
+
+func (r *registry[F]) register(name string, factory F, isNil bool) {
+	if name == "" || isNil {
+		panic(fmt.Sprintf("engine: registering %s needs a name and a factory", r.kind))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := strings.ToLower(name)
+	if _, dup := r.entries[key]; dup {
+		panic(fmt.Sprintf("engine: %s %q registered twice", r.kind, name))
+	}
+	r.entries[key] = registryEntry[F]{name: name, factory: factory}
+}
+
+func (r *registry[F]) lookup(name string) (F, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.entries[strings.ToLower(name)]
+	if !ok {
+		var zero F
+		return zero, fmt.Errorf("unknown %s %q", r.kind, name)
+	}
+	return entry.factory, nil
+}
+
+func (r *registry[F]) names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.entries))
+	for _, entry := range r.entries {
+		names = append(names, entry.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	inputMethods  = newRegistry[InputMethodFactory]("input method")
+	outputFormats = newRegistry[OutputFormatFactory]("output format")
+)
+
+// RegisterInputMethod makes an input method available by name. It panics if
+// the name is empty, the factory is nil or the name is already registered.
+func RegisterInputMethod(name string, factory InputMethodFactory) {
+	inputMethods.register(name, factory, factory == nil)
+}
+
+// RegisterOutputFormat makes an output format available by name. It panics
+// if the name is empty, the factory is nil or the name is already registered.
+func RegisterOutputFormat(name string, factory OutputFormatFactory) {
+	outputFormats.register(name, factory, factory == nil)
+}
+
+// NewInputMethod creates the input method registered under name.
+func NewInputMethod(name string) (InputMethod, error) {
+	factory, err := inputMethods.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return factory(), nil
+}
+
+// NewOutputFormat creates the output format registered under name.
+func NewOutputFormat(name string) (OutputFormat, error) {
+	factory, err := outputFormats.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return factory(), nil
+}
+
+// InputMethods returns the names of the registered input methods, sorted.
+func InputMethods() []string {
+	return inputMethods.names()
+}
+
+// OutputFormats returns the names of the registered output formats, sorted.
+func OutputFormats() []string {
+	return outputFormats.names()
+}
+
+// isModifierKey reports whether char is in the method's modifier-key set.
+func isModifierKey(method InputMethod, char rune) bool {
+	return strings.ContainsRune(method.ModifierKeys(), unicode.ToLower(char))
+}
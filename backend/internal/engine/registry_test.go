@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_BuiltIns(t *testing.T) {
+	for _, name := range []string{"Telex", "VNI", "VIQR"} {
+		if !slices.Contains(InputMethods(), name) {
+			t.Errorf("InputMethods() = %v, missing %s", InputMethods(), name)
+		}
+	}
+	if !slices.Contains(OutputFormats(), "Unicode") {
+		t.Errorf("OutputFormats() = %v, missing Unicode", OutputFormats())
+	}
+
+	method, err := NewInputMethod("vni")
+	if err != nil || method.Name() != "VNI" {
+		t.Errorf("NewInputMethod(vni) = %v, %v; want VNI", method, err)
+	}
+	if _, err := NewInputMethod("Dvorak"); err == nil {
+		t.Error("NewInputMethod(Dvorak) should fail")
+	}
+	if _, err := NewOutputFormat("TCVN3"); err == nil {
+		t.Error("NewOutputFormat(TCVN3) should fail")
+	}
+}
+
+// upperFormat composes like Unicode but in upper case.
+type upperFormat struct{ *UnicodeFormat }
+
+func (upperFormat) Name() string { return "Upper" }
+
+func (f upperFormat) Compose(s *Syllable) string {
+	return strings.ToUpper(f.UnicodeFormat.Compose(s))
+}
+
+func init() {
+	RegisterOutputFormat("Upper", func() OutputFormat { return upperFormat{NewUnicodeFormat()} })
+}
+
+func TestRegistry_PluggedFormat(t *testing.T) {
+	config := DefaultConfig()
+	config.InputMethodName = "VNI"
+	config.OutputFormatName = "upper"
+	e := NewConfiguredEngine(config)
+	typeKeys(e.CompositionEngine, "vie6t5", 0)
+
+	if got := e.GetPreedit(); got != "VIỆT" {
+		t.Errorf("GetPreedit() = %q, want %q", got, "VIỆT")
+	}
+}
+
+func TestRegistry_DuplicatePanics(t *testing.T) {
+	r := newRegistry[OutputFormatFactory]("output format")
+	r.register("Upper", nil, false)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering Upper twice should panic")
+		}
+	}()
+	r.register("UPPER", nil, false)
+}
+
+func TestRegistry_ModifierKeys(t *testing.T) {
+	// Keys outside the modifier set are always letters
+	e := NewCompositionEngine()
+	typeKeys(e, "as1", 0)
+	if got := e.GetPreedit(); got != "á1" {
+		t.Errorf("Telex %q = %q, want %q", "as1", got, "á1")
+	}
+
+	e.SetInputMethod(NewVNIMethod())
+	e.Reset()
+	typeKeys(e, "a1s", 0)
+	if got := e.GetPreedit(); got != "ás" {
+		t.Errorf("VNI %q = %q, want %q", "a1s", got, "ás")
+	}
+}
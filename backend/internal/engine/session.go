@@ -0,0 +1,443 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Session recordings
+//
+// A session file records what a frontend sent to the daemon and what the
+// engine answered, so a word that came out wrong can be replayed against a
+// newer engine. It is JSON Lines: a SessionHeader, then one SessionEvent per
+// line. Keys are written as key scripts (see ParseKeyScript).
+//
+// An anonymized recording keeps the structure of the typing and drops most
+// of the words. Mark and tone keys that changed a word, special keys and
+// modifiers stay; other letters and digits get placeholders that keep the
+// syllable valid, except the ones the word must keep to replay as it was
+// typed (see Anonymizer.Keys). The recorded text shows vowels by their mark
+// and tone only, other letters as b and digits as 0. Word lengths, marks,
+// tones and some letters still show, so an anonymized recording is not a
+// privacy guarantee.
+
+// SessionVersion is the version of the session file format.
+const SessionVersion = 1
+
+// Session operations.
+const (
+	SessionKey      = "key"       // A key event and its result
+	SessionFocusIn  = "focus-in"  // The context gained focus
+	SessionFocusOut = "focus-out" // The context lost focus
+	SessionReset    = "reset"     // The composition was cleared
+	SessionEnable   = "enable"    // The engine was switched to Vietnamese
+	SessionDisable  = "disable"   // The engine was switched to English
+	SessionDestroy  = "destroy"   // The context was destroyed
+)
+
+// SessionHeader is the first line of a session file.
+type SessionHeader struct {
+	Version    int           `json:"version"`
+	Created    time.Time     `json:"created"`
+	Config     *EngineConfig `json:"config"`
+	Anonymized bool          `json:"anonymized,omitempty"`
+}
+
+// SessionEvent is one recorded call. Handled, Commit and Preedit are the
+// result of a key event.
+type SessionEvent struct {
+	Op      string `json:"op"`
+	Context string `json:"ctx,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Handled bool   `json:"handled,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	Preedit string `json:"preedit,omitempty"`
+}
+
+// SessionWriter writes a session file.
+//
+// An anonymizing writer holds the keys of a word back until it ends, when
+// the preedit is empty again or another context or call comes, so that it
+// can check the keys it writes replay as the word was typed (see
+// Anonymizer.Keys). Flush writes the keys held back.
+type SessionWriter struct {
+	enc       *json.Encoder
+	config    *EngineConfig
+	anonymize *Anonymizer
+	word      sessionWord          // Keys held back, when anonymizing
+	modes     map[string]InputMode // Mode by context, or under "" when shared
+	mode      InputMode            // Mode of a context not seen yet
+}
+
+// sessionWord is the keys of a word held back by an anonymizing writer.
+type sessionWord struct {
+	context string
+	mode    InputMode // Mode before the first key
+	keys    []KeyEvent
+	results []ProcessResult
+	edited  []bool // KeyStats.Edited of each key
+}
+
+// NewSessionWriter writes the header of a session typed with config. With
+// anonymize set, the words typed are not recorded.
+func NewSessionWriter(w io.Writer, config *EngineConfig, anonymize bool) (*SessionWriter, error) {
+	s := &SessionWriter{
+		enc:    json.NewEncoder(w),
+		config: config,
+		modes:  make(map[string]InputMode),
+		mode:   NewConfiguredEngine(config).Mode(),
+	}
+	s.enc.SetEscapeHTML(false)
+	if anonymize {
+		s.anonymize = NewAnonymizer(config)
+	}
+
+	header := SessionHeader{
+		Version:    SessionVersion,
+		Created:    time.Now().UTC().Truncate(time.Second),
+		Config:     config,
+		Anonymized: anonymize,
+	}
+	if err := s.enc.Encode(header); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Key records a key event of a context, the engine's result and the stats
+// of the key, which tell the anonymizer whether it changed the word.
+func (s *SessionWriter) Key(context string, event KeyEvent, result ProcessResult, stats KeyStats) error {
+	if s.anonymize == nil {
+		return s.writeKey(context, event, result)
+	}
+
+	w := &s.word
+	if len(w.keys) > 0 && w.context != context {
+		if err := s.Flush(); err != nil {
+			return err
+		}
+	}
+	if len(w.keys) == 0 {
+		w.context, w.mode = context, s.modeOf(context)
+	}
+	w.keys = append(w.keys, event)
+	w.results = append(w.results, result)
+	w.edited = append(w.edited, stats.Edited)
+	s.setMode(context, result.Mode)
+	if result.Preedit == "" {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Event records an operation other than a key event.
+func (s *SessionWriter) Event(op, context string) error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	switch op {
+	case SessionEnable:
+		s.setMode(context, ModeVietnamese)
+	case SessionDisable:
+		s.setMode(context, ModeEnglish)
+	}
+	return s.enc.Encode(SessionEvent{Op: op, Context: context})
+}
+
+// Flush writes the key events an anonymizing writer holds back. Call it
+// before closing the file.
+func (s *SessionWriter) Flush() error {
+	w := &s.word
+	if len(w.keys) == 0 {
+		return nil
+	}
+	keys := s.anonymize.Keys(w.mode, w.keys, w.results, w.edited)
+	var err error
+	for i, key := range keys {
+		result := w.results[i]
+		result.CommitText, result.Preedit = s.anonymize.Text(result.CommitText), s.anonymize.Text(result.Preedit)
+		if err = s.writeKey(w.context, key, result); err != nil {
+			break
+		}
+	}
+	w.keys, w.results, w.edited = w.keys[:0], w.results[:0], w.edited[:0]
+	return err
+}
+
+// writeKey writes a key event and its result.
+func (s *SessionWriter) writeKey(context string, event KeyEvent, result ProcessResult) error {
+	return s.enc.Encode(SessionEvent{
+		Op:      SessionKey,
+		Context: context,
+		Key:     FormatKeyScript([]KeyEvent{event}),
+		Handled: result.Handled,
+		Commit:  result.CommitText,
+		Preedit: result.Preedit,
+	})
+}
+
+// modeOf returns the mode of a context.
+func (s *SessionWriter) modeOf(context string) InputMode {
+	if !s.config.ModePerContext {
+		context = ""
+	}
+	if mode, ok := s.modes[context]; ok {
+		return mode
+	}
+	return s.mode
+}
+
+// setMode notes the mode of a context, shared with every context unless the
+// config keeps it per context.
+func (s *SessionWriter) setMode(context string, mode InputMode) {
+	if !s.config.ModePerContext {
+		context = ""
+	}
+	s.modes[context] = mode
+}
+
+// Anonymizer returns the anonymizer the session was recorded with, or nil
+// when it is not anonymized.
+func (h SessionHeader) Anonymizer() *Anonymizer {
+	if !h.Anonymized {
+		return nil
+	}
+	return NewAnonymizer(h.Config)
+}
+
+// ReadSession reads a session file.
+func ReadSession(r io.Reader) (SessionHeader, []SessionEvent, error) {
+	var header SessionHeader
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return header, nil, err
+		}
+		return header, nil, fmt.Errorf("empty session file")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return header, nil, fmt.Errorf("line 1: %w", err)
+	}
+	if header.Version != SessionVersion {
+		return header, nil, fmt.Errorf("session version %d, want %d", header.Version, SessionVersion)
+	}
+	if header.Config == nil {
+		header.Config = DefaultConfig()
+	}
+
+	var events []SessionEvent
+	for line := 2; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var event SessionEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return header, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if event.Op == SessionKey {
+			if keys, err := ParseKeyScript(event.Key); err != nil || len(keys) != 1 {
+				return header, nil, fmt.Errorf("line %d: bad key %q", line, event.Key)
+			}
+		}
+		events = append(events, event)
+	}
+	return header, events, scanner.Err()
+}
+
+// maxCheckedKeys bounds the keys of a word Anonymizer.Keys checks; each
+// check types the word again, once per letter put back.
+const maxCheckedKeys = 64
+
+// Anonymizer replaces the letters and digits of a session that do not decide
+// how the engine composes a word.
+type Anonymizer struct {
+	config *EngineConfig
+	keep   string // Modifier keys of the input method
+	vowel  rune   // Placeholder for plain vowels, not a modifier key
+}
+
+// NewAnonymizer anonymizes sessions typed with config.
+func NewAnonymizer(config *EngineConfig) *Anonymizer {
+	method, err := NewInputMethod(config.InputMethodName)
+	if err != nil {
+		method = NewTelexMethod()
+	}
+	a := &Anonymizer{config: config, keep: strings.ToLower(method.ModifierKeys()), vowel: 'a'}
+	for _, v := range "aiuy" {
+		if !strings.ContainsRune(a.keep, v) {
+			a.vowel = v
+			break
+		}
+	}
+	return a
+}
+
+// Keys returns the keys of a word with their characters replaced. The word
+// was typed from mode, results are what the engine returned for the keys
+// and edited tells which keys changed the word (KeyStats.Edited).
+//
+// A mark or tone key that changed the word stays, and each other letter
+// gets a placeholder that keeps the syllable valid: b for a consonant
+// before the vowels (th for two), n or t for a nasal or stop after them,
+// and a vowel that is no key of the input method for a vowel. The keys are
+// then typed into a scratch engine, and while a result differs from the
+// recorded one as Text shows it, the last replaced key at or before it is
+// put back, so that the keys replay the word as it was typed. A word
+// therefore keeps the letters its marks act on, such as the first o of oo.
+// Words longer than maxCheckedKeys are not checked.
+func (a *Anonymizer) Keys(mode InputMode, keys []KeyEvent, results []ProcessResult, edited []bool) []KeyEvent {
+	anon := a.placeholders(keys, edited)
+	if len(keys) > maxCheckedKeys {
+		return anon
+	}
+	for from := 0; ; {
+		i := a.differs(mode, anon, results, from)
+		if i < 0 {
+			return anon
+		}
+		k := i
+		for k >= 0 && anon[k] == keys[k] {
+			k--
+		}
+		if k < 0 {
+			// No replaced key before it: the key differs on its own
+			from = i + 1
+			continue
+		}
+		anon[k] = keys[k]
+	}
+}
+
+// placeholders returns keys with every letter that did not change the word
+// replaced by a placeholder.
+func (a *Anonymizer) placeholders(keys []KeyEvent, edited []bool) []KeyEvent {
+	anon := slices.Clone(keys)
+	vowels := false // A vowel was typed before the key
+	for i, key := range keys {
+		r := KeysymToRune(key.KeySym)
+		if r == 0 || key.KeySym == KeySpace {
+			continue
+		}
+		lower := unicode.ToLower(r)
+		var placeholder rune
+		switch {
+		case key.Modifiers&(ModRelease|ModControl|ModMod1|ModMod4) != 0:
+			placeholder = a.rune(r)
+		case edited[i] && strings.ContainsRune(a.keep, lower):
+			continue
+		case IsVietnameseVowel(lower):
+			placeholder, vowels = a.vowel, true
+		case !unicode.IsLetter(r):
+			placeholder = a.rune(r)
+		case vowels:
+			placeholder = codaPlaceholder(lower)
+		default:
+			placeholder = onsetPlaceholder(keys, i)
+		}
+		if unicode.IsUpper(r) {
+			placeholder = unicode.ToUpper(placeholder)
+		}
+		if placeholder != r {
+			anon[i].KeySym = RuneToKeysym(placeholder)
+		}
+	}
+	return anon
+}
+
+// onsetPlaceholder returns the placeholder of the consonant keys[i] typed
+// before the vowels: b, or t and h for an onset of two letters.
+func onsetPlaceholder(keys []KeyEvent, i int) rune {
+	isConsonant := func(j int) bool {
+		if j < 0 || j >= len(keys) || keys[j].Modifiers&(ModRelease|ModControl|ModMod1|ModMod4) != 0 {
+			return false
+		}
+		r := KeysymToRune(keys[j].KeySym)
+		return unicode.IsLetter(r) && !IsVietnameseVowel(unicode.ToLower(r))
+	}
+	switch {
+	case isConsonant(i+1) && !isConsonant(i-1) && !isConsonant(i+2):
+		return 't'
+	case isConsonant(i-1) && !isConsonant(i-2) && !isConsonant(i+1):
+		return 'h'
+	}
+	return 'b'
+}
+
+// codaPlaceholder returns the placeholder of a consonant typed after the
+// vowels: n for a nasal, t for a stop, g and h as they are.
+func codaPlaceholder(r rune) rune {
+	switch r {
+	case 'm', 'n':
+		return 'n'
+	case 'c', 'p', 't':
+		return 't'
+	case 'g', 'h':
+		return r
+	}
+	return 'b'
+}
+
+// differs types keys into a scratch engine from mode and returns the first
+// key from on whose result differs from results, or -1 when none does.
+func (a *Anonymizer) differs(mode InputMode, keys []KeyEvent, results []ProcessResult, from int) int {
+	scratch := NewConfiguredEngine(a.config)
+	scratch.SetEnabled(mode == ModeVietnamese)
+	for i, key := range keys {
+		got := scratch.ProcessKey(key)
+		want := results[i]
+		if i >= from && (got.Handled != want.Handled || got.Mode != want.Mode ||
+			a.Text(got.CommitText) != a.Text(want.CommitText) || a.Text(got.Preedit) != a.Text(want.Preedit)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Text returns text with its characters replaced. A vowel becomes the
+// placeholder of its mark, keeping its tone: â, ê and ô become â, ơ and ư
+// become ơ, ă stays and plain vowels become the placeholder of keys. đ
+// stays, as the stroke a key put on it.
+func (a *Anonymizer) Text(text string) string {
+	return strings.Map(a.rune, text)
+}
+
+// rune replaces one character of a text.
+func (a *Anonymizer) rune(r rune) rune {
+	base, tone := GetBaseVowel(r)
+	lower := unicode.ToLower(base)
+	if IsVietnameseVowel(lower) {
+		var anon rune
+		switch lower {
+		case 'â', 'ê', 'ô':
+			anon = 'â'
+		case 'ơ', 'ư':
+			anon = 'ơ'
+		case 'ă':
+			anon = 'ă'
+		default:
+			anon = a.vowel
+		}
+		if unicode.IsUpper(base) {
+			anon = unicode.ToUpper(anon)
+		}
+		return toneVowel(anon, tone)
+	}
+	switch {
+	case lower == 'đ':
+		return r
+	case unicode.IsDigit(r):
+		return '0'
+	case unicode.IsUpper(r):
+		return 'B'
+	case unicode.IsLetter(r):
+		return 'b'
+	}
+	return r
+}
@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// recordKeys types a key script into a new engine and records it.
+func recordKeys(t *testing.T, script string, anonymize bool) string {
+	t.Helper()
+	return recordSession(t, DefaultConfig(), script, anonymize)
+}
+
+// recordSession types a key script into a new engine with config and
+// records it.
+func recordSession(t *testing.T, config *EngineConfig, script string, anonymize bool) string {
+	t.Helper()
+	engine := NewConfiguredEngine(config)
+	var buf bytes.Buffer
+	w, err := NewSessionWriter(&buf, config, anonymize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ParseKeyScript(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if err := w.Key("editor", key, engine.ProcessKey(key), engine.LastKeyStats()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Event(SessionFocusOut, "editor"); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestSession_RoundTrip(t *testing.T) {
+	data := recordKeys(t, "<S-t>ieengs<Space>", false)
+	header, events, err := ReadSession(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != SessionVersion || header.Anonymized || header.Config.InputMethodName != "Telex" {
+		t.Errorf("header = %+v", header)
+	}
+	if len(events) != 9 {
+		t.Fatalf("got %d events, want 9", len(events))
+	}
+	first := events[0]
+	if first.Op != SessionKey || first.Context != "editor" || first.Key != "<S-t>" || first.Preedit != "T" {
+		t.Errorf("first event = %+v", first)
+	}
+	if space := events[7]; space.Commit != "Tiếng " || !space.Handled {
+		t.Errorf("space event = %+v", space)
+	}
+	if last := events[8]; last.Op != SessionFocusOut || last.Key != "" {
+		t.Errorf("last event = %+v", last)
+	}
+}
+
+func TestSession_Anonymized(t *testing.T) {
+	data := recordKeys(t, "<S-t>ieengs<Space>so<Space>mk2<C-c>", true)
+	if strings.Contains(data, "Tiếng") || strings.Contains(data, `"mk2"`) {
+		t.Fatalf("anonymized session contains the words:\n%s", data)
+	}
+	header, events, err := ReadSession(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !header.Anonymized {
+		t.Error("header should say the session is anonymized")
+	}
+
+	var keys, commits []string
+	for _, e := range events {
+		if e.Op == SessionKey {
+			keys = append(keys, e.Key)
+		}
+		if e.Commit != "" {
+			commits = append(commits, e.Commit)
+		}
+	}
+	// The s that changed the word, the e the second e acts on, special keys
+	// and modifiers stay; vowels become i, which is not a Telex key, the s
+	// of so is a letter and the other letters keep the syllables valid
+	want := []string{"<S-b>", "i", "e", "e", "n", "g", "s", "<Space>", "b", "i", "<Space>", "t", "h", "0", "<C-b>"}
+	if strings.Join(keys, " ") != strings.Join(want, " ") {
+		t.Errorf("keys = %v, want %v", keys, want)
+	}
+	if strings.Join(commits, "|") != "Biấbb |bi |bb0" {
+		t.Errorf("commits = %q", commits)
+	}
+}
+
+func TestSession_AnonymizedReplays(t *testing.T) {
+	vni := DefaultConfig()
+	vni.InputMethodName = "VNI"
+	tests := []struct {
+		config *EngineConfig
+		script string
+	}{
+		{DefaultConfig(), "tooi<Space>vieetj<Space>ddi<Space>nguwowif<Space><S-t>ieengs<Space>quas<Space>gias<Space><BS>xin<Space>chaof"},
+		{vni, "to6i<Space>vie65t<Space>d9i<Space>ngu7o72i<Space><S-t>ie61ng<Space>qua1<Space>gia1<Space><BS>xin<Space>chao2"},
+	}
+	for _, tt := range tests {
+		data := recordSession(t, tt.config, tt.script, true)
+		header, events, err := ReadSession(strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Typed again, the anonymized keys give the recorded results
+		a, engine := header.Anonymizer(), NewConfiguredEngine(header.Config)
+		var keys []string
+		differ := 0
+		for _, event := range events {
+			if event.Op != SessionKey {
+				continue
+			}
+			keys = append(keys, event.Key)
+			key, _ := ParseKeyScript(event.Key)
+			got := engine.ProcessKey(key[0])
+			if got.Handled != event.Handled || a.Text(got.CommitText) != event.Commit || a.Text(got.Preedit) != event.Preedit {
+				t.Errorf("%s: key %s gives %q %q, recorded %q %q", header.Config.InputMethodName,
+					event.Key, a.Text(got.CommitText), a.Text(got.Preedit), event.Commit, event.Preedit)
+				differ++
+			}
+		}
+		if differ > 0 {
+			t.Errorf("%s: %d keys differ: %s", header.Config.InputMethodName, differ, strings.Join(keys, ""))
+		}
+		if typed := strings.Join(keys, ""); strings.Contains(typed, "to") || strings.Contains(typed, "chao") {
+			t.Errorf("%s: keys keep the words: %s", header.Config.InputMethodName, typed)
+		}
+	}
+}
+
+func TestAnonymizer_Text(t *testing.T) {
+	a := NewAnonymizer(DefaultConfig())
+	if got, want := a.Text("Trường Đắk Lắk ÂU, 2024"), "Bbơờbb Đắb Bắb ÂI, 0000"; got != want {
+		t.Errorf("Text = %q, want %q", got, want)
+	}
+	vni := DefaultConfig()
+	vni.InputMethodName = "VNI"
+	if got := NewAnonymizer(vni).Text("hoa"); got != "baa" {
+		t.Errorf("VNI Text = %q, want %q", got, "baa")
+	}
+}
+
+func TestReadSession_Errors(t *testing.T) {
+	tests := []string{
+		"",
+		"not json",
+		`{"version": 99}`,
+		`{"version": 1}` + "\n" + `{"op": "key", "key": "<Nope>"}`,
+		`{"version": 1}` + "\n" + `{"op": "key", "key": "ab"}`,
+	}
+	for _, input := range tests {
+		if _, _, err := ReadSession(strings.NewReader(input)); err == nil {
+			t.Errorf("ReadSession(%q) succeeded", input)
+		}
+	}
+}
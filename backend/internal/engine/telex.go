@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"strings"
 	"unicode"
 )
 
@@ -12,11 +13,20 @@ func NewTelexMethod() *TelexMethod {
 	return &TelexMethod{}
 }
 
+func init() {
+	RegisterInputMethod("Telex", func() InputMethod { return NewTelexMethod() })
+}
+
 // Name returns the method name.
 func (t *TelexMethod) Name() string {
 	return "Telex"
 }
 
+// ModifierKeys returns the tone keys, w and the doubled letters.
+func (t *TelexMethod) ModifierKeys() string {
+	return "sfrxjzwaeod"
+}
+
 // Telex tone key mappings
 var telexToneKeys = map[rune]ToneMark{
 	's': ToneSac,   // á
@@ -61,15 +71,9 @@ var telexDoublePatterns = map[string]struct {
 	"dD": {result: 'đ', mark: VowelDBar},
 }
 
-// Horn patterns with 'w'
-var telexHornPatterns = map[rune]rune{
-	'o': 'ơ',
-	'O': 'Ơ',
-	'u': 'ư',
-	'U': 'Ư',
-	'a': 'ă',
-	'A': 'Ă',
-}
+// telexWTargets are the vowels a 'w' modifier can change: horn on o and u,
+// breve on a.
+var telexWTargets = MarkTargets{'a': VowelBreve, 'o': VowelHorn, 'u': VowelHorn}
 
 // IsToneKey checks if the character is a Telex tone key.
 func (t *TelexMethod) IsToneKey(char rune) bool {
@@ -103,57 +107,48 @@ func (t *TelexMethod) GetVowelMark(char rune) VowelMark {
 	}
 }
 
-// ProcessChar processes a character according to Telex rules.
-func (t *TelexMethod) ProcessChar(char rune, current *Syllable) (string, ToneMark, VowelMark, bool) {
+// ProcessChar returns the edit a key makes according to Telex rules.
+func (t *TelexMethod) ProcessChar(char rune, current *Syllable) Edit {
 	if current == nil {
-		return string(char), ToneNone, VowelNone, false
+		return Edit{}
 	}
 
 	lower := unicode.ToLower(char)
+	nucleus := []rune(current.Nucleus)
 
 	// Check for tone keys
-	if t.IsToneKey(char) {
-		tone := t.GetToneMark(char)
-		// Only apply tone if we have a vowel
-		if current.Nucleus != "" {
-			return "", tone, VowelNone, true
-		}
+	if t.IsToneKey(char) && len(nucleus) > 0 {
+		return Edit{Kind: EditTone, Tone: t.GetToneMark(char)}
 	}
 
-	// Check for 'w' vowel modifier (ư, ơ, ă)
+	// 'w' marks the nucleus (ư, ơ, ă) or stands for ư on its own
 	if lower == 'w' {
-		// 'w' can modify the previous vowel
-		if current.Nucleus != "" {
-			nucleus := []rune(current.Nucleus)
-			lastVowel := nucleus[len(nucleus)-1]
-			if result, ok := telexHornPatterns[lastVowel]; ok {
-				return string(result), ToneNone, VowelHorn, true
-			}
+		if hasMarkTarget(nucleus, telexWTargets) {
+			return Edit{Kind: EditMark, Vowel: -1, Targets: telexWTargets}
 		}
-		// 'uw' for 'ư' at the beginning
-		if current.Raw != "" && len(current.Raw) > 0 {
-			lastRaw := []rune(current.Raw)
-			last := lastRaw[len(lastRaw)-1]
-			if result, ok := telexHornPatterns[last]; ok {
-				return string(result), ToneNone, VowelHorn, true
-			}
+		if len(nucleus) == 0 {
+			return Edit{Kind: EditInsertVowel, Char: 'u', Mark: VowelHorn}
 		}
+		return Edit{}
 	}
 
-	// Check for double-letter patterns (aa -> â, etc.)
-	if current.Raw != "" {
-		rawRunes := []rune(current.Raw)
-		if len(rawRunes) > 0 {
-			lastRaw := rawRunes[len(rawRunes)-1]
-			pattern := string(lastRaw) + string(char)
-			if p, ok := telexDoublePatterns[pattern]; ok {
-				return string(p.result), ToneNone, p.mark, true
-			}
+	// Double letters: aa, ee, oo mark the vowel just typed, dd strokes the d
+	if unicode.ToLower(current.LastKey) != lower {
+		return Edit{}
+	}
+	if p, ok := telexDoublePatterns[string(current.LastKey)+string(char)]; ok {
+		n := len(nucleus)
+		switch {
+		case p.mark == VowelDBar && n == 0 && strings.ContainsAny(current.Onset, "dD"):
+			return Edit{Kind: EditStroke}
+		case p.mark == VowelHat && n > 0 && current.Coda == "" &&
+			unicode.ToLower(nucleus[n-1]) == lower && markAt(current.Marks, n-1) == VowelNone:
+			return Edit{Kind: EditMark, Mark: VowelHat, Vowel: n - 1}
 		}
 	}
 
 	// Regular character - just pass through
-	return string(char), ToneNone, VowelNone, false
+	return Edit{}
 }
 
 // CanStartWord checks if a character can start a Vietnamese word.
@@ -952,11 +952,11 @@ func TestTelexEdgeCases_ComplexConsonants(t *testing.T) {
 		{"khai", "khai", "kh onset"},
 		{"khais", "khái", "khais -> khái"},
 		{"gia", "gia", "gi onset (semivowel)"},
-		// Note: gi + a + tone - tone goes on i (first vowel after onset parse)
-		{"gias", "gía", "gias -> gía (tone on i)"},
+		// The i of gi belongs to the onset, so the tone goes on a
+		{"gias", "giá", "gias -> giá (tone on a)"},
 		{"qua", "qua", "qu onset"},
-		// Note: qu + a + tone - tone goes on u (first vowel)
-		{"quas", "qúa", "quas -> qúa (tone on u)"},
+		// The u of qu belongs to the onset, so the tone goes on a
+		{"quas", "quá", "quas -> quá (tone on a)"},
 
 		// Final consonant clusters
 		{"anh", "anh", "nh coda"},
@@ -1258,13 +1258,11 @@ func TestTelexEdgeCases_SpecialPatterns(t *testing.T) {
 		{"gi", "gi", "gi"},
 		{"gis", "gí", "gis -> gí"},
 		{"gia", "gia", "gia"},
-		// Note: gias tone on i (first vowel after onset)
-		{"gias", "gía", "gias -> gía (tone on i)"},
+		{"gias", "giá", "gias -> giá (i belongs to gi, tone on a)"},
 
 		// QU special case
 		{"que", "que", "que"},
-		// Note: ques tone on u (first vowel)
-		{"ques", "qúe", "ques -> qúe (tone on u)"},
+		{"ques", "qué", "ques -> qué (u belongs to qu, tone on e)"},
 		{"quoc", "quôc", "quoc -> quôc"},
 		{"quocs", "quốc", "quocs -> quốc"},
 
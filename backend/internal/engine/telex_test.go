@@ -90,7 +90,7 @@ func TestTelexMethod_ProcessChar_ToneKeys(t *testing.T) {
 
 	// Create a syllable with a vowel
 	syllable := &Syllable{
-		Raw:     "a",
+		LastKey: 'a',
 		Nucleus: "a",
 	}
 
@@ -98,26 +98,30 @@ func TestTelexMethod_ProcessChar_ToneKeys(t *testing.T) {
 		name         string
 		char         rune
 		expectedTone ToneMark
-		consumed     bool
 	}{
-		{"s applies sac", 's', ToneSac, true},
-		{"f applies huyen", 'f', ToneHuyen, true},
-		{"r applies hoi", 'r', ToneHoi, true},
-		{"x applies nga", 'x', ToneNga, true},
-		{"j applies nang", 'j', ToneNang, true},
+		{"s applies sac", 's', ToneSac},
+		{"f applies huyen", 'f', ToneHuyen},
+		{"r applies hoi", 'r', ToneHoi},
+		{"x applies nga", 'x', ToneNga},
+		{"j applies nang", 'j', ToneNang},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, tone, _, consumed := telex.ProcessChar(tt.char, syllable)
-			if consumed != tt.consumed {
-				t.Errorf("ProcessChar(%c) consumed = %v, want %v", tt.char, consumed, tt.consumed)
+			edit := telex.ProcessChar(tt.char, syllable)
+			if edit.Kind != EditTone {
+				t.Errorf("ProcessChar(%c) kind = %v, want %v", tt.char, edit.Kind, EditTone)
 			}
-			if tone != tt.expectedTone {
-				t.Errorf("ProcessChar(%c) tone = %v, want %v", tt.char, tone, tt.expectedTone)
+			if edit.Tone != tt.expectedTone {
+				t.Errorf("ProcessChar(%c) tone = %v, want %v", tt.char, edit.Tone, tt.expectedTone)
 			}
 		})
 	}
+
+	// Without a vowel a tone key is a letter
+	if edit := telex.ProcessChar('s', &Syllable{LastKey: 'b', Onset: "b"}); edit.Kind != EditLetter {
+		t.Errorf("ProcessChar('s') without vowel kind = %v, want %v", edit.Kind, EditLetter)
+	}
 }
 
 func TestTelexMethod_ProcessChar_DoubleLetters(t *testing.T) {
@@ -125,30 +129,25 @@ func TestTelexMethod_ProcessChar_DoubleLetters(t *testing.T) {
 
 	tests := []struct {
 		name     string
-		raw      string
+		syllable Syllable
 		char     rune
-		expected string
-		consumed bool
+		expected Edit
 	}{
-		{"aa -> â", "a", 'a', "â", true},
-		{"AA -> Â", "A", 'A', "Â", true},
-		{"ee -> ê", "e", 'e', "ê", true},
-		{"oo -> ô", "o", 'o', "ô", true},
-		{"dd -> đ", "d", 'd', "đ", true},
-		{"ab not consumed", "a", 'b', "b", false}, // not a double pattern
+		{"aa -> â", Syllable{LastKey: 'a', Nucleus: "a"}, 'a', Edit{Kind: EditMark, Mark: VowelHat, Vowel: 0}},
+		{"AA -> Â", Syllable{LastKey: 'A', Nucleus: "A"}, 'A', Edit{Kind: EditMark, Mark: VowelHat, Vowel: 0}},
+		{"ee -> ê", Syllable{LastKey: 'e', Onset: "t", Nucleus: "ie"}, 'e', Edit{Kind: EditMark, Mark: VowelHat, Vowel: 1}},
+		{"oo -> ô", Syllable{LastKey: 'o', Nucleus: "o"}, 'o', Edit{Kind: EditMark, Mark: VowelHat, Vowel: 0}},
+		{"dd -> đ", Syllable{LastKey: 'd', Onset: "d"}, 'd', Edit{Kind: EditStroke}},
+		{"ab is a letter", Syllable{LastKey: 'a', Nucleus: "a"}, 'b', Edit{}},
+		{"aa after coda is a letter", Syllable{LastKey: 'a', Nucleus: "a", Coda: "n"}, 'a', Edit{}},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			syllable := &Syllable{Raw: tt.raw}
-			result, _, _, consumed := telex.ProcessChar(tt.char, syllable)
-			if consumed != tt.consumed {
-				t.Errorf("ProcessChar(%c) after %s: consumed = %v, want %v",
-					tt.char, tt.raw, consumed, tt.consumed)
-			}
-			if consumed && result != tt.expected {
-				t.Errorf("ProcessChar(%c) after %s = %s, want %s",
-					tt.char, tt.raw, result, tt.expected)
+			edit := telex.ProcessChar(tt.char, &tt.syllable)
+			if edit.Kind != tt.expected.Kind || edit.Mark != tt.expected.Mark || edit.Vowel != tt.expected.Vowel {
+				t.Errorf("ProcessChar(%c) after %c = %+v, want %+v",
+					tt.char, tt.syllable.LastKey, edit, tt.expected)
 			}
 		})
 	}
@@ -158,31 +157,29 @@ func TestTelexMethod_ProcessChar_HornWithW(t *testing.T) {
 	telex := NewTelexMethod()
 
 	tests := []struct {
-		name     string
-		nucleus  string
-		expected string
-		consumed bool
+		name    string
+		nucleus string
+		kind    EditKind
 	}{
-		{"ow -> ơ", "o", "ơ", true},
-		{"uw -> ư", "u", "ư", true},
-		{"aw -> ă", "a", "ă", true},
-		{"Ow -> Ơ", "O", "Ơ", true},
+		{"ow marks the nucleus", "o", EditMark},
+		{"uw marks the nucleus", "u", EditMark},
+		{"aw marks the nucleus", "a", EditMark},
+		{"Ow marks the nucleus", "O", EditMark},
+		{"w alone is ư", "", EditInsertVowel},
+		{"iw is a letter", "i", EditLetter},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			syllable := &Syllable{
-				Raw:     tt.nucleus,
-				Nucleus: tt.nucleus,
-			}
-			result, _, _, consumed := telex.ProcessChar('w', syllable)
-			if consumed != tt.consumed {
-				t.Errorf("ProcessChar('w') with nucleus %s: consumed = %v, want %v",
-					tt.nucleus, consumed, tt.consumed)
+			syllable := &Syllable{Nucleus: tt.nucleus}
+			edit := telex.ProcessChar('w', syllable)
+			if edit.Kind != tt.kind {
+				t.Errorf("ProcessChar('w') with nucleus %q: kind = %v, want %v",
+					tt.nucleus, edit.Kind, tt.kind)
 			}
-			if consumed && result != tt.expected {
-				t.Errorf("ProcessChar('w') with nucleus %s = %s, want %s",
-					tt.nucleus, result, tt.expected)
+			if edit.Kind == EditMark && edit.Vowel != -1 {
+				t.Errorf("ProcessChar('w') with nucleus %q: vowel = %d, want -1 (grammar picks)",
+					tt.nucleus, edit.Vowel)
 			}
 		})
 	}
@@ -0,0 +1,165 @@
+package engine
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tone placement
+//
+// Which vowel of a syllable carries the tone depends only on its rhyme: the
+// written vowel cluster and whether a consonant coda follows. rhymeTones
+// lists every cluster of the grammar table (vowelClusters) with the tone
+// position for open and closed syllables under both rules. The rules only
+// disagree on open oa, oe and uy: the old rule writes hòa, khỏe, thủy and the
+// new rule hoà, khoẻ, thuỷ.
+
+// tonePos is the index of the tone vowel under the old and new rules.
+type tonePos struct {
+	old, new int8
+}
+
+// rhymeTone gives the tone position of a vowel cluster without and with a
+// consonant coda; noTone marks a form the cluster does not have.
+type rhymeTone struct {
+	open, closed tonePos
+}
+
+var noTone = tonePos{-1, -1}
+
+// both is a position the two rules agree on.
+func both(i int8) tonePos { return tonePos{i, i} }
+
+// rhymeTones is the tone position table, keyed like vowelClusters.
+var rhymeTones = map[string]rhymeTone{
+	// Single vowels
+	"a": {both(0), both(0)}, "ă": {noTone, both(0)}, "â": {noTone, both(0)},
+	"e": {both(0), both(0)}, "ê": {both(0), both(0)},
+	"i": {both(0), both(0)}, "y": {both(0), noTone},
+	"o": {both(0), both(0)}, "ô": {both(0), both(0)}, "ơ": {both(0), both(0)},
+	"u": {both(0), both(0)}, "ư": {both(0), both(0)},
+
+	// Falling diphthongs: the first vowel is the main one (mái, cửa, nghĩa)
+	"ai": {both(0), noTone}, "ao": {both(0), noTone}, "au": {both(0), noTone},
+	"ay": {both(0), noTone}, "âu": {both(0), noTone}, "ây": {both(0), noTone},
+	"eo": {both(0), noTone}, "êu": {both(0), noTone},
+	"ia": {both(0), noTone}, "iu": {both(0), noTone},
+	"oi": {both(0), noTone}, "ôi": {both(0), noTone}, "ơi": {both(0), noTone},
+	"ua": {both(0), noTone}, "ui": {both(0), noTone},
+	"ưa": {both(0), noTone}, "ưi": {both(0), noTone}, "ưu": {both(0), noTone},
+
+	// Rising diphthongs: the second vowel is the main one, except that the
+	// old rule puts the tone on the first vowel of open oa, oe and uy
+	"oa": {tonePos{0, 1}, both(1)}, "oe": {tonePos{0, 1}, both(1)},
+	"uy": {tonePos{0, 1}, both(1)},
+	"oă": {noTone, both(1)}, "oo": {noTone, both(1)},
+	"uă": {noTone, both(1)}, "uâ": {noTone, both(1)},
+	"ue": {both(1), both(1)}, "uê": {both(1), both(1)},
+	"uơ": {both(1), noTone},
+	"iê": {noTone, both(1)}, "yê": {noTone, both(1)},
+	"uô": {noTone, both(1)}, "ươ": {noTone, both(1)},
+
+	// Triphthongs: the middle vowel, or ê in uyê
+	"iêu": {both(1), noTone}, "yêu": {both(1), noTone},
+	"oai": {both(1), noTone}, "oay": {both(1), noTone}, "oeo": {both(1), noTone},
+	"uây": {both(1), noTone}, "uôi": {both(1), noTone},
+	"uya": {both(1), noTone}, "uyu": {both(1), noTone},
+	"ươi": {both(1), noTone}, "ươu": {both(1), noTone},
+	"uyê": {noTone, both(2)},
+}
+
+// TonePlacement decides which vowel of a syllable carries the tone. The
+// zero value follows the old rule.
+type TonePlacement struct {
+	Rule ToneRule
+
+	// Overrides maps a rhyme (vowel cluster and coda, lower case with vowel
+	// marks and no tone, e.g. "oa" or "uynh") to the index of the vowel that
+	// takes the tone, replacing the table entry.
+	Overrides map[string]int
+}
+
+// Position returns the index in nucleus of the vowel that carries the tone.
+// nucleus is the written cluster with vowel marks, in any case. The u of qu
+// and the i of gi belong to the onset and never take the tone (quá, giữa).
+func (p TonePlacement) Position(onset string, nucleus []rune, coda string) int {
+	if len(nucleus) <= 1 {
+		return 0
+	}
+	if onsetGlideVowel(onset, nucleus[0]) {
+		return 1 + p.Position("", nucleus[1:], coda)
+	}
+
+	var buf [4 * maxClusterVowels]byte
+	cluster := buf[:0]
+	for _, r := range nucleus {
+		cluster = utf8.AppendRune(cluster, unicode.ToLower(r))
+	}
+
+	if pos, ok := p.lookup(cluster, coda); ok {
+		return min(pos, len(nucleus)-1)
+	}
+	return fallbackTonePosition(nucleus)
+}
+
+// lookup finds the tone position of a lower-case cluster in the overrides
+// and then the table.
+func (p TonePlacement) lookup(cluster []byte, coda string) (int, bool) {
+	if len(p.Overrides) > 0 {
+		var buf [4*maxClusterVowels + 2]byte
+		rhyme := lowerInto(append(buf[:0], cluster...), coda)
+		if pos, ok := p.Overrides[string(rhyme)]; ok && pos >= 0 {
+			return pos, true
+		}
+	}
+
+	entry, ok := rhymeTones[string(cluster)]
+	if !ok {
+		return 0, false
+	}
+	pos := entry.open
+	if coda != "" {
+		pos = entry.closed
+	}
+	if pos == noTone {
+		// Not a Vietnamese rhyme (ươ without a coda): use the other form
+		pos = entry.open
+		if pos == noTone {
+			pos = entry.closed
+		}
+	}
+	if p.Rule == ToneRuleNew {
+		return int(pos.new), true
+	}
+	return int(pos.old), true
+}
+
+// onsetGlideVowel reports whether the first nucleus vowel completes the
+// onset qu or gi.
+func onsetGlideVowel(onset string, first rune) bool {
+	if len(onset) != 1 {
+		return false
+	}
+	switch unicode.ToLower(first) {
+	case 'u':
+		return onset == "q" || onset == "Q"
+	case 'i':
+		return onset == "g" || onset == "G"
+	}
+	return false
+}
+
+// fallbackTonePosition places the tone on clusters that are not Vietnamese:
+// on the last vowel with a mark, otherwise on the middle of three or more
+// vowels, otherwise on the first.
+func fallbackTonePosition(nucleus []rune) int {
+	for i := len(nucleus) - 1; i >= 0; i-- {
+		if isMarkedVowel(nucleus[i]) {
+			return i
+		}
+	}
+	if len(nucleus) >= 3 {
+		return 1
+	}
+	return 0
+}
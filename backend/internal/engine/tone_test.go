@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRhymeTones_CoverGrammar(t *testing.T) {
+	for cluster, rule := range vowelClusters {
+		entry, ok := rhymeTones[cluster]
+		if !ok {
+			t.Errorf("%q: missing from rhymeTones", cluster)
+			continue
+		}
+		n := int8(utf8.RuneCountInString(cluster))
+		check := func(form string, pos tonePos) {
+			if pos == noTone {
+				t.Errorf("%q %s: no tone position", cluster, form)
+				return
+			}
+			if pos.old < 0 || pos.old >= n || pos.new < 0 || pos.new >= n {
+				t.Errorf("%q %s: position %v out of range", cluster, form, pos)
+			}
+		}
+		if rule.open {
+			check("open", entry.open)
+		}
+		if rule.codas != 0 {
+			check("closed", entry.closed)
+		}
+	}
+	for cluster := range rhymeTones {
+		if _, ok := vowelClusters[cluster]; !ok {
+			t.Errorf("%q: not in vowelClusters", cluster)
+		}
+	}
+}
+
+func TestRhymeTones_Compose(t *testing.T) {
+	// Compose every rhyme of the grammar table with a tone under both rules
+	// and check that the tone lands where the table says
+	format := NewUnicodeFormat()
+	for cluster, rule := range vowelClusters {
+		onset := ""
+		if rule.onsets != nil {
+			onset = rule.onsets[0]
+		}
+		var codas []string
+		if rule.open {
+			codas = append(codas, "")
+		}
+		for coda, bit := range codaBits {
+			if rule.codas&bit != 0 {
+				codas = append(codas, coda)
+			}
+		}
+
+		entry := rhymeTones[cluster]
+		for _, coda := range codas {
+			pos := entry.open
+			if coda != "" {
+				pos = entry.closed
+			}
+			for _, toneRule := range []ToneRule{ToneRuleOld, ToneRuleNew} {
+				want := int(pos.old)
+				if toneRule == ToneRuleNew {
+					want = int(pos.new)
+				}
+
+				s := Syllable{Onset: onset, Coda: coda, ToneMark: ToneSac}
+				s.SetNucleus(cluster)
+				s.Tones.Rule = toneRule
+				got := []rune(format.Compose(&s))
+				vowels := got[utf8.RuneCountInString(onset):]
+				for i, r := range []rune(cluster) {
+					if toned := i == want; toned != (vowels[i] != r) {
+						t.Errorf("%s%s%s rule %d: got %q, want the tone on vowel %d",
+							onset, cluster, coda, toneRule, string(got), want)
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestTonePlacement_MarkedVowel(t *testing.T) {
+	// When a cluster has marked vowels the last of them takes the tone
+	for cluster, entry := range rhymeTones {
+		marked := -1
+		for i, r := range []rune(cluster) {
+			if isMarkedVowel(r) {
+				marked = i
+			}
+		}
+		if marked < 0 {
+			continue
+		}
+		for _, pos := range []tonePos{entry.open, entry.closed} {
+			if pos != noTone && (int(pos.old) != marked || int(pos.new) != marked) {
+				t.Errorf("%q: tone at %v, marked vowel at %d", cluster, pos, marked)
+			}
+		}
+	}
+}
+
+func TestTonePlacement_Words(t *testing.T) {
+	tests := []struct {
+		onset, nucleus, coda string
+		tone                 ToneMark
+		old, new             string
+	}{
+		{"h", "oa", "", ToneHuyen, "hòa", "hoà"},
+		{"h", "oa", "n", ToneHuyen, "hoàn", "hoàn"},
+		{"kh", "oe", "", ToneHoi, "khỏe", "khoẻ"},
+		{"th", "uy", "", ToneHoi, "thủy", "thuỷ"},
+		{"th", "uy", "nh", ToneHoi, "thuỷnh", "thuỷnh"},
+		{"c", "ua", "", ToneHoi, "của", "của"},
+		{"m", "ua", "", ToneHuyen, "mùa", "mùa"},
+		{"ngh", "ia", "", ToneNga, "nghĩa", "nghĩa"},
+		{"c", "ưa", "", ToneHoi, "cửa", "cửa"},
+		{"ng", "ươi", "", ToneHuyen, "người", "người"},
+		{"kh", "uyu", "", ToneHoi, "khuỷu", "khuỷu"},
+		{"ng", "uyê", "n", ToneSac, "nguyến", "nguyến"},
+		{"t", "iêu", "", ToneSac, "tiếu", "tiếu"},
+		{"x", "oo", "ng", ToneHuyen, "xoòng", "xoòng"},
+		{"h", "oai", "", ToneSac, "hoái", "hoái"},
+		{"th", "uơ", "", ToneHoi, "thuở", "thuở"},
+		{"q", "ua", "", ToneSac, "quá", "quá"},
+		{"q", "uy", "", ToneSac, "quý", "quý"},
+		{"q", "uyê", "n", ToneHoi, "quyển", "quyển"},
+		{"g", "ia", "", ToneSac, "giá", "giá"},
+		{"g", "iưa", "", ToneNga, "giữa", "giữa"},
+		{"g", "i", "", ToneHuyen, "gì", "gì"},
+	}
+
+	format := NewUnicodeFormat()
+	for _, tt := range tests {
+		t.Run(tt.old, func(t *testing.T) {
+			for _, rule := range []ToneRule{ToneRuleOld, ToneRuleNew} {
+				s := Syllable{Onset: tt.onset, Coda: tt.coda, ToneMark: tt.tone}
+				s.SetNucleus(tt.nucleus)
+				s.Tones.Rule = rule
+
+				want := tt.old
+				if rule == ToneRuleNew {
+					want = tt.new
+				}
+				if got := format.Compose(&s); got != want {
+					t.Errorf("rule %d: got %q, want %q", rule, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTonePlacement_Overrides(t *testing.T) {
+	placement := TonePlacement{Rule: ToneRuleNew, Overrides: map[string]int{"oa": 0, "uynh": 0}}
+
+	tests := []struct {
+		nucleus, coda string
+		expected      int
+	}{
+		{"oa", "", 0},   // Overridden
+		{"oa", "n", 1},  // oan is a different rhyme
+		{"OA", "", 0},   // Any case
+		{"uy", "nh", 0}, // Rhymes include the coda
+		{"uy", "", 1},
+	}
+	for _, tt := range tests {
+		if got := placement.Position("", []rune(tt.nucleus), tt.coda); got != tt.expected {
+			t.Errorf("%s+%s: got %d, want %d", tt.nucleus, tt.coda, got, tt.expected)
+		}
+	}
+}
+
+func TestConfiguredEngine_ToneOverrides(t *testing.T) {
+	config := DefaultConfig()
+	config.ToneOverrides = map[string]int{"oa": 0}
+	engine := NewConfiguredEngine(config)
+
+	typeKeys(engine.CompositionEngine, "hoaf", 0)
+	if got := engine.GetPreedit(); got != "hòa" {
+		t.Errorf("got %q, want %q", got, "hòa")
+	}
+
+	engine.Reset()
+	typeKeys(engine.CompositionEngine, "khoer", 0)
+	if got := engine.GetPreedit(); got != "khoẻ" {
+		t.Errorf("got %q, want %q", got, "khoẻ")
+	}
+}
+
+func TestTonePlacement_Allocs(t *testing.T) {
+	placement := TonePlacement{Overrides: map[string]int{"oa": 0}}
+	nucleus := []rune("ươi")
+	allocs := testing.AllocsPerRun(100, func() {
+		placement.Position("ng", nucleus, "")
+		placement.Position("h", nucleus[:2], "ng")
+	})
+	if allocs != 0 {
+		t.Errorf("Position allocates %.1f times, want 0", allocs)
+	}
+}
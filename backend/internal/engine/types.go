@@ -9,9 +9,63 @@ type KeyEvent struct {
 
 // ProcessResult contains the output from processing a key event.
 type ProcessResult struct {
-	Handled    bool   // Whether the key was consumed by the engine
-	CommitText string // Text to commit to the application
-	Preedit    string // Current preedit/composition string
+	Handled           bool                // Whether the key was consumed by the engine
+	CommitText        string              // Text to commit to the application
+	Preedit           string              // Current preedit/composition string
+	Segments          []PreeditSegment    // Preedit split into runs with display attributes
+	Cursor            int                 // Caret position within Preedit, in runes
+	Candidates        []string            // Candidate list (empty when there is nothing to choose)
+	DeleteSurrounding SurroundingDeletion // Text around the caret the frontend should delete
+	Mode              InputMode           // Current input mode (for indicators)
+}
+
+// KeyStats describes the work the engine did for the last key event. It is
+// meant for metrics and recordings and does not affect the result.
+type KeyStats struct {
+	Edited   bool // The key applied a mark, tone, stroke or vowel, or reverted one
+	Reverted bool // The key reverted the modifier typed before it
+	Rejected bool // The validator turned the key's mark or tone into a letter
+	Replayed int  // Keystrokes reduced again to rebuild the word (0 when none were)
+}
+
+// PreeditAttr is a bit set describing how a preedit segment is rendered.
+type PreeditAttr uint32
+
+// Preedit attribute flags.
+const (
+	PreeditAttrNone      PreeditAttr = 0
+	PreeditAttrUnderline PreeditAttr = 1 << 0 // Regular composing text
+	PreeditAttrHighlight PreeditAttr = 1 << 1 // Text the validator rejected
+)
+
+// PreeditSegment is a run of preedit text sharing the same attributes.
+// Concatenating the Text of all segments yields ProcessResult.Preedit.
+type PreeditSegment struct {
+	Text string
+	Attr PreeditAttr
+}
+
+// SurroundingDeletion asks the frontend to delete already committed text
+// around the caret before applying the commit.
+type SurroundingDeletion struct {
+	Offset int // Start of the range relative to the caret, in runes
+	Length int // Number of runes to delete (0 means nothing to delete)
+}
+
+// InputMode is the typing mode shown by mode indicators.
+type InputMode int
+
+const (
+	ModeVietnamese InputMode = iota // Keys are composed into Vietnamese
+	ModeEnglish                     // Keys pass through untouched
+)
+
+// String returns the short indicator label for the mode.
+func (m InputMode) String() string {
+	if m == ModeEnglish {
+		return "en"
+	}
+	return "vi"
 }
 
 // Modifier flags for keyboard state.
@@ -20,8 +74,9 @@ const (
 	ModShift   uint32 = 1 << 0
 	ModLock    uint32 = 1 << 1 // Caps Lock
 	ModControl uint32 = 1 << 2
-	ModMod1    uint32 = 1 << 3 // Alt
-	ModMod4    uint32 = 1 << 6 // Super/Windows key
+	ModMod1    uint32 = 1 << 3  // Alt
+	ModMod4    uint32 = 1 << 6  // Super/Windows key
+	ModRelease uint32 = 1 << 30 // Key release event (IBus convention)
 )
 
 // Common keysym values for Vietnamese input.
@@ -32,6 +87,24 @@ const (
 	KeySpace     uint32 = 0x0020
 	KeyTab       uint32 = 0xff09
 	KeyDelete    uint32 = 0xffff
+	KeyHome      uint32 = 0xff50
+	KeyLeft      uint32 = 0xff51
+	KeyUp        uint32 = 0xff52
+	KeyRight     uint32 = 0xff53
+	KeyDown      uint32 = 0xff54
+	KeyPageUp    uint32 = 0xff55
+	KeyPageDown  uint32 = 0xff56
+	KeyEnd       uint32 = 0xff57
+
+	// Modifier keys
+	KeyShiftL   uint32 = 0xffe1
+	KeyShiftR   uint32 = 0xffe2
+	KeyControlL uint32 = 0xffe3
+	KeyControlR uint32 = 0xffe4
+	KeyAltL     uint32 = 0xffe9
+	KeyAltR     uint32 = 0xffea
+	KeySuperL   uint32 = 0xffeb
+	KeySuperR   uint32 = 0xffec
 
 	// Lowercase letters
 	KeyA uint32 = 0x0061
@@ -70,15 +143,45 @@ const (
 )
 
 // Syllable represents a Vietnamese syllable being composed.
+// The nucleus is stored as base vowels with one mark per vowel, so "người"
+// has Nucleus "uoi" and Marks {VowelHorn, VowelHorn, VowelNone}.
 type Syllable struct {
-	Raw               string    // Raw input characters
-	Onset             string    // Initial consonant(s) - phụ âm đầu
-	Nucleus           string    // Vowel cluster - nguyên âm
-	Coda              string    // Final consonant(s) - phụ âm cuối
-	ToneMark          ToneMark  // Tone mark position
-	VowelMark         VowelMark // Vowel modification
-	Consumed          int       // How many characters from Raw were accounted for
-	ConsumedModifiers int       // How many modifier keys were used in transformation
+	LastKey  rune        // Last keystroke typed, 0 before the first
+	Onset    string      // Initial consonant(s) - phụ âm đầu
+	Nucleus  string      // Vowel cluster as base vowels - nguyên âm
+	Marks    []VowelMark // Vowel mark of each nucleus vowel (missing = VowelNone)
+	Coda     string      // Final consonant(s) - phụ âm cuối
+	ToneMark ToneMark    // Tone mark position
+	Consumed int         // How many letters form the syllable; the rest is shown as typed
+
+	Tones TonePlacement // Which vowel takes the tone; the zero value is the traditional rule
+}
+
+// EditKind is the kind of change a keystroke makes to the composition.
+type EditKind int
+
+const (
+	EditLetter      EditKind = iota // Append the key as a letter
+	EditTone                        // Set the tone (set-tone)
+	EditMark                        // Put a mark on a nucleus vowel (mark-vowel)
+	EditStroke                      // Turn the onset d into đ (stroke-d)
+	EditInsertVowel                 // Append a marked vowel (Telex w as ư)
+)
+
+// MarkTargets maps the lowercase base vowels a mark key can change to the
+// mark each of them receives.
+type MarkTargets map[rune]VowelMark
+
+// Edit is the abstract operation an input method emits for a keystroke.
+// The engine reduces the keystroke log by applying edits in order, so every
+// input method gets the same backspace, revert and validation behavior.
+type Edit struct {
+	Kind    EditKind
+	Tone    ToneMark    // EditTone: tone to set
+	Mark    VowelMark   // EditMark with a Vowel index, EditInsertVowel: mark to put on the vowel
+	Vowel   int         // EditMark: nucleus index of the vowel, or -1 to let the grammar pick from Targets
+	Targets MarkTargets // EditMark with Vowel -1: vowels the key can change
+	Char    rune        // EditInsertVowel: base vowel to append
 }
 
 // Engine is the main interface for input method engines.
@@ -104,9 +207,9 @@ type InputMethod interface {
 	// Name returns the name of the input method (e.g., "Telex", "VNI").
 	Name() string
 
-	// ProcessChar processes a character and returns the transformation.
-	// Returns (transformed string, tone mark, vowel mark, consumed).
-	ProcessChar(char rune, current *Syllable) (string, ToneMark, VowelMark, bool)
+	// ProcessChar returns the edit a key makes to the current syllable.
+	// Keys that change nothing are returned as EditLetter.
+	ProcessChar(char rune, current *Syllable) Edit
 
 	// IsToneKey checks if the character is used for tone marking.
 	IsToneKey(char rune) bool
@@ -119,6 +222,10 @@ type InputMethod interface {
 
 	// GetVowelMark returns the vowel mark for a given character.
 	GetVowelMark(char rune) VowelMark
+
+	// ModifierKeys returns the lower-case keys that can change a syllable
+	// instead of typing a letter. Other keys skip ProcessChar.
+	ModifierKeys() string
 }
 
 // OutputFormat defines the interface for different output encodings.
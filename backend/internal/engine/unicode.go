@@ -1,5 +1,7 @@
 package engine
 
+import "unicode/utf8"
+
 // UnicodeFormat implements OutputFormat for Unicode output.
 type UnicodeFormat struct{}
 
@@ -8,6 +10,10 @@ func NewUnicodeFormat() *UnicodeFormat {
 	return &UnicodeFormat{}
 }
 
+func init() {
+	RegisterOutputFormat("Unicode", func() OutputFormat { return NewUnicodeFormat() })
+}
+
 // Name returns the format name.
 func (u *UnicodeFormat) Name() string {
 	return "Unicode"
@@ -62,12 +68,7 @@ var unicodeVowelMarks = map[rune]map[VowelMark]rune{
 
 // ApplyTone applies a tone mark to a vowel.
 func (u *UnicodeFormat) ApplyTone(vowel rune, tone ToneMark) string {
-	if tones, ok := unicodeVowelTones[vowel]; ok {
-		if result, ok := tones[tone]; ok {
-			return string(result)
-		}
-	}
-	return string(vowel)
+	return string(toneVowel(vowel, tone))
 }
 
 // ApplyVowelMark applies a vowel mark (hat, breve, horn) to a character.
@@ -89,149 +90,32 @@ func (u *UnicodeFormat) Compose(syllable *Syllable) string {
 		return ""
 	}
 
-	result := syllable.Onset
-
-	// Find the position to place the tone mark
-	nucleus := []rune(syllable.Nucleus)
-	tonePos := findTonePosition(nucleus, syllable.Coda)
-	lastVowelIdx := len(nucleus) - 1
+	// Apply each vowel's own mark, then the tone
+	var nucleusBuf [8]rune
+	nucleus := nucleusBuf[:0]
+	for _, r := range syllable.Nucleus {
+		nucleus = append(nucleus, applyMark(r, markAt(syllable.Marks, len(nucleus))))
+	}
+	tonePos := syllable.Tones.Position(syllable.Onset, nucleus, syllable.Coda)
 
+	var buf [64]byte
+	result := append(buf[:0], syllable.Onset...)
 	for i, r := range nucleus {
-		// Apply vowel mark ONLY to the last vowel (the one being modified)
-		// Exception: for uo+w patterns, both vowels are modified (handled in updateSyllableStructure)
-		modified := r
-		if i == lastVowelIdx && syllable.VowelMark != VowelNone {
-			// Only apply vowel mark to the last vowel
-			if marks, ok := unicodeVowelMarks[r]; ok {
-				if result, ok := marks[syllable.VowelMark]; ok {
-					modified = result
-				}
-			}
-		}
-
-		// Apply tone mark at the correct position
 		if i == tonePos {
-			result += u.ApplyTone(modified, syllable.ToneMark)
-		} else {
-			result += string(modified)
+			r = toneVowel(r, syllable.ToneMark)
 		}
+		result = utf8.AppendRune(result, r)
 	}
-
-	result += syllable.Coda
-	return result
-}
-
-// findTonePosition determines where to place the tone mark in a vowel cluster.
-// Uses the traditional (old) rule by default.
-// Vietnamese tone placement rules (in order of priority):
-// 1. If there's a marked vowel (ă, â, ê, ô, ơ, ư), put tone on it
-// 2. If syllable has 'oa', 'oe', 'oo', 'uy' -> tone on the second vowel
-// 3. If syllable has 'ua', 'uô', 'ưa', 'ươ' -> tone on the second vowel
-// 4. If there's a coda -> tone on the vowel before last (penultimate vowel rule)
-// 5. If no coda and 2+ vowels -> tone on the second vowel
-// 6. Otherwise, tone on the only vowel
-func findTonePosition(nucleus []rune, coda string) int {
-	return findTonePositionWithRule(nucleus, coda, ToneRuleOld)
+	result = append(result, syllable.Coda...)
+	return string(result)
 }
 
-// findTonePositionWithRule determines where to place the tone mark with configurable rule
-// rule: ToneRuleOld (traditional) or ToneRuleNew (modern)
-func findTonePositionWithRule(nucleus []rune, coda string, rule ToneRule) int {
-	n := len(nucleus)
-	if n == 0 {
-		return 0
-	}
-	if n == 1 {
-		return 0
-	}
-
-	// Rule 1: Find marked vowels (these get priority for tone placement)
-	// If there are MULTIPLE marked vowels (like ươ), use the LAST one
-	// Exception: 'iê', 'uô', 'ươ' - tone on the marked vowel (ê, ô, ơ)
-	markedPositions := []int{}
-	for i, r := range nucleus {
-		if isMarkedVowel(r) {
-			markedPositions = append(markedPositions, i)
-		}
-	}
-
-	if len(markedPositions) > 0 {
-		// For patterns with multiple marked vowels (like 'ươ'), use the LAST one
-		// This gives 'ơ' priority over 'ư' in 'người'
-		return markedPositions[len(markedPositions)-1]
-	}
-
-	// Rule 2: For 'oa', 'oe', 'uy' patterns without coda -> second vowel
-	if n == 2 && coda == "" {
-		first := nucleus[0]
-		second := nucleus[1]
-
-		// 'oa', 'oă', 'oe' -> second vowel
-		if (first == 'o' || first == 'O') &&
-			(second == 'a' || second == 'A' || second == 'ă' || second == 'Ă' ||
-				second == 'e' || second == 'E') {
-			return 1
-		}
-
-		// 'uy' -> second vowel
-		if (first == 'u' || first == 'U') && (second == 'y' || second == 'Y') {
-			return 1
-		}
+// toneVowel returns vowel with tone applied, or vowel if it takes no tone.
+func toneVowel(vowel rune, tone ToneMark) rune {
+	if toned, ok := unicodeVowelTones[vowel][tone]; ok {
+		return toned
 	}
-
-	// Rule 3: For complex vowel pairs without coda
-	// The difference between old and new rule:
-	// Old rule (quy tắc cũ): hoà, của, mùa - tone on FIRST vowel
-	// New rule (quy tắc mới): hòa, của, mùa - tone on SECOND vowel (last 'a')
-	if n >= 2 && coda == "" {
-		first := nucleus[0]
-		second := nucleus[1]
-
-		// 'ia' without coda
-		if (first == 'i' || first == 'I') && (second == 'a' || second == 'A') {
-			if rule == ToneRuleNew {
-				return 1 // Modern: nghiã (on 'a')
-			}
-			return 0 // Traditional: nghĩa (on 'i')
-		}
-
-		// 'ua', 'ưa' without coda
-		if (first == 'u' || first == 'U' || first == 'ư' || first == 'Ư') &&
-			(second == 'a' || second == 'A') {
-			if rule == ToneRuleNew {
-				return 1 // Modern: mùa -> mùa (on 'a')
-			}
-			return 0 // Traditional: mùa (on 'u')
-		}
-
-		// 'iê', 'uô', 'ươ' always -> the marked vowel (handled in rule 1)
-	}
-
-	// Rule 4: With coda, tone goes on the last vowel that can take a tone
-	// For 2 vowels + coda (like 'oat'), put tone on first vowel
-	// For 3 vowels + coda (like 'uyen'), put tone on middle vowel
-	if coda != "" {
-		if n == 2 {
-			return 0 // First vowel: oát, oàn, etc.
-		}
-		if n >= 3 {
-			return 1 // Middle vowel: uyến, etc.
-		}
-	}
-
-	// Rule 5: Without coda, 2 vowels - need to determine which vowel gets the tone
-	// 'ao', 'au', 'ay', 'ai', 'eo', 'eu' -> FIRST vowel (not second!)
-	// This is the default case after 'oa', 'oe', 'uy', 'ia', 'ua', 'ưa' were handled above
-	if n == 2 {
-		return 0 // First vowel for 'ao', 'au', 'ay', etc.
-	}
-
-	// Rule 6: 3+ vowels without coda -> middle vowel
-	if n >= 3 {
-		return 1
-	}
-
-	return 0
+	return vowel
 }
 
 // isMarkedVowel checks if a vowel has a diacritic mark (not tone)
@@ -243,16 +127,28 @@ func isMarkedVowel(r rune) bool {
 	return false
 }
 
-// GetBaseVowel returns the base form of a vowel (without tone marks).
-func GetBaseVowel(r rune) (rune, ToneMark) {
-	// Check each base vowel's tone map
+// tonedVowel is a vowel with a tone split into its base and tone.
+type tonedVowel struct {
+	base rune
+	tone ToneMark
+}
+
+// unicodeTonedVowels is the reverse of unicodeVowelTones: ấ -> {â, sắc}.
+var unicodeTonedVowels = func() map[rune]tonedVowel {
+	reverse := make(map[rune]tonedVowel)
 	for base, tones := range unicodeVowelTones {
-		for tone, char := range tones {
-			if char == r {
-				return base, tone
-			}
+		for tone, toned := range tones {
+			reverse[toned] = tonedVowel{base, tone}
 		}
 	}
+	return reverse
+}()
+
+// GetBaseVowel returns the base form of a vowel (without tone marks).
+func GetBaseVowel(r rune) (rune, ToneMark) {
+	if tv, ok := unicodeTonedVowels[r]; ok {
+		return tv.base, tv.tone
+	}
 	return r, ToneNone
 }
 
@@ -3,6 +3,7 @@ package engine
 import (
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // ValidInitials are valid Vietnamese initial consonants (phụ âm đầu)
@@ -31,18 +32,242 @@ var validFinals = map[string]bool{
 // SpellingRules defines invalid combinations that need correction
 // key: invalid pattern, value: what should be used instead
 var spellingRules = map[string]string{
-	// c + (e,i,y) → should use k
-	"ce": "ke", "ci": "ki", "cy": "ky",
-	// k + (a,o,u) → should use c
-	"ka": "ca", "ko": "co", "ku": "cu",
-	// g + e → should use gh
-	"ge": "ghe",
-	// ng + (e,i) → should use ngh
-	"nge": "nghe", "ngi": "nghi",
-	// gh + (a,o,u) → should use g
-	"gha": "ga", "gho": "go", "ghu": "gu",
-	// ngh + (a,o,u) → should use ng
-	"ngha": "nga", "ngho": "ngo", "nghu": "ngu",
+	// c + (e,ê,i,y) → should use k
+	"ce": "ke", "cê": "kê", "ci": "ki", "cy": "ky",
+	// k + (a,ă,â,o,ô,ơ,u,ư) → should use c
+	"ka": "ca", "kă": "că", "kâ": "câ", "ko": "co", "kô": "cô", "kơ": "cơ", "ku": "cu", "kư": "cư",
+	// g + (e,ê) → should use gh
+	"ge": "ghe", "gê": "ghê",
+	// ng + (e,ê,i) → should use ngh
+	"nge": "nghe", "ngê": "nghê", "ngi": "nghi",
+	// gh + (a,ă,â,o,ô,ơ,u,ư) → should use g
+	"gha": "ga", "ghă": "gă", "ghâ": "gâ", "gho": "go", "ghô": "gô", "ghơ": "gơ", "ghu": "gu", "ghư": "gư",
+	// ngh + (a,ă,â,o,ô,ơ,u,ư) → should use ng
+	"ngha": "nga", "nghă": "ngă", "nghâ": "ngâ", "ngho": "ngo", "nghô": "ngô", "nghơ": "ngơ", "nghu": "ngu", "nghư": "ngư",
+}
+
+// codaSet is a set of consonant codas
+type codaSet uint8
+
+const (
+	codaC codaSet = 1 << iota
+	codaCH
+	codaM
+	codaN
+	codaNG
+	codaNH
+	codaP
+	codaT
+)
+
+// Common coda sets
+const (
+	codasPlain = codaC | codaM | codaN | codaNG | codaP | codaT // No ch/nh
+	codasAll   = codasPlain | codaCH | codaNH
+	codasStop  = codaC | codaCH | codaP | codaT // Only sắc and nặng
+)
+
+var codaBits = map[string]codaSet{
+	"c": codaC, "ch": codaCH, "m": codaM, "n": codaN,
+	"ng": codaNG, "nh": codaNH, "p": codaP, "t": codaT,
+}
+
+// toneFitsCoda reports whether a tone can mark a syllable with the given
+// coda: syllables closed by c, ch, p or t only take sắc or nặng (cát, cạt).
+func toneFitsCoda(tone ToneMark, coda string) bool {
+	var buf [8]byte
+	if codaBits[string(lowerInto(buf[:0], coda))]&codasStop == 0 {
+		return true
+	}
+	return tone == ToneSac || tone == ToneNang
+}
+
+// clusterRule describes where a written vowel cluster may appear
+type clusterRule struct {
+	open   bool     // May end the syllable without a consonant coda
+	codas  codaSet  // Consonant codas it accepts
+	onsets []string // Only after these onsets (nil = any onset)
+}
+
+// vowelClusters lists the written Vietnamese vowel clusters (lowercase, with
+// vowel marks, no tone). Semivowel finals (i, y, o, u) belong to the cluster
+// because the parser keeps them in the nucleus; qu and gi are parsed as q and
+// g followed by the vowel.
+var vowelClusters = map[string]clusterRule{
+	"a": {open: true, codas: codasAll}, "ai": {open: true}, "ao": {open: true},
+	"au": {open: true}, "ay": {open: true},
+	"ă": {codas: codasPlain},
+	"â": {codas: codasPlain}, "âu": {open: true}, "ây": {open: true},
+	"e": {open: true, codas: codasPlain}, "eo": {open: true},
+	"ê": {open: true, codas: codaCH | codaM | codaN | codaNH | codaP | codaT}, "êu": {open: true},
+	"i":  {open: true, codas: codaCH | codaM | codaN | codaNH | codaP | codaT},
+	"ia": {open: true}, "iu": {open: true},
+	"iê": {codas: codasPlain}, "iêu": {open: true},
+	"o": {open: true, codas: codasPlain}, "oi": {open: true},
+	"oa": {open: true, codas: codasAll}, "oai": {open: true}, "oay": {open: true},
+	"oă": {codas: codaC | codaM | codaN | codaNG | codaT},
+	"oe": {open: true, codas: codaN | codaT}, "oeo": {open: true},
+	"oo": {codas: codaC | codaNG},
+	"ô":  {open: true, codas: codasPlain}, "ôi": {open: true},
+	"ơ": {open: true, codas: codaM | codaN | codaP | codaT}, "ơi": {open: true},
+	"u": {open: true, codas: codasPlain}, "ui": {open: true},
+	"ua": {open: true},
+	"uă": {codas: codaC | codaM | codaN | codaNG | codaT, onsets: []string{"q"}},
+	"uâ": {codas: codaN | codaNG | codaT}, "uây": {open: true},
+	"ue": {open: true, codas: codaN | codaT, onsets: []string{"q"}},
+	"uê": {open: true, codas: codaCH | codaNH},
+	"uô": {codas: codaC | codaM | codaN | codaNG | codaT}, "uôi": {open: true},
+	"uơ":  {open: true, onsets: []string{"h", "kh", "q", "th"}},
+	"uy":  {open: true, codas: codaCH | codaN | codaNH | codaP | codaT},
+	"uya": {open: true}, "uyu": {open: true},
+	"uyê": {codas: codaN | codaT},
+	"ư":   {open: true, codas: codaC | codaM | codaNG | codaT}, "ưi": {open: true}, "ưu": {open: true},
+	"ưa": {open: true},
+	"ươ": {codas: codasPlain}, "ươi": {open: true}, "ươu": {open: true},
+	"y":  {open: true},
+	"yê": {codas: codaM | codaN | codaT}, "yêu": {open: true},
+}
+
+// maxClusterVowels is the length of the longest cluster in vowelClusters.
+const maxClusterVowels = 3
+
+// fit grades how well a vowel cluster matches an onset and coda
+type fit int
+
+const (
+	fitNone     fit = iota // Not a Vietnamese syllable
+	fitPrefix              // Valid once a consonant coda is typed
+	fitComplete            // Valid as it stands
+)
+
+// clusterFit checks a written vowel cluster against the grammar table.
+func clusterFit(onset, cluster, coda string) fit {
+	var buf [4 * maxClusterVowels]byte
+	return clusterKeyFit(onset, lowerInto(buf[:0], cluster), coda)
+}
+
+// markedClusterFit is clusterFit for base vowels with per-vowel marks.
+func markedClusterFit(onset string, nucleus []rune, marks []VowelMark, coda string) fit {
+	var buf [4 * maxClusterVowels]byte
+	key := buf[:0]
+	for i, r := range nucleus {
+		key = utf8.AppendRune(key, applyMark(unicode.ToLower(r), markAt(marks, i)))
+	}
+	return clusterKeyFit(onset, key, coda)
+}
+
+// clusterKeyFit checks a lower-case cluster against the grammar table. After
+// q and g the cluster may also be the u of qu or the i of gi followed by a
+// cluster of the table (quanh, giường).
+func clusterKeyFit(onset string, cluster []byte, coda string) fit {
+	var onsetBuf [16]byte
+	onsetLower := lowerInto(onsetBuf[:0], onset)
+
+	f := ruleFit(onsetLower, cluster, coda)
+	if rest, ok := onsetGlide(onsetLower, cluster); ok && f != fitComplete {
+		glide := append(onsetLower, cluster[0]) // qu or gi
+		if glideRest(glide, rest) {
+			f = max(f, ruleFit(glide, rest, coda))
+		}
+	}
+	return f
+}
+
+// onsetGlide returns the lower-case cluster without the u of qu or the i of
+// gi. The parser reads qu and gi as q and g followed by a vowel, so that
+// vowel is part of the nucleus (qua is q+ua, giống is g+iô).
+func onsetGlide(onset, cluster []byte) ([]byte, bool) {
+	if len(cluster) < 2 {
+		return nil, false
+	}
+	switch string(onset) {
+	case "q":
+		return cluster[1:], cluster[0] == 'u'
+	case "g":
+		return cluster[1:], cluster[0] == 'i'
+	}
+	return nil, false
+}
+
+// glideRest reports whether a cluster may follow the onset qu or gi. Their u
+// and i already hold the place of the medial o/u, so no second medial
+// follows (quoa, gioa, giuy). After qu, i stands alone (quít) and y is read
+// with its u (uy, uyê); gi is not followed by another i or y.
+func glideRest(onset, rest []byte) bool {
+	first, size := utf8.DecodeRune(rest)
+	switch first {
+	case 'o', 'u':
+		return string(onset) == "gi" && (size == len(rest) || string(rest[size:]) == "i")
+	case 'ô', 'ơ', 'ư':
+		return string(onset) == "gi"
+	case 'i':
+		return string(onset) == "qu" && size == len(rest)
+	case 'y':
+		return false
+	}
+	return true
+}
+
+// ruleFit checks a lower-case cluster against its grammar table entry.
+func ruleFit(onsetLower, cluster []byte, coda string) fit {
+	rule, ok := vowelClusters[string(cluster)]
+	if !ok {
+		return fitNone
+	}
+	if rule.onsets != nil && !containsKey(rule.onsets, onsetLower) {
+		return fitNone
+	}
+	// q is always written before a plain u
+	if string(onsetLower) == "q" && cluster[0] != 'u' {
+		return fitNone
+	}
+
+	if coda == "" {
+		if rule.open {
+			return fitComplete
+		}
+		if rule.codas != 0 {
+			return fitPrefix
+		}
+		return fitNone
+	}
+	var codaBuf [8]byte
+	if rule.codas&codaBits[string(lowerInto(codaBuf[:0], coda))] != 0 {
+		return fitComplete
+	}
+	return fitNone
+}
+
+// containsKey reports whether key is one of list.
+func containsKey(list []string, key []byte) bool {
+	for _, s := range list {
+		if s == string(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerInto appends the lower-case form of s to dst. Lookups keyed by the
+// result do not allocate, unlike strings.ToLower.
+func lowerInto(dst []byte, s string) []byte {
+	for _, r := range s {
+		dst = utf8.AppendRune(dst, unicode.ToLower(r))
+	}
+	return dst
+}
+
+// initialKey appends the lower-case form of an onset to dst with đ read as
+// d, the form isValidInitial checks.
+func initialKey(dst []byte, onset string) []byte {
+	for _, r := range onset {
+		r = unicode.ToLower(r)
+		if r == 'đ' {
+			r = 'd'
+		}
+		dst = utf8.AppendRune(dst, r)
+	}
+	return dst
 }
 
 // ValidationResult contains the result of syllable validation
@@ -69,13 +294,10 @@ func ValidateVietnamese(onset, nucleus, coda string) ValidationResult {
 	}
 	result.HasVowel = true
 
-	// Rule 2: Check initial consonant (if present)
+	// Rule 2: Check initial consonant (if present), reading đ as d
+	var buf [16]byte
 	if onset != "" {
-		onsetLower := strings.ToLower(onset)
-		// Remove đ for checking (it's valid)
-		onsetLower = strings.ReplaceAll(onsetLower, "đ", "d")
-
-		if !isValidInitial(onsetLower) {
+		if !isValidInitial(string(initialKey(buf[:0], onset))) {
 			result.Valid = false
 			result.Reason = "invalid_initial"
 			result.InitialValid = false
@@ -86,8 +308,7 @@ func ValidateVietnamese(onset, nucleus, coda string) ValidationResult {
 
 	// Rule 3: Check final consonant (if present)
 	if coda != "" {
-		codaLower := strings.ToLower(coda)
-		if !validFinals[codaLower] {
+		if !validFinals[string(lowerInto(buf[:0], coda))] {
 			result.Valid = false
 			result.Reason = "invalid_final"
 			result.FinalValid = false
@@ -98,8 +319,9 @@ func ValidateVietnamese(onset, nucleus, coda string) ValidationResult {
 
 	// Rule 4: Check spelling rules
 	if onset != "" && nucleus != "" {
-		combined := strings.ToLower(onset) + string(unicode.ToLower([]rune(nucleus)[0]))
-		if _, invalid := spellingRules[combined]; invalid {
+		first, _ := utf8.DecodeRuneInString(nucleus)
+		combined := utf8.AppendRune(lowerInto(buf[:0], onset), unicode.ToLower(first))
+		if _, invalid := spellingRules[string(combined)]; invalid {
 			result.Valid = false
 			result.Reason = "spelling_rule_violation"
 			result.SpellingOK = false
@@ -111,6 +333,29 @@ func ValidateVietnamese(onset, nucleus, coda string) ValidationResult {
 	return result
 }
 
+// ValidateSyllable validates a parsed syllable like ValidateVietnamese and
+// also checks its marked vowel cluster against the onset and coda. A cluster
+// that still needs a coda (tiê, ươ) counts as valid.
+func ValidateSyllable(s *Syllable) ValidationResult {
+	var buf [32]byte
+	cluster := buf[:0]
+	i := 0
+	for _, r := range s.Nucleus {
+		cluster = utf8.AppendRune(cluster, applyMark(r, markAt(s.Marks, i)))
+		i++
+	}
+	result := ValidateVietnamese(s.Onset, string(cluster), s.Coda)
+	if !result.Valid {
+		return result
+	}
+
+	if clusterFit(s.Onset, string(cluster), s.Coda) == fitNone {
+		result.Valid = false
+		result.Reason = "invalid_nucleus"
+	}
+	return result
+}
+
 // isValidInitial checks if a string is a valid Vietnamese initial
 func isValidInitial(s string) bool {
 	if s == "" {
@@ -137,10 +382,7 @@ func isValidInitial(s string) bool {
 // ValidateForModifier checks if the buffer should accept a modifier key
 // Returns true if the modifier should transform the text, false if it should be literal
 func (e *CompositionEngine) ValidateForModifier() bool {
-	syllable := e.buffer.syllable
-	if syllable == nil {
-		return false
-	}
+	syllable := &e.buffer.syllable
 
 	// Check if we have a valid Vietnamese structure
 	result := ValidateVietnamese(syllable.Onset, syllable.Nucleus, syllable.Coda)
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"strings"
+	"unicode"
+)
+
+// VIQRMethod implements the VIQR input method (RFC 1456). The diacritics
+// are typed as the ASCII marks VIQR writes after a vowel: ' ` ? ~ . for the
+// tones, ^ ( + for the vowel marks and dd for đ.
+type VIQRMethod struct{}
+
+// NewVIQRMethod creates a new VIQR input method.
+func NewVIQRMethod() *VIQRMethod {
+	return &VIQRMethod{}
+}
+
+func init() {
+	RegisterInputMethod("VIQR", func() InputMethod { return NewVIQRMethod() })
+}
+
+// Name returns the method name.
+func (v *VIQRMethod) Name() string {
+	return "VIQR"
+}
+
+// ModifierKeys returns the tone and vowel mark characters and d.
+func (v *VIQRMethod) ModifierKeys() string {
+	return "'`?~.^(+d"
+}
+
+// VIQR tone marks
+var viqrToneKeys = map[rune]ToneMark{
+	'\'': ToneSac,   // á
+	'`':  ToneHuyen, // à
+	'?':  ToneHoi,   // ả
+	'~':  ToneNga,   // ã
+	'.':  ToneNang,  // ạ
+}
+
+// VIQR vowel marks
+var viqrVowelKeys = map[rune]VowelMark{
+	'^': VowelHat,   // â, ê, ô
+	'(': VowelBreve, // ă
+	'+': VowelHorn,  // ơ, ư
+}
+
+// IsToneKey checks if the character is a VIQR tone mark.
+func (v *VIQRMethod) IsToneKey(char rune) bool {
+	_, ok := viqrToneKeys[char]
+	return ok
+}
+
+// GetToneMark returns the tone mark for a VIQR character.
+func (v *VIQRMethod) GetToneMark(char rune) ToneMark {
+	return viqrToneKeys[char]
+}
+
+// IsVowelModifier checks if the character is a VIQR vowel mark or d.
+func (v *VIQRMethod) IsVowelModifier(char rune) bool {
+	_, ok := viqrVowelKeys[char]
+	return ok || unicode.ToLower(char) == 'd'
+}
+
+// GetVowelMark returns the vowel mark for a VIQR character.
+func (v *VIQRMethod) GetVowelMark(char rune) VowelMark {
+	if unicode.ToLower(char) == 'd' {
+		return VowelDBar
+	}
+	return viqrVowelKeys[char]
+}
+
+// ProcessChar returns the edit a key makes according to VIQR rules.
+func (v *VIQRMethod) ProcessChar(char rune, current *Syllable) Edit {
+	if current == nil {
+		return Edit{}
+	}
+
+	// Tone marks need a vowel, otherwise they are punctuation
+	if v.IsToneKey(char) {
+		if current.Nucleus != "" {
+			return Edit{Kind: EditTone, Tone: v.GetToneMark(char)}
+		}
+		return Edit{}
+	}
+
+	// dd strokes the onset d
+	if unicode.ToLower(char) == 'd' {
+		if unicode.ToLower(current.LastKey) == 'd' && current.Nucleus == "" &&
+			strings.ContainsAny(current.Onset, "dD") {
+			return Edit{Kind: EditStroke}
+		}
+		return Edit{}
+	}
+
+	// Vowel marks go where the grammar allows, like VNI 6/7/8
+	if mark, ok := viqrVowelKeys[char]; ok {
+		targets := targetsForMark(mark)
+		if hasMarkTarget([]rune(current.Nucleus), targets) {
+			return Edit{Kind: EditMark, Vowel: -1, Targets: targets}
+		}
+	}
+	return Edit{}
+}
@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"strings"
 	"unicode"
 )
 
@@ -13,11 +14,20 @@ func NewVNIMethod() *VNIMethod {
 	return &VNIMethod{}
 }
 
+func init() {
+	RegisterInputMethod("VNI", func() InputMethod { return NewVNIMethod() })
+}
+
 // Name returns the method name.
 func (v *VNIMethod) Name() string {
 	return "VNI"
 }
 
+// ModifierKeys returns the digits used for tones and marks.
+func (v *VNIMethod) ModifierKeys() string {
+	return "0123456789"
+}
+
 // VNI key mappings for tone marks
 // 1: sắc    2: huyền   3: hỏi   4: ngã   5: nặng   0: remove
 var vniToneKeys = map[rune]ToneMark{
@@ -38,28 +48,6 @@ var vniVowelKeys = map[rune]VowelMark{
 	'9': VowelDBar,  // Stroke: đ
 }
 
-// vowelTargetsForMark defines which vowels can receive each mark type
-var vniVowelTargets = map[VowelMark][]rune{
-	VowelHat:   {'a', 'A', 'e', 'E', 'o', 'O'}, // â, ê, ô
-	VowelHorn:  {'o', 'O', 'u', 'U'},           // ơ, ư
-	VowelBreve: {'a', 'A'},                     // ă
-	VowelDBar:  {'d', 'D'},                     // đ
-}
-
-// vniTransformations maps (vowel + mark) -> result
-var vniTransformations = map[rune]map[VowelMark]rune{
-	'a': {VowelHat: 'â', VowelBreve: 'ă'},
-	'A': {VowelHat: 'Â', VowelBreve: 'Ă'},
-	'e': {VowelHat: 'ê'},
-	'E': {VowelHat: 'Ê'},
-	'o': {VowelHat: 'ô', VowelHorn: 'ơ'},
-	'O': {VowelHat: 'Ô', VowelHorn: 'Ơ'},
-	'u': {VowelHorn: 'ư'},
-	'U': {VowelHorn: 'Ư'},
-	'd': {VowelDBar: 'đ'},
-	'D': {VowelDBar: 'Đ'},
-}
-
 // IsToneKey checks if the character is a VNI tone key (1-5, 0).
 func (v *VNIMethod) IsToneKey(char rune) bool {
 	_, ok := vniToneKeys[char]
@@ -88,22 +76,19 @@ func (v *VNIMethod) GetVowelMark(char rune) VowelMark {
 	return VowelNone
 }
 
-// ProcessChar processes a character according to VNI rules.
-// Returns (transformed string, tone mark, vowel mark, consumed)
-func (v *VNIMethod) ProcessChar(char rune, current *Syllable) (string, ToneMark, VowelMark, bool) {
+// ProcessChar returns the edit a key makes according to VNI rules.
+func (v *VNIMethod) ProcessChar(char rune, current *Syllable) Edit {
 	if current == nil {
-		return string(char), ToneNone, VowelNone, false
+		return Edit{}
 	}
 
 	// Check for tone keys (1-5, 0)
 	if v.IsToneKey(char) {
-		tone := v.GetToneMark(char)
-		// Only apply tone if we have a vowel
+		// Only apply tone if we have a vowel, otherwise it is a literal number
 		if current.Nucleus != "" {
-			return "", tone, VowelNone, true
+			return Edit{Kind: EditTone, Tone: v.GetToneMark(char)}
 		}
-		// No vowel - treat as literal number
-		return string(char), ToneNone, VowelNone, false
+		return Edit{}
 	}
 
 	// Check for vowel modifier keys (6-9)
@@ -112,86 +97,22 @@ func (v *VNIMethod) ProcessChar(char rune, current *Syllable) (string, ToneMark,
 
 		// Handle đ (9 key)
 		if mark == VowelDBar {
-			// Find 'd' in onset or nucleus
-			if current.Onset != "" {
-				onset := []rune(current.Onset)
-				for i, r := range onset {
-					if r == 'd' || r == 'D' {
-						result := 'đ'
-						if unicode.IsUpper(r) {
-							result = 'Đ'
-						}
-						onset[i] = result
-						return string(result), ToneNone, VowelDBar, true
-					}
-				}
+			if strings.ContainsAny(current.Onset, "dD") {
+				return Edit{Kind: EditStroke}
 			}
-			// No 'd' found - treat as literal
-			return string(char), ToneNone, VowelNone, false
+			return Edit{}
 		}
 
-		// Handle vowel marks (6, 7, 8)
-		if current.Nucleus != "" {
-			nucleus := []rune(current.Nucleus)
-
-			// For VNI key 7 (horn), handle UO compound: uo -> ươ
-			if mark == VowelHorn && len(nucleus) >= 2 {
-				last := nucleus[len(nucleus)-1]
-				secondLast := nucleus[len(nucleus)-2]
-				if (unicode.ToLower(secondLast) == 'u') && (unicode.ToLower(last) == 'o') {
-					// Transform both: u -> ư, o -> ơ
-					var transformedResult string
-					for i, r := range nucleus {
-						if i == len(nucleus)-2 {
-							if unicode.IsUpper(r) {
-								transformedResult += "Ư"
-							} else {
-								transformedResult += "ư"
-							}
-						} else if i == len(nucleus)-1 {
-							if unicode.IsUpper(r) {
-								transformedResult += "Ơ"
-							} else {
-								transformedResult += "ơ"
-							}
-						} else {
-							transformedResult += string(r)
-						}
-					}
-					return transformedResult, ToneNone, VowelHorn, true
-				}
-			}
-
-			// Find last vowel that can accept this mark
-			for i := len(nucleus) - 1; i >= 0; i-- {
-				r := nucleus[i]
-				if transforms, ok := vniTransformations[r]; ok {
-					if result, found := transforms[mark]; found {
-						return string(result), ToneNone, mark, true
-					}
-				}
-			}
+		// Handle vowel marks (6, 7, 8). The engine decides which vowels get
+		// the mark (e.g. both in ươ, see resolveVowelMark).
+		targets := targetsForMark(mark)
+		if hasMarkTarget([]rune(current.Nucleus), targets) {
+			return Edit{Kind: EditMark, Vowel: -1, Targets: targets}
 		}
-
-		// Also check raw buffer for vowels not yet parsed
-		if current.Raw != "" {
-			rawRunes := []rune(current.Raw)
-			for i := len(rawRunes) - 1; i >= 0; i-- {
-				r := rawRunes[i]
-				if transforms, ok := vniTransformations[r]; ok {
-					if result, found := transforms[mark]; found {
-						return string(result), ToneNone, mark, true
-					}
-				}
-			}
-		}
-
-		// No suitable target - treat as literal number
-		return string(char), ToneNone, VowelNone, false
 	}
 
-	// Regular character - just pass through
-	return string(char), ToneNone, VowelNone, false
+	// Regular character or no suitable target - treat as literal
+	return Edit{}
 }
 
 // CanStartWord checks if a character can start a Vietnamese word.
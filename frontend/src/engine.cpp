@@ -5,7 +5,7 @@
 #include <vector>
 
 GoVietEngine::GoVietEngine(fcitx::Instance *instance)
-    : fcitx::InputMethodEngine() {
+    : fcitx::InputMethodEngine(), instance(instance) {
   DBusError err;
   dbus_error_init(&err);
   conn = dbus_bus_get(DBUS_BUS_SESSION, &err);
@@ -14,9 +14,33 @@ GoVietEngine::GoVietEngine(fcitx::Instance *instance)
     dbus_error_free(&err);
     conn = nullptr;
   }
+
+  // Give each input context its own composition and, with modepercontext,
+  // its own mode in the backend
+  eventHandlers.push_back(instance->watchEvent(
+      fcitx::EventType::InputContextFocusIn, fcitx::EventWatcherPhase::Default,
+      [this](fcitx::Event &event) {
+        auto *ic = static_cast<fcitx::InputContextEvent &>(event).inputContext();
+        if (this->instance->inputMethod(ic) == "goviet")
+          callBackend("FocusIn", contextId(ic));
+      }));
+  eventHandlers.push_back(instance->watchEvent(
+      fcitx::EventType::InputContextFocusOut, fcitx::EventWatcherPhase::Default,
+      [this](fcitx::Event &event) {
+        auto *ic = static_cast<fcitx::InputContextEvent &>(event).inputContext();
+        if (this->instance->inputMethod(ic) == "goviet")
+          callBackend("FocusOut", contextId(ic));
+      }));
+  eventHandlers.push_back(instance->watchEvent(
+      fcitx::EventType::InputContextDestroyed,
+      fcitx::EventWatcherPhase::Default, [this](fcitx::Event &event) {
+        auto *ic = static_cast<fcitx::InputContextEvent &>(event).inputContext();
+        sendBackend("DestroyContext", contextId(ic));
+      }));
 }
 
 GoVietEngine::~GoVietEngine() {
+  eventHandlers.clear();
   if (conn) {
     dbus_connection_unref(conn);
   }
@@ -34,13 +58,26 @@ std::vector<fcitx::InputMethodEntry> GoVietEngine::listInputMethods() {
 
 void GoVietEngine::keyEvent(const fcitx::InputMethodEntry &entry,
                             fcitx::KeyEvent &keyEvent) {
-  if (keyEvent.isRelease())
-    return;
-
   uint32_t sym = keyEvent.key().sym();
   uint32_t state = keyEvent.key().states();
   std::string preedit, commit;
 
+  if (keyEvent.isRelease()) {
+    // Forward releases (flagged like IBus, bit 30) only when the backend
+    // needs them to detect a modifier-only toggle chord such as Ctrl+Shift;
+    // otherwise they would double the round trips for nothing
+    if (!forwardReleases)
+      return;
+    callGoBackend(sym, state | (1u << 30), preedit, commit);
+    if (!commit.empty()) {
+      auto inputContext = keyEvent.inputContext();
+      inputContext->inputPanel().setClientPreedit(fcitx::Text());
+      inputContext->updatePreedit();
+      inputContext->commitString(commit);
+    }
+    return;
+  }
+
   // Call Go Backend
   bool handled = callGoBackend(sym, state, preedit, commit);
 
@@ -84,22 +121,133 @@ void GoVietEngine::reset(const fcitx::InputMethodEntry &,
 }
 
 void GoVietEngine::activate(const fcitx::InputMethodEntry &,
-                            fcitx::InputContextEvent &) {
-  // Reset on activate to ensure a clean state
+                            fcitx::InputContextEvent &event) {
+  // Switching to this input method sends no focus event
+  callBackend("FocusIn", contextId(event.inputContext()));
+  // Reset on activate to ensure a clean state. This is also the call that
+  // starts the backend through D-Bus activation when it is not running.
   resetBackend();
+  // The toggle hotkey may have changed since the last activation
+  forwardReleases = backendHasFeature("key-releases");
 }
 
-void GoVietEngine::resetBackend() {
+// The backend's name for an input context: its UUID in hex, which stays the
+// same for the life of the context and is never reused
+std::string GoVietEngine::contextId(fcitx::InputContext *ic) {
+  static const char digits[] = "0123456789abcdef";
+  std::string id;
+  for (uint8_t byte : ic->uuid()) {
+    id += digits[byte >> 4];
+    id += digits[byte & 0xf];
+  }
+  return id;
+}
+
+// Calls a backend method that takes a context id and waits for the reply,
+// so that the call runs before the key events that follow it
+void GoVietEngine::callBackend(const char *method, const std::string &id) {
   if (!conn)
     return;
 
   DBusMessage *msg = dbus_message_new_method_call(
-      "com.github.goviet.ime", "/Engine", "com.github.goviet.ime", "Reset");
+      "com.github.goviet.ime", "/Engine", "com.github.goviet.ime.Engine1",
+      method);
+  if (!msg)
+    return;
 
-  if (msg) {
-    dbus_connection_send(conn, msg, NULL);
-    dbus_message_unref(msg);
+  const char *id_cstr = id.c_str();
+  dbus_message_append_args(msg, DBUS_TYPE_STRING, &id_cstr, DBUS_TYPE_INVALID);
+  DBusMessage *reply =
+      dbus_connection_send_with_reply_and_block(conn, msg, 200, NULL);
+  dbus_message_unref(msg);
+  if (reply)
+    dbus_message_unref(reply);
+}
+
+// Like callBackend without waiting, for calls that no key event depends on
+void GoVietEngine::sendBackend(const char *method, const std::string &id) {
+  if (!conn)
+    return;
+
+  DBusMessage *msg = dbus_message_new_method_call(
+      "com.github.goviet.ime", "/Engine", "com.github.goviet.ime.Engine1",
+      method);
+  if (!msg)
+    return;
+
+  const char *id_cstr = id.c_str();
+  dbus_message_append_args(msg, DBUS_TYPE_STRING, &id_cstr, DBUS_TYPE_INVALID);
+  dbus_message_set_no_reply(msg, TRUE);
+  dbus_connection_send(conn, msg, NULL);
+  dbus_message_unref(msg);
+}
+
+// Asks the backend whether GetCapabilities lists the given feature
+bool GoVietEngine::backendHasFeature(const std::string &feature) {
+  if (!conn)
+    return false;
+
+  DBusMessage *msg = dbus_message_new_method_call(
+      "com.github.goviet.ime", "/Engine", "com.github.goviet.ime.Engine1",
+      "GetCapabilities");
+  if (!msg)
+    return false;
+
+  DBusMessage *reply =
+      dbus_connection_send_with_reply_and_block(conn, msg, 200, NULL);
+  dbus_message_unref(msg);
+  if (!reply)
+    return false;
+
+  // The reply is a{sv}; "features" holds an array of strings
+  bool found = false;
+  DBusMessageIter args, dict;
+  if (dbus_message_iter_init(reply, &args) &&
+      dbus_message_iter_get_arg_type(&args) == DBUS_TYPE_ARRAY) {
+    dbus_message_iter_recurse(&args, &dict);
+    for (; dbus_message_iter_get_arg_type(&dict) == DBUS_TYPE_DICT_ENTRY;
+         dbus_message_iter_next(&dict)) {
+      DBusMessageIter entry, variant, features;
+      const char *key = NULL;
+      dbus_message_iter_recurse(&dict, &entry);
+      dbus_message_iter_get_basic(&entry, &key);
+      if (!key || std::string(key) != "features")
+        continue;
+      dbus_message_iter_next(&entry);
+      dbus_message_iter_recurse(&entry, &variant);
+      if (dbus_message_iter_get_arg_type(&variant) != DBUS_TYPE_ARRAY)
+        continue;
+      dbus_message_iter_recurse(&variant, &features);
+      for (; dbus_message_iter_get_arg_type(&features) == DBUS_TYPE_STRING;
+           dbus_message_iter_next(&features)) {
+        const char *name = NULL;
+        dbus_message_iter_get_basic(&features, &name);
+        if (name && feature == name)
+          found = true;
+      }
+    }
   }
+  dbus_message_unref(reply);
+  return found;
+}
+
+// Waits for the reply like callBackend: the backend runs calls in any order,
+// and a Reset still in flight would clear the key typed after it
+void GoVietEngine::resetBackend() {
+  if (!conn)
+    return;
+
+  DBusMessage *msg = dbus_message_new_method_call(
+      "com.github.goviet.ime", "/Engine", "com.github.goviet.ime.Engine1",
+      "Reset");
+  if (!msg)
+    return;
+
+  DBusMessage *reply =
+      dbus_connection_send_with_reply_and_block(conn, msg, 200, NULL);
+  dbus_message_unref(msg);
+  if (reply)
+    dbus_message_unref(reply);
 }
 
 // This function keeps the original logic
@@ -113,7 +261,8 @@ bool GoVietEngine::callGoBackend(uint32_t keysym, uint32_t modifiers,
 
   DBusMessage *msg =
       dbus_message_new_method_call("com.github.goviet.ime", "/Engine",
-                                   "com.github.goviet.ime", "ProcessKey");
+                                   "com.github.goviet.ime.Engine1",
+                                   "ProcessKey");
 
   if (!msg)
     return false;
@@ -121,12 +270,36 @@ bool GoVietEngine::callGoBackend(uint32_t keysym, uint32_t modifiers,
   dbus_message_append_args(msg, DBUS_TYPE_UINT32, &keysym, DBUS_TYPE_UINT32,
                            &modifiers, DBUS_TYPE_INVALID);
 
-  DBusMessage *reply =
-      dbus_connection_send_with_reply_and_block(conn, msg, 200, &err);
+  // Wait for the reply ourselves rather than with
+  // dbus_connection_send_with_reply_and_block, which drops the body of error
+  // replies
+  DBusPendingCall *pending = NULL;
+  if (!dbus_connection_send_with_reply(conn, msg, &pending, 200) || !pending) {
+    dbus_message_unref(msg);
+    return false;
+  }
   dbus_message_unref(msg);
+  dbus_pending_call_block(pending);
+  DBusMessage *reply = dbus_pending_call_steal_reply(pending);
+  dbus_pending_call_unref(pending);
+  if (!reply)
+    return false;
 
-  if (dbus_error_is_set(&err)) {
-    dbus_error_free(&err);
+  if (dbus_message_get_type(reply) == DBUS_MESSAGE_TYPE_ERROR) {
+    // The backend recovered from an internal error and started the word
+    // over; commit the keys typed so far and let this key through
+    if (dbus_message_is_error(reply, "com.github.goviet.ime.Error.Internal")) {
+      char *message_cstr = NULL;
+      char *commit_cstr = NULL;
+      if (dbus_message_get_args(reply, &err, DBUS_TYPE_STRING, &message_cstr,
+                                DBUS_TYPE_STRING, &commit_cstr,
+                                DBUS_TYPE_INVALID)) {
+        commit = std::string(commit_cstr);
+      }
+      if (dbus_error_is_set(&err))
+        dbus_error_free(&err);
+    }
+    dbus_message_unref(reply);
     return false;
   }
 
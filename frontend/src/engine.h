@@ -2,9 +2,14 @@
 #define GOVIET_ENGINE_H
 
 #include <dbus/dbus.h>
+#include <fcitx-utils/handlertable.h>
 #include <fcitx/addonfactory.h>
+#include <fcitx/event.h>
+#include <fcitx/inputcontext.h>
 #include <fcitx/inputmethodengine.h>
 #include <fcitx/instance.h>
+#include <memory>
+#include <string>
 #include <vector>
 
 class GoVietEngine : public fcitx::InputMethodEngine {
@@ -21,10 +26,19 @@ public:
                 fcitx::InputContextEvent &event) override;
 
 private:
+  fcitx::Instance *instance;
   DBusConnection *conn;
+  std::vector<std::unique_ptr<fcitx::HandlerTableEntry<fcitx::EventHandler>>>
+      eventHandlers;
+  // Send key releases, for a modifier-only toggle hotkey (see activate)
+  bool forwardReleases = false;
   bool callGoBackend(uint32_t keysym, uint32_t modifiers, std::string &preedit,
                      std::string &commit);
   void resetBackend();
+  void callBackend(const char *method, const std::string &id);
+  void sendBackend(const char *method, const std::string &id);
+  static std::string contextId(fcitx::InputContext *ic);
+  bool backendHasFeature(const std::string &feature);
 };
 
 class GoVietEngineFactory : public fcitx::AddonFactory {